@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var accessDeniedConnectionsMetric = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sshesame_access_denied_connections_total",
+	Help: "Total number of connections rejected by the access list",
+})
+
+// accessList decides which source IPs are engaged. Denied networks take precedence over allowed ones,
+// and a non-empty allow list denies everything it doesn't contain.
+type accessList struct {
+	allow, deny []*net.IPNet
+}
+
+// parseNetworks parses CIDRs, taking bare IP addresses as networks of a single address.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// newAccessList returns the access list described by cfg, or nil if it doesn't restrict anything.
+func newAccessList(cfg accessListConfig) (*accessList, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 {
+		return nil, nil
+	}
+	allow, err := parseNetworks(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access list allow entry: %w", err)
+	}
+	deny, err := parseNetworks(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access list deny entry: %w", err)
+	}
+	return &accessList{allow, deny}, nil
+}
+
+// check returns why ip is denied, or an empty string if it's allowed.
+func (list *accessList) check(ip net.IP) string {
+	for _, network := range list.deny {
+		if network.Contains(ip) {
+			return fmt.Sprintf("source in denied network %v", network)
+		}
+	}
+	if len(list.allow) == 0 {
+		return ""
+	}
+	for _, network := range list.allow {
+		if network.Contains(ip) {
+			return ""
+		}
+	}
+	return "source not in an allowed network"
+}
+
+// accessListListener closes connections from denied source IPs before the SSH handshake.
+type accessListListener struct {
+	net.Listener
+	list *accessList
+	cfg  *config
+}
+
+func newAccessListListener(listener net.Listener, cfg *config) net.Listener {
+	return &accessListListener{listener, cfg.accessList, cfg}
+}
+
+func (listener *accessListListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		reason := "source address unknown"
+		if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				reason = listener.list.check(ip)
+			}
+		}
+		if reason == "" {
+			return conn, nil
+		}
+		accessDeniedConnectionsMetric.Inc()
+		connContext{ConnMetadata: rawConnMetadata{conn}, cfg: listener.cfg}.logEvent(accessDeniedLog{Reason: reason})
+		conn.Close()
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessList(t *testing.T) {
+	list, err := newAccessList(accessListConfig{
+		Allow: []string{"192.0.2.0/24", "2001:db8::/32", "203.0.113.7"},
+		Deny:  []string{"192.0.2.128/25", "2001:db8:bad::/48"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, expected := range map[string]string{
+		"192.0.2.1":       "",
+		"192.0.2.200":     "source in denied network 192.0.2.128/25",
+		"203.0.113.7":     "",
+		"203.0.113.8":     "source not in an allowed network",
+		"2001:db8::1":     "",
+		"2001:db8:bad::1": "source in denied network 2001:db8:bad::/48",
+		"::1":             "source not in an allowed network",
+	} {
+		if reason := list.check(net.ParseIP(ip)); reason != expected {
+			t.Errorf("check(%v)=%q, want %q", ip, reason, expected)
+		}
+	}
+	if _, err := newAccessList(accessListConfig{Deny: []string{"192.0.2.0/33"}}); err == nil {
+		t.Errorf("err=nil, want an invalid CIDR rejected")
+	}
+	if list, err := newAccessList(accessListConfig{}); list != nil || err != nil {
+		t.Errorf("list=%v, err=%v, want no access list", list, err)
+	}
+}
+
+// acceptOnce connects a client to a listener filtering with an access list, returning the client,
+// whether its connection was accepted and the logs.
+func acceptOnce(t *testing.T, accessListCfg accessListConfig) (net.Conn, bool, *bytes.Buffer) {
+	t.Helper()
+	cfg := &config{}
+	logs := setupLogBuffer(t, cfg)
+	list, err := newAccessList(accessListCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.accessList = list
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newAccessListListener(tcpListener, cfg)
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		return client, true, logs
+	case <-time.After(100 * time.Millisecond):
+		return client, false, logs
+	}
+}
+
+func TestAccessListListenerAllowed(t *testing.T) {
+	if _, accepted, logs := acceptOnce(t, accessListConfig{Allow: []string{"127.0.0.0/8", "::1"}}); !accepted || logs.Len() != 0 {
+		t.Errorf("accepted=%v, logs=%v, want the connection accepted", accepted, logs)
+	}
+}
+
+func TestAccessListListenerDenied(t *testing.T) {
+	client, accepted, logs := acceptOnce(t, accessListConfig{Deny: []string{"127.0.0.0/8"}})
+	if accepted {
+		t.Errorf("accepted=true, want the connection denied")
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("err=%v, want the denied connection to be closed", err)
+	}
+	if !strings.HasSuffix(logs.String(), "] connection denied: source in denied network 127.0.0.0/8\n") {
+		t.Errorf("logs=%v, want the denied connection logged", logs)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// auditLog appends JSON Lines event records to a file, independently of the format of the activity logs.
+type auditLog struct {
+	path    string
+	maxSize int64
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+func openAuditLog(cfg auditConfig) (*auditLog, error) {
+	audit := &auditLog{path: cfg.File, maxSize: cfg.MaxSize}
+	if err := audit.open(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+func (audit *auditLog) open() error {
+	file, err := os.OpenFile(audit.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	audit.file = file
+	audit.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file aside, replacing the previously rotated one, and starts a new file.
+func (audit *auditLog) rotate() error {
+	if err := audit.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(audit.path, audit.path+".1"); err != nil {
+		return err
+	}
+	return audit.open()
+}
+
+func (audit *auditLog) write(record []byte) error {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	line := append(record, '\n')
+	if audit.maxSize > 0 && audit.size > 0 && audit.size+int64(len(line)) > audit.maxSize {
+		if err := audit.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := audit.file.Write(line)
+	audit.size += int64(n)
+	return err
+}
+
+func (audit *auditLog) Close() error {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	return audit.file.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, file string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Failed to parse audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	cfg := &config{}
+	cfg.Logging.Audit.File = path.Join(t.TempDir(), "audit.jsonl")
+	logs := setupLogBuffer(t, cfg)
+	t.Cleanup(func() { cfg.auditLog.Close() })
+	context := connContext{ConnMetadata: mockConnContext{}, cfg: cfg}
+	context.logEvent(passwordAuthLog{authLog: authLog{User: "root", Accepted: true}, Password: "hunter2"})
+	context.logEvent(sessionLog{channelLog: channelLog{ChannelID: 0}})
+	context.logEvent(commandLog{channelLog: channelLog{ChannelID: 0}, Command: "id", Args: []string{}, User: "root"})
+	context.logEvent(debugGlobalRequestLog{RequestType: "test"})
+
+	if !strings.HasPrefix(logs.String(), `[127.0.0.1:1234] authentication for user "root" with password "hunter2" accepted`) {
+		t.Errorf("logs=%v, want human readable logs", logs.String())
+	}
+	records := readAuditLog(t, cfg.Logging.Audit.File)
+	var eventTypes []string
+	for _, record := range records {
+		if _, ok := record["time"].(string); !ok {
+			t.Errorf("record=%v, want a timestamp", record)
+		}
+		eventTypes = append(eventTypes, record["event_type"].(string))
+	}
+	if strings.Join(eventTypes, " ") != "password_auth session command" {
+		t.Errorf("eventTypes=%v, want password_auth session command", eventTypes)
+	}
+	if event := records[0]["event"].(map[string]interface{}); event["password"] != "hunter2" {
+		t.Errorf("event=%v, want the password", event)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	file := path.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(auditConfig{File: file, MaxSize: 10})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer audit.Close()
+	for _, record := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := audit.write([]byte(record)); err != nil {
+			t.Fatalf("Failed to write audit record: %v", err)
+		}
+	}
+	if records := readAuditLog(t, file+".1"); len(records) != 1 || records[0]["n"] != 2.0 {
+		t.Errorf("rotated records=%v, want the second record", records)
+	}
+	if records := readAuditLog(t, file); len(records) != 1 || records[0]["n"] != 3.0 {
+		t.Errorf("records=%v, want the third record", records)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
 	"strings"
+	"text/template"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -15,6 +21,44 @@ var authAttemptsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help: "Total number of authentication attempts",
 }, []string{"method", "accepted"})
 
+// authMethodExtension is the permissions extension recording how a connection authenticated.
+const authMethodExtension = "sshesame-auth-method"
+
+func authPermissions(method string) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{authMethodExtension: method}}
+}
+
+// authMethod returns the method conn authenticated with, "none" if authentication wasn't required.
+func authMethod(conn ssh.Conn) string {
+	if serverConn, ok := conn.(*ssh.ServerConn); ok && serverConn.Permissions != nil {
+		if method, ok := serverConn.Permissions.Extensions[authMethodExtension]; ok {
+			return method
+		}
+	}
+	return "none"
+}
+
+// accepts reports whether authentication from addr is accepted, consistently for the same IP.
+func (cfg randomAuthConfig) accepts(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	hash := fnv.New64a()
+	binary.Write(hash, binary.BigEndian, cfg.Seed)
+	hash.Write([]byte(host))
+	return rand.New(rand.NewSource(int64(hash.Sum64()))).Float64() < cfg.Probability
+}
+
+// credentialsAccepted reports whether authentication as user with password is accepted,
+// before the one-shot guard.
+func (cfg *config) credentialsAccepted(conn ssh.ConnMetadata, password string) bool {
+	if cfg.Auth.RandomAuth.Enabled {
+		return cfg.Auth.RandomAuth.accepts(conn.RemoteAddr())
+	}
+	return cfg.validCredentials(conn.User(), password)
+}
+
 func (cfg *config) getAuthLogCallback() func(conn ssh.ConnMetadata, method string, err error) {
 	return func(conn ssh.ConnMetadata, method string, err error) {
 		var acceptedLabel string
@@ -24,15 +68,32 @@ func (cfg *config) getAuthLogCallback() func(conn ssh.ConnMetadata, method strin
 			acceptedLabel = "false"
 		}
 		authAttemptsMetric.WithLabelValues(method, acceptedLabel).Inc()
+		if err == nil && method == "publickey" {
+			// Only trip once the signature has been verified
+			cfg.oneShot.accept(conn, cfg)
+		}
 		if method == "none" {
 			connContext{ConnMetadata: conn, cfg: cfg}.logEvent(noAuthLog{authLog: authLog{
 				User:     conn.User(),
 				Accepted: err == nil,
+				geoLog:   cfg.geoIP.lookup(conn.RemoteAddr()),
 			}})
 		}
 	}
 }
 
+func (cfg *config) getNoAuthCallback() func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+	if !cfg.Auth.OneShot {
+		return nil
+	}
+	return func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+		if !cfg.oneShot.accept(conn, cfg) {
+			return nil, errors.New("")
+		}
+		return authPermissions("none"), nil
+	}
+}
+
 func (cfg *config) getPasswordCallback() func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	// If password auth is disabled we reject the connection
 	if !cfg.Auth.PasswordAuth.Enabled {
@@ -40,23 +101,28 @@ func (cfg *config) getPasswordCallback() func(conn ssh.ConnMetadata, password []
 	}
 
 	return func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		cfg.spray.check(conn, cfg, string(password))
 		// Check for valid connection
-		if conn.User() == cfg.validUser && string(password) == cfg.validPass {
+		if cfg.credentialsAccepted(conn, string(password)) && cfg.oneShot.accept(conn, cfg) {
 			// Logging
-			connContext{ConnMetadata: conn, cfg: cfg}.logEvent(passwordAuthLog{
+			entry := passwordAuthLog{
 				authLog: authLog{
 					User:     conn.User(),
 					Accepted: authAccepted(cfg.Auth.PasswordAuth.Accepted),
+					geoLog:   cfg.geoIP.lookup(conn.RemoteAddr()),
 				},
 				Password: string(password),
-			})
-			return nil, nil
+			}
+			connContext{ConnMetadata: conn, cfg: cfg}.logEvent(entry)
+			cfg.notifyLogin(conn, entry)
+			return authPermissions("password"), nil
 		}
 		// Log the failed attempt and return an error
 		connContext{ConnMetadata: conn, cfg: cfg}.logEvent(passwordAuthLog{
 			authLog: authLog{
 				User:     conn.User(),
 				Accepted: authAccepted(false), // Failed authentication
+				geoLog:   cfg.geoIP.lookup(conn.RemoteAddr()),
 			},
 			Password: string(password),
 		})
@@ -69,17 +135,23 @@ func (cfg *config) getPublicKeyCallback() func(conn ssh.ConnMetadata, key ssh.Pu
 		return nil
 	}
 	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-		connContext{ConnMetadata: conn, cfg: cfg}.logEvent(publicKeyAuthLog{
+		accepted := cfg.Auth.PublicKeyAuth.accepts(key) && cfg.oneShot.permits(conn)
+		entry := publicKeyAuthLog{
 			authLog: authLog{
 				User:     conn.User(),
-				Accepted: authAccepted(cfg.Auth.PublicKeyAuth.Accepted),
+				Accepted: authAccepted(accepted),
+				geoLog:   cfg.geoIP.lookup(conn.RemoteAddr()),
 			},
 			PublicKeyFingerprint: ssh.FingerprintSHA256(key),
-		})
-		if !cfg.Auth.PublicKeyAuth.Accepted {
+			PublicKeyType:        key.Type(),
+			PublicKey:            base64.StdEncoding.EncodeToString(key.Marshal()),
+		}
+		connContext{ConnMetadata: conn, cfg: cfg}.logEvent(entry)
+		if !accepted {
 			return nil, errors.New("")
 		}
-		return nil, nil
+		cfg.notifyLogin(conn, entry)
+		return authPermissions("publickey"), nil
 	}
 }
 
@@ -87,11 +159,14 @@ func (cfg *config) getKeyboardInteractiveCallback() func(conn ssh.ConnMetadata,
 	if !cfg.Auth.KeyboardInteractiveAuth.Enabled {
 		return nil
 	}
-	var keyboardInteractiveQuestions []string
-	var keyboardInteractiveEchos []bool
-	for _, question := range cfg.Auth.KeyboardInteractiveAuth.Questions {
-		keyboardInteractiveQuestions = append(keyboardInteractiveQuestions, question.Text)
-		keyboardInteractiveEchos = append(keyboardInteractiveEchos, question.Echo)
+	rounds := cfg.Auth.KeyboardInteractiveAuth.rounds()
+	keyboardInteractiveQuestions := make([][]string, len(rounds))
+	keyboardInteractiveEchos := make([][]bool, len(rounds))
+	for i, round := range rounds {
+		for _, question := range round.Questions {
+			keyboardInteractiveQuestions[i] = append(keyboardInteractiveQuestions[i], question.Text)
+			keyboardInteractiveEchos[i] = append(keyboardInteractiveEchos[i], question.Echo)
+		}
 	}
 	//return func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
 	//	answers, err := client(conn.User(), cfg.Auth.KeyboardInteractiveAuth.Instruction, keyboardInteractiveQuestions, keyboardInteractiveEchos)
@@ -115,43 +190,76 @@ func (cfg *config) getKeyboardInteractiveCallback() func(conn ssh.ConnMetadata,
 	//	return nil, nil
 	//}
 	return func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
-		// Ask the user for the password (single question)
-		answers, err := client(conn.User(), cfg.Auth.KeyboardInteractiveAuth.Instruction, keyboardInteractiveQuestions, keyboardInteractiveEchos)
-		if err != nil {
-			warningLogger.Printf("Failed to process keyboard interactive authentication: %v", err)
-			return nil, errors.New("")
+		// Ask the questions of every round in turn, collecting all the answers
+		var answers []string
+		for i, round := range rounds {
+			roundAnswers, err := client(conn.User(), round.Instruction, keyboardInteractiveQuestions[i], keyboardInteractiveEchos[i])
+			if err != nil {
+				warningLogger.Printf("Failed to process keyboard interactive authentication: %v", err)
+				return nil, errors.New("")
+			}
+			answers = append(answers, roundAnswers...)
 		}
 
 		// Log the authentication event
-		connContext{ConnMetadata: conn, cfg: cfg}.logEvent(keyboardInteractiveAuthLog{
+		entry := keyboardInteractiveAuthLog{
 			authLog: authLog{
 				User:     conn.User(),
 				Accepted: authAccepted(cfg.Auth.KeyboardInteractiveAuth.Accepted),
+				geoLog:   cfg.geoIP.lookup(conn.RemoteAddr()),
 			},
 			Answers: answers,
-		})
+		}
+		connContext{ConnMetadata: conn, cfg: cfg}.logEvent(entry)
+		if len(answers) != 0 {
+			cfg.spray.check(conn, cfg, answers[0])
+		}
 
 		// If the username and password are correct, allow the user to log in
-		if conn.User() == cfg.validUser && answers[0] == cfg.validPass {
-			return nil, nil // Successful authentication
+		if len(answers) != 0 && cfg.credentialsAccepted(conn, answers[0]) && cfg.oneShot.accept(conn, cfg) {
+			cfg.notifyLogin(conn, entry)
+			return authPermissions("keyboard-interactive"), nil // Successful authentication
 		}
 
 		// Reject if the password is incorrect or authentication isn't accepted
-		if !cfg.Auth.KeyboardInteractiveAuth.Accepted {
+		if cfg.Auth.RandomAuth.Enabled || !cfg.Auth.KeyboardInteractiveAuth.Accepted || !cfg.oneShot.accept(conn, cfg) {
 			return nil, errors.New("")
 		}
 
-		return nil, nil // If it's not accepted in configuration, reject silently
+		cfg.notifyLogin(conn, entry)
+		return authPermissions("keyboard-interactive"), nil // If it's not accepted in configuration, reject silently
 	}
 }
 
-func (cfg *config) getBannerCallback() func(conn ssh.ConnMetadata) string {
+// bannerData is what the banner template is executed with for every connection.
+type bannerData struct {
+	Hostname string
+	Date     string
+	Uptime   string
+}
+
+func (cfg *config) getBannerCallback() (func(conn ssh.ConnMetadata) string, error) {
 	if cfg.SSHProto.Banner == "" {
-		return nil
+		return nil, nil
 	}
-	banner := strings.ReplaceAll(strings.ReplaceAll(cfg.SSHProto.Banner, "\r\n", "\n"), "\n", "\r\n")
-	if !strings.HasSuffix(banner, "\r\n") {
-		banner = fmt.Sprintf("%v\r\n", banner)
+	bannerTemplate, err := template.New("banner").Parse(cfg.SSHProto.Banner)
+	if err != nil {
+		return nil, err
 	}
-	return func(conn ssh.ConnMetadata) string { return banner }
+	return func(conn ssh.ConnMetadata) string {
+		var rendered strings.Builder
+		now := cfg.clock.now()
+		if err := bannerTemplate.Execute(&rendered, bannerData{
+			Hostname: cfg.Shell.Hostname,
+			Date:     strftime("%a %b %e %H:%M:%S %Z %Y", now),
+			Uptime:   formatUptime(now.Sub(cfg.clock.boot())),
+		}); err != nil {
+			warningLogger.Printf("Failed to render banner: %v", err)
+		}
+		banner := strings.ReplaceAll(strings.ReplaceAll(rendered.String(), "\r\n", "\n"), "\n", "\r\n")
+		if !strings.HasSuffix(banner, "\r\n") {
+			banner = fmt.Sprintf("%v\r\n", banner)
+		}
+		return banner
+	}, nil
 }
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type mockConnContext struct{}
@@ -121,8 +133,8 @@ func TestPasswordSuccess(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
-	if permissions != nil {
-		t.Errorf("permissions=%v, want nil", permissions)
+	if !reflect.DeepEqual(permissions, authPermissions("password")) {
+		t.Errorf("permissions=%v, want the password method recorded", permissions)
 	}
 	expectedLogs := `[127.0.0.1:1234] authentication for user "root" with password "hunter2" accepted
 `
@@ -171,8 +183,8 @@ func TestPasswordSuccessJSON(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
-	if permissions != nil {
-		t.Errorf("permissions=%v, want nil", permissions)
+	if !reflect.DeepEqual(permissions, authPermissions("password")) {
+		t.Errorf("permissions=%v, want the password method recorded", permissions)
 	}
 	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"password_auth","event":{"user":"root","accepted":true,"password":"hunter2"}}
 `
@@ -228,8 +240,8 @@ func TestPublicKeySuccess(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
-	if permissions != nil {
-		t.Errorf("permissions=%v, want nil", permissions)
+	if !reflect.DeepEqual(permissions, authPermissions("publickey")) {
+		t.Errorf("permissions=%v, want the publickey method recorded", permissions)
 	}
 	expectedLogs := `[127.0.0.1:1234] authentication for user "root" with public key "SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q" accepted
 `
@@ -256,7 +268,7 @@ func TestPublicKeyFailJSON(t *testing.T) {
 	if permissions != nil {
 		t.Errorf("permissions=%v, want nil", permissions)
 	}
-	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"public_key_auth","event":{"user":"root","accepted":false,"public_key":"SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q"}}
+	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"public_key_auth","event":{"user":"root","accepted":false,"public_key":"SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q","public_key_type":"rsa","public_key_data":"cnNh"}}
 `
 	if logs != expectedLogs {
 		t.Errorf("logs=%v, want %v", string(logs), expectedLogs)
@@ -278,16 +290,75 @@ func TestPublicKeySuccessJSON(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
-	if permissions != nil {
-		t.Errorf("permissions=%v, want nil", permissions)
+	if !reflect.DeepEqual(permissions, authPermissions("publickey")) {
+		t.Errorf("permissions=%v, want the publickey method recorded", permissions)
 	}
-	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"public_key_auth","event":{"user":"root","accepted":true,"public_key":"SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q"}}
+	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"public_key_auth","event":{"user":"root","accepted":true,"public_key":"SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q","public_key_type":"rsa","public_key_data":"cnNh"}}
 `
 	if logs != expectedLogs {
 		t.Errorf("logs=%v, want %v", string(logs), expectedLogs)
 	}
 }
 
+func TestPublicKeyLogsKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, privateKey := range []crypto.Signer{rsaKey, ed25519Key} {
+		publicKey, err := ssh.NewPublicKey(privateKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config{}
+		cfg.Logging.JSON = true
+		cfg.Auth.PublicKeyAuth.Enabled = true
+		logBuffer := setupLogBuffer(t, cfg)
+		cfg.getPublicKeyCallback()(mockConnContext{}, publicKey)
+		var entry struct {
+			Event publicKeyAuthLog `json:"event"`
+		}
+		if err := json.Unmarshal(logBuffer.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Event.PublicKeyType != publicKey.Type() {
+			t.Errorf("public_key_type=%v, want %v", entry.Event.PublicKeyType, publicKey.Type())
+		}
+		if marshaled, err := base64.StdEncoding.DecodeString(entry.Event.PublicKey); err != nil || !bytes.Equal(marshaled, publicKey.Marshal()) {
+			t.Errorf("public_key_data=%v, want the marshaled %v key", entry.Event.PublicKey, publicKey.Type())
+		}
+	}
+}
+
+func TestPublicKeyAcceptedFingerprints(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.PublicKeyAuth.Enabled = true
+	cfg.Auth.PublicKeyAuth.Accepted = true
+	cfg.Auth.PublicKeyAuth.AcceptedFingerprints = []string{"SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q"}
+	callback := cfg.getPublicKeyCallback()
+	if callback == nil {
+		t.Fatalf("callback=nil, want a function")
+	}
+	logBuffer := setupLogBuffer(t, cfg)
+	if _, err := callback(mockConnContext{}, mockPublicKey{}); err != nil {
+		t.Errorf("err=%v, want nil for an accepted fingerprint", err)
+	}
+	otherKey := mockPublicKey{ed25519_key}
+	if _, err := callback(mockConnContext{}, otherKey); err == nil {
+		t.Errorf("err=nil, want an error for a fingerprint not in the list")
+	}
+	expectedLogs := fmt.Sprintf(`[127.0.0.1:1234] authentication for user "root" with public key "SHA256:9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q" accepted
+[127.0.0.1:1234] authentication for user "root" with public key %q rejected
+`, ssh.FingerprintSHA256(otherKey))
+	if logs := logBuffer.String(); logs != expectedLogs {
+		t.Errorf("logs=%v, want %v", logs, expectedLogs)
+	}
+}
+
 func TestKeyboardInteractiveDisabled(t *testing.T) {
 	cfg := &config{}
 	cfg.Auth.KeyboardInteractiveAuth.Enabled = false
@@ -396,8 +467,8 @@ func TestKeyboardInteractiveSuccess(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
-	if permissions != nil {
-		t.Errorf("permissions=%v, want nil", permissions)
+	if !reflect.DeepEqual(permissions, authPermissions("keyboard-interactive")) {
+		t.Errorf("permissions=%v, want the keyboard-interactive method recorded", permissions)
 	}
 	expectedLogs := `[127.0.0.1:1234] authentication for user "root" with keyboard interactive answers ["a1" "a2"] accepted
 `
@@ -460,31 +531,129 @@ func TestKeyboardInteractiveSuccessJSON(t *testing.T) {
 	if err != nil {
 		t.Errorf("err=%v, want nil", err)
 	}
+	if !reflect.DeepEqual(permissions, authPermissions("keyboard-interactive")) {
+		t.Errorf("permissions=%v, want the keyboard-interactive method recorded", permissions)
+	}
+	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"keyboard_interactive_auth","event":{"user":"root","accepted":true,"answers":["a1","a2"]}}
+`
+	if logs != expectedLogs {
+		t.Errorf("logs=%v, want %v", string(logs), expectedLogs)
+	}
+}
+
+func TestKeyboardInteractiveRounds(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.KeyboardInteractiveAuth.Enabled = true
+	cfg.Auth.KeyboardInteractiveAuth.Accepted = false
+	cfg.Auth.KeyboardInteractiveAuth.Rounds = []keyboardInteractiveAuthRound{
+		{"inst1", []keyboardInteractiveAuthQuestion{{"Password: ", false}}},
+		{"inst2", []keyboardInteractiveAuthQuestion{{"Verification code: ", true}}},
+	}
+	callback := cfg.getKeyboardInteractiveCallback()
+	if callback == nil {
+		t.Fatalf("callback=nil, want a function")
+	}
+	logBuffer := setupLogBuffer(t, cfg)
+	var instructions []string
+	permissions, err := callback(mockConnContext{}, func(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
+		instructions = append(instructions, instruction)
+		if len(instructions) == 1 {
+			return []string{"hunter2"}, nil
+		}
+		return []string{"123456"}, nil
+	})
+	logs := logBuffer.String()
+	if err == nil {
+		t.Errorf("err=nil, want an error")
+	}
 	if permissions != nil {
 		t.Errorf("permissions=%v, want nil", permissions)
 	}
-	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"keyboard_interactive_auth","event":{"user":"root","accepted":true,"answers":["a1","a2"]}}
+	if !reflect.DeepEqual(instructions, []string{"inst1", "inst2"}) {
+		t.Errorf("instructions=%v, want [inst1 inst2]", instructions)
+	}
+	expectedLogs := `[127.0.0.1:1234] authentication for user "root" with keyboard interactive answers ["hunter2" "123456"] rejected
 `
 	if logs != expectedLogs {
 		t.Errorf("logs=%v, want %v", string(logs), expectedLogs)
 	}
 }
 
+type mockAddrConnContext struct {
+	mockConnContext
+	addr net.Addr
+}
+
+func (context mockAddrConnContext) RemoteAddr() net.Addr {
+	return context.addr
+}
+
+func TestRandomAuthDeterministic(t *testing.T) {
+	for _, probability := range []float64{0, 1} {
+		cfg := &config{}
+		cfg.Auth.PasswordAuth.Enabled = true
+		cfg.Auth.KeyboardInteractiveAuth.Enabled = true
+		cfg.Auth.KeyboardInteractiveAuth.Accepted = true
+		cfg.Auth.KeyboardInteractiveAuth.Questions = []keyboardInteractiveAuthQuestion{{"Password: ", false}}
+		cfg.Auth.RandomAuth = randomAuthConfig{Enabled: true, Probability: probability}
+		setupLogBuffer(t, cfg)
+		passwordCallback := cfg.getPasswordCallback()
+		keyboardInteractiveCallback := cfg.getKeyboardInteractiveCallback()
+		for i := 0; i < 16; i++ {
+			conn := mockAddrConnContext{addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 1234}}
+			if _, err := passwordCallback(conn, []byte("hunter2")); (err == nil) != (probability == 1) {
+				t.Errorf("probability=%v: password err=%v for %v", probability, err, conn.addr)
+			}
+			_, err := keyboardInteractiveCallback(conn, func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return []string{"hunter2"}, nil
+			})
+			if (err == nil) != (probability == 1) {
+				t.Errorf("probability=%v: keyboard interactive err=%v for %v", probability, err, conn.addr)
+			}
+		}
+	}
+}
+
+func TestRandomAuthConsistent(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.PasswordAuth.Enabled = true
+	cfg.Auth.RandomAuth = randomAuthConfig{Enabled: true, Probability: 0.5, Seed: 42}
+	setupLogBuffer(t, cfg)
+	callback := cfg.getPasswordCallback()
+	accepted := 0
+	for i := 0; i < 64; i++ {
+		addr := &net.TCPAddr{IP: net.IPv4(198, 51, 100, byte(i)), Port: 1234}
+		_, err := callback(mockAddrConnContext{addr: addr}, []byte("123456"))
+		for port := 1; port < 4; port++ {
+			_, retryErr := callback(mockAddrConnContext{addr: &net.TCPAddr{IP: addr.IP, Port: 1234 + port}}, []byte("password"))
+			if (retryErr == nil) != (err == nil) {
+				t.Errorf("err=%v then %v for %v, want the same outcome", err, retryErr, addr.IP)
+			}
+		}
+		if err == nil {
+			accepted++
+		}
+	}
+	if accepted == 0 || accepted == 64 {
+		t.Errorf("accepted=%v of 64 IPs, want different IPs to differ", accepted)
+	}
+}
+
 func TestBannerDisabled(t *testing.T) {
 	cfg := &config{}
 	cfg.SSHProto.Banner = ""
-	callback := cfg.getBannerCallback()
-	if callback != nil {
-		t.Errorf("callback=%p, want nil", callback)
+	callback, err := cfg.getBannerCallback()
+	if err != nil || callback != nil {
+		t.Errorf("callback=%p, err=%v, want nil", callback, err)
 	}
 }
 
 func TestBanner(t *testing.T) {
 	cfg := &config{}
 	cfg.SSHProto.Banner = "Lorem\nIpsum\r\nDolor\n\nSit Amet"
-	callback := cfg.getBannerCallback()
-	if callback == nil {
-		t.Fatalf("callback=nil, want a function")
+	callback, err := cfg.getBannerCallback()
+	if err != nil || callback == nil {
+		t.Fatalf("callback=nil, err=%v, want a function", err)
 	}
 	banner := callback(mockConnContext{})
 	expectedBanner := "Lorem\r\nIpsum\r\nDolor\r\n\r\nSit Amet\r\n"
@@ -492,3 +661,31 @@ func TestBanner(t *testing.T) {
 		t.Errorf("banner=%v, want %v", banner, expectedBanner)
 	}
 }
+
+func TestBannerTemplate(t *testing.T) {
+	cfg := &config{}
+	cfg.SSHProto.Banner = "{{.Hostname}}\n{{.Date}}\nup {{.Uptime}}"
+	cfg.Shell.Hostname = "web-01"
+	cfg.clock = clock{location: time.UTC, booted: time.Date(2021, time.February, 26, 8, 5, 3, 0, time.UTC)}
+	callback, err := cfg.getBannerCallback()
+	if err != nil || callback == nil {
+		t.Fatalf("callback=nil, err=%v, want a function", err)
+	}
+	stubTimeSource(t, time.Date(2021, time.March, 1, 9, 5, 3, 0, time.UTC))
+	expectedBanner := "web-01\r\nMon Mar  1 09:05:03 UTC 2021\r\nup 3 days,  1:00\r\n"
+	if banner := callback(mockConnContext{}); banner != expectedBanner {
+		t.Errorf("banner=%q, want %q", banner, expectedBanner)
+	}
+	stubTimeSource(t, time.Date(2021, time.March, 2, 10, 0, 0, 0, time.UTC))
+	if banner := callback(mockConnContext{}); !strings.Contains(banner, "Tue Mar  2 10:00:00 UTC 2021\r\nup 4 days,  1:54") {
+		t.Errorf("banner=%q, want it rendered for the current date and uptime", banner)
+	}
+}
+
+func TestInvalidBanner(t *testing.T) {
+	cfg := &config{}
+	cfg.SSHProto.Banner = "{{.Hostname"
+	if err := cfg.setupSSHConfig(); err == nil || !strings.HasPrefix(err.Error(), "invalid banner: ") {
+		t.Errorf("err=%v, want an invalid banner error", err)
+	}
+}
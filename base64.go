@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// base64LineLength is the column encoded output is wrapped at by default.
+const base64LineLength = 76
+
+type cmdBase64 struct{}
+
+func (cmdBase64) execute(context commandContext) (uint32, error) {
+	decode := false
+	var files []string
+	for _, arg := range context.args[1:] {
+		switch arg {
+		case "-d", "--decode":
+			decode = true
+		case "-i", "--ignore-garbage":
+		default:
+			files = append(files, arg)
+		}
+	}
+	if len(files) > 1 {
+		_, err := fmt.Fprintf(context.stderr, "base64: extra operand '%v'\nTry 'base64 --help' for more information.\n", files[1])
+		return 1, err
+	}
+	var input string
+	if len(files) == 0 || files[0] == "-" {
+		var lines []string
+		for {
+			line, err := context.stdin.ReadLine()
+			if endOfInput(err) {
+				break
+			}
+			if err != nil {
+				return 1, err
+			}
+			lines = append(lines, line+"\n")
+		}
+		input = strings.Join(lines, "")
+	} else {
+		node := context.state.fs.lookup(files[0])
+		switch {
+		case node == nil:
+			_, err := fmt.Fprintf(context.stderr, "base64: %v: No such file or directory\n", files[0])
+			return 1, err
+		case node.IsDir:
+			_, err := fmt.Fprintln(context.stderr, "base64: read error: Is a directory")
+			return 1, err
+		case !node.canRead(context.user):
+			_, err := fmt.Fprintf(context.stderr, "base64: %v: Permission denied\n", files[0])
+			return 1, err
+		}
+		input = node.content()
+	}
+
+	if input == "" {
+		return 0, nil
+	}
+	if !decode {
+		encoded := base64.StdEncoding.EncodeToString([]byte(input))
+		var lines []string
+		for len(encoded) > base64LineLength {
+			lines = append(lines, encoded[:base64LineLength])
+			encoded = encoded[base64LineLength:]
+		}
+		lines = append(lines, encoded)
+		_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+		return 0, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(input), ""))
+	if err != nil {
+		_, err := fmt.Fprintln(context.stderr, "base64: invalid input")
+		return 1, err
+	}
+	context.logEvent(base64DecodeLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Decoded:    string(decoded),
+	})
+	_, err = fmt.Fprint(context.stdout, string(decoded))
+	return 0, err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeSource is the real wall clock, stubbed in tests.
+var timeSource = time.Now
+
+// clock is the time seen by the fake shell, optionally frozen, skewed or moved to another time zone.
+type clock struct {
+	fixed    time.Time
+	offset   time.Duration
+	location *time.Location
+	// booted is when the fake system booted, so that its uptime grows as the clock advances.
+	booted time.Time
+}
+
+func newClock(cfg clockConfig) (clock, error) {
+	result := clock{offset: cfg.Offset}
+	if cfg.Fixed != "" {
+		fixed, err := time.Parse(time.RFC3339, cfg.Fixed)
+		if err != nil {
+			return clock{}, fmt.Errorf("invalid fixed clock time: %w", err)
+		}
+		result.fixed = fixed
+	}
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return clock{}, fmt.Errorf("invalid clock timezone: %w", err)
+		}
+		result.location = location
+	}
+	result.booted = result.now().Add(-bootTime)
+	return result, nil
+}
+
+func (c clock) now() time.Time {
+	now := c.fixed
+	if now.IsZero() {
+		now = timeSource()
+	}
+	now = now.Add(c.offset)
+	if c.location != nil {
+		now = now.In(c.location)
+	}
+	return now
+}
+
+// boot returns when the fake system booted, bootTime before the current time if the clock wasn't set up.
+func (c clock) boot() time.Time {
+	if c.booted.IsZero() {
+		return c.now().Add(-bootTime)
+	}
+	return c.booted
+}
+
+// strftime formats t using the conversion specifications understood by date(1).
+// Unknown specifications are printed as is.
+func strftime(format string, t time.Time) string {
+	var result strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			result.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'a':
+			result.WriteString(t.Format("Mon"))
+		case 'A':
+			result.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			result.WriteString(t.Format("Jan"))
+		case 'B':
+			result.WriteString(t.Format("January"))
+		case 'c':
+			result.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'C':
+			fmt.Fprintf(&result, "%02d", t.Year()/100)
+		case 'd':
+			result.WriteString(t.Format("02"))
+		case 'D':
+			result.WriteString(t.Format("01/02/06"))
+		case 'e':
+			result.WriteString(t.Format("_2"))
+		case 'F':
+			result.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			result.WriteString(t.Format("15"))
+		case 'I':
+			result.WriteString(t.Format("03"))
+		case 'j':
+			fmt.Fprintf(&result, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(&result, "%2d", t.Hour())
+		case 'l':
+			result.WriteString(t.Format("_3"))
+		case 'm':
+			result.WriteString(t.Format("01"))
+		case 'M':
+			result.WriteString(t.Format("04"))
+		case 'n':
+			result.WriteByte('\n')
+		case 'N':
+			fmt.Fprintf(&result, "%09d", t.Nanosecond())
+		case 'p':
+			result.WriteString(t.Format("PM"))
+		case 'P':
+			result.WriteString(t.Format("pm"))
+		case 'r':
+			result.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			result.WriteString(t.Format("15:04"))
+		case 's':
+			fmt.Fprint(&result, t.Unix())
+		case 'S':
+			result.WriteString(t.Format("05"))
+		case 't':
+			result.WriteByte('\t')
+		case 'T', 'X':
+			result.WriteString(t.Format("15:04:05"))
+		case 'u':
+			weekday := int(t.Weekday())
+			if weekday == 0 {
+				weekday = 7
+			}
+			fmt.Fprint(&result, weekday)
+		case 'w':
+			fmt.Fprint(&result, int(t.Weekday()))
+		case 'x':
+			result.WriteString(t.Format("01/02/06"))
+		case 'y':
+			result.WriteString(t.Format("06"))
+		case 'Y':
+			fmt.Fprint(&result, t.Year())
+		case 'z':
+			result.WriteString(t.Format("-0700"))
+		case 'Z':
+			result.WriteString(t.Format("MST"))
+		case '%':
+			result.WriteByte('%')
+		default:
+			result.WriteByte('%')
+			result.WriteByte(format[i])
+		}
+	}
+	return result.String()
+}
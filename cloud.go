@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var defaultCloudResponses = map[string]string{
+	"aws sts get-caller-identity": `{
+    "UserId": "AIDA4QYLXR7TQ2JHNV6PA",
+    "Account": "482913576102",
+    "Arn": "arn:aws:iam::482913576102:user/deploy"
+}`,
+	"aws s3 ls": `2021-06-14 08:21:37 prod-db-01-backups
+2022-01-03 17:45:12 billing-exports-eu-west-1
+2022-09-27 11:02:58 terraform-state-482913576102`,
+	"gcloud compute instances list": `NAME          ZONE            MACHINE_TYPE   PREEMPTIBLE  INTERNAL_IP  EXTERNAL_IP    STATUS
+prod-db-01    europe-west1-b  n2-standard-8               10.132.0.4   34.76.112.51   RUNNING
+prod-web-01   europe-west1-b  e2-medium                   10.132.0.7   35.195.61.204  RUNNING
+ci-runner-03  europe-west1-c  e2-standard-4  true         10.132.0.12                 TERMINATED`,
+}
+
+// cloudCLI describes how a cloud provider's command line client is emulated.
+type cloudCLI struct {
+	name string
+	// profileFlag selects the credentials used, defaulting to defaultProfile.
+	profileFlag    string
+	defaultProfile string
+	// valueFlags are the global flags taking a separate value.
+	valueFlags []string
+	// denied is printed when there's no configured response for a command.
+	denied       string
+	deniedStatus uint32
+}
+
+func (cli cloudCLI) execute(context commandContext) (uint32, error) {
+	profile := cli.defaultProfile
+	var positional []string
+	for i := 1; i < len(context.args); i++ {
+		arg := context.args[i]
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		flag, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue {
+			for _, valueFlag := range cli.valueFlags {
+				if flag == valueFlag && i+1 < len(context.args) {
+					i++
+					value = context.args[i]
+				}
+			}
+		}
+		if flag == cli.profileFlag {
+			profile = value
+		}
+	}
+	command := strings.Join(append([]string{cli.name}, positional...), " ")
+	context.logEvent(cloudReconLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Command:    command,
+		Profile:    profile,
+	})
+
+	// The longest configured command the invocation starts with wins, so "aws s3 ls s3://bucket" can fall back to "aws s3 ls".
+	response, found := "", false
+	for prefix := positional; ; prefix = prefix[:len(prefix)-1] {
+		response, found = context.cfg.Shell.Cloud.Responses[strings.Join(append([]string{cli.name}, prefix...), " ")]
+		if found || len(prefix) == 0 {
+			break
+		}
+	}
+	if !found {
+		_, err := fmt.Fprintln(context.stderr, cli.denied)
+		return cli.deniedStatus, err
+	}
+	_, err := fmt.Fprintln(context.stdout, response)
+	return 0, err
+}
+
+var cmdAws = cloudCLI{
+	name:           "aws",
+	profileFlag:    "--profile",
+	defaultProfile: "default",
+	valueFlags:     []string{"--profile", "--region", "--output", "--endpoint-url", "--query", "--color", "--ca-bundle", "--cli-read-timeout", "--cli-connect-timeout"},
+	denied:         "\nAn error occurred (AccessDenied) when calling the operation: User: arn:aws:iam::482913576102:user/deploy is not authorized to perform this action",
+	deniedStatus:   254,
+}
+
+var cmdGcloud = cloudCLI{
+	name:           "gcloud",
+	profileFlag:    "--account",
+	defaultProfile: "deploy@prod-db-01.iam.gserviceaccount.com",
+	valueFlags:     []string{"--account", "--project", "--zone", "--region", "--format", "--filter", "--configuration", "--verbosity", "--limit"},
+	denied:         "ERROR: (gcloud) PERMISSION_DENIED: Request had insufficient authentication scopes.",
+	deniedStatus:   1,
+}
@@ -1,23 +1,116 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type readLiner interface {
 	ReadLine() (string, error)
 }
 
+// endOfInput reports whether err from reading stdin ends the input of a command,
+// which is EOF without a pty and Ctrl-D with one.
+func endOfInput(err error) bool {
+	return err == io.EOF || err == clientEOF
+}
+
+type passwordReader interface {
+	ReadPassword(prompt string) (string, error)
+}
+
 type commandContext struct {
+	channelContext
 	args           []string
 	stdin          readLiner
 	stdout, stderr io.Writer
 	pty            bool
-	user           string
+	// height is the number of rows of the terminal if there's a pty, shared with the session so it follows
+	// window changes. It's read with atomic.LoadUint32.
+	height *uint32
+	user   string
+	env    map[string]string
+	state  *shellState
+	// done is closed once the session ends, interrupting commands that block.
+	done <-chan struct{}
+}
+
+// shellState is the mutable state of a shell session, shared by every command run in it.
+type shellState struct {
+	history []string
+	// lineNumber is the line of input currently being run by the shell, as reported in dash error messages.
+	lineNumber int
+	fs         *FileSystemType
+	input      *idleReader
+	// archives holds the members of the archives created with tar, by archive file.
+	archives map[*FileSystemNode][]string
+	// motdShown is set once the login shell has written the MOTD.
+	motdShown bool
+	// crontabs holds the crontabs installed with crontab, by user.
+	crontabs map[string]string
+	// hostname is set once the hostname has been changed with the hostname command.
+	hostname string
+	// baitRead holds the bait files read by the command being run.
+	baitRead []string
+}
+
+// waitForInput waits for a read abandoned by an idle timeout to return, which it does once the channel is closed.
+func (state *shellState) waitForInput() {
+	if state.input != nil && state.input.pending {
+		<-state.input.results
+		state.input.pending = false
+	}
+}
+
+var errIdleTimeout = errors.New("timed out waiting for input")
+
+type readLineResult struct {
+	line string
+	err  error
+}
+
+// idleReader reads lines in the background so that waiting for them can time out.
+// At most one read is in flight, a timed out read is picked up by the next call.
+// Once a shell has one, it reads all its lines through it, waiting indefinitely if timeout isn't positive.
+type idleReader struct {
+	readLiner
+	results chan readLineResult
+	pending bool
+}
+
+func (r *idleReader) readLineTimeout(timeout time.Duration) (string, error) {
+	if !r.pending {
+		r.pending = true
+		go func() {
+			line, err := r.ReadLine()
+			r.results <- readLineResult{line, err}
+		}()
+	}
+	if timeout <= 0 {
+		result := <-r.results
+		r.pending = false
+		return result.line, result.err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-r.results:
+		r.pending = false
+		return result.line, result.err
+	case <-timer.C:
+		return "", errIdleTimeout
+	}
 }
 
 type command interface {
@@ -25,78 +118,378 @@ type command interface {
 }
 
 var commands = map[string]command{
-	"sh":    cmdShell{},
-	"true":  cmdTrue{},
-	"false": cmdFalse{},
-	"echo":  cmdEcho{},
-	"cat":   cmdCat{},
-	"ls":    cmdLs{},
-	"touch": cmdTouch{},
-	"mkdir": cmdMkdir{},
-	"cd":    cmdCd{},
-	"pwd":   cmdPwd{},
-	"su":    cmdSu{},
+	"sh":       cmdShell{},
+	"true":     cmdTrue{},
+	"false":    cmdFalse{},
+	"echo":     cmdEcho{},
+	"env":      cmdEnv{},
+	"history":  cmdHistory{},
+	"ps":       cmdPs{},
+	"kill":     cmdKill{},
+	"crontab":  cmdCrontab{},
+	"apt":      cmdApt{},
+	"apt-get":  cmdApt{},
+	"yum":      cmdYum{},
+	"dnf":      cmdYum{},
+	"netstat":  cmdNetstat{},
+	"ping":     cmdPing{},
+	"ss":       cmdSs{},
+	"df":       cmdDf{},
+	"free":     cmdFree{},
+	"find":     cmdFind{},
+	"tar":      cmdTar{},
+	"chmod":    cmdChmod{},
+	"chown":    cmdChown{},
+	"date":     cmdDate{},
+	"clear":    cmdClear{},
+	"hostname": cmdHostname{},
+	"uname":    cmdUname{},
+	"cat":      cmdCat{name: "cat"},
+	"base64":   cmdBase64{},
+	"ls":       cmdLs{},
+	"touch":    cmdTouch{},
+	"mkdir":    cmdMkdir{},
+	"cd":       cmdCd{},
+	"pwd":      cmdPwd{},
+	"su":       cmdSu{},
+	"sudo":     cmdSudo{},
+	"passwd":   cmdPasswd{},
+	"ftp":      cmdFtp{},
+	"sftp":     cmdSftp{},
+	"wget":     cmdWget{},
+	"curl":     cmdCurl{},
+	"aws":      cmdAws,
+	"gcloud":   cmdGcloud,
+}
+
+// registerCommand makes a command available under name, which mustn't be taken already.
+// It's meant to be called from the init functions of the files implementing commands.
+func registerCommand(name string, c command) {
+	if _, exists := commands[name]; exists {
+		panic(fmt.Sprintf("command %q registered twice", name))
+	}
+	commands[name] = c
+}
+
+// lookupCommand returns the command run for name, or nil if there's none or it's disabled in the config.
+func lookupCommand(cfg *config, name string) command {
+	for _, disabled := range cfg.Shell.DisabledCommands {
+		if disabled == name {
+			return nil
+		}
+	}
+	return commands[name]
 }
 
 var shellProgram = []string{"sh"}
 
+var commandsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sshesame_commands_total",
+	Help: "Total number of commands run, by command name or unknown",
+}, []string{"command", "exit_status"})
+
 func executeProgram(context commandContext) (uint32, error) {
 	if len(context.args) == 0 {
 		return 0, nil
 	}
-	command := commands[context.args[0]]
-	if command == nil {
-		_, err := fmt.Fprintf(context.stderr, "%v: command not found\n", context.args[0])
-		return 127, err
+	var status uint32
+	var err error
+	// Commands such as cd change the working directory, log the one the command was run in.
+	cwd := context.state.fs.Path
+	commandLabel := context.args[0]
+	// Nested commands such as sudo's log their own bait reads, which also count for the outer command.
+	outerBaitRead := context.state.baitRead
+	context.state.baitRead = nil
+	if command := lookupCommand(context.cfg, context.args[0]); command != nil {
+		status, err = command.execute(context)
+	} else {
+		commandLabel = "unknown"
+		status = 127
+		_, err = fmt.Fprintln(context.stderr, notFoundMessage(context, context.args[0]))
+	}
+	commandsMetric.WithLabelValues(commandLabel, fmt.Sprint(status)).Inc()
+	baitRead := context.state.baitRead
+	context.state.baitRead = append(outerBaitRead, baitRead...)
+	context.logEvent(commandLog{
+		channelLog:   channelLog{ChannelID: context.channelID},
+		Command:      context.args[0],
+		Args:         context.args[1:],
+		ExitStatus:   status,
+		User:         context.user,
+		Cwd:          cwd,
+		BaitAccessed: len(baitRead) > 0,
+		BaitFiles:    baitRead,
+	})
+	return status, err
+}
+
+// notFoundMessage formats the error for an unknown command like the configured shell flavor does.
+func notFoundMessage(context commandContext, name string) string {
+	switch context.cfg.Shell.Flavor {
+	case "bash":
+		return fmt.Sprintf("bash: %v: command not found", name)
+	case "dash":
+		return fmt.Sprintf("sh: %v: %v: not found", context.state.lineNumber, name)
+	default:
+		return fmt.Sprintf("%v: command not found", name)
+	}
+}
+
+// readPassword prompts for a password without echoing it if stdin supports that.
+func readPassword(context commandContext, prompt string) (string, error) {
+	if reader, ok := context.stdin.(passwordReader); ok {
+		return reader.ReadPassword(prompt)
+	}
+	if _, err := fmt.Fprint(context.stdout, prompt); err != nil {
+		return "", err
 	}
-	return command.execute(context)
+	return context.stdin.ReadLine()
 }
 
 type cmdShell struct{}
 
 func (cmdShell) execute(context commandContext) (uint32, error) {
-	var prompt string
+	if len(context.args) > 1 && context.args[1] == "-c" {
+		if len(context.args) < 3 {
+			_, err := fmt.Fprintln(context.stderr, "sh: 0: -c requires an argument")
+			return 2, err
+		}
+		status, _, err := runCommandLine(context, context.args[2], 0, 1)
+		return status, err
+	}
 	if context.pty {
-		switch context.user {
-		case "root":
-			prompt = "# "
-		default:
-			prompt = "$ "
+		if !context.state.motdShown {
+			context.state.motdShown = true
+			if err := writeMOTD(context, context.stdout); err != nil {
+				return 0, err
+			}
 		}
 	}
 	var lastStatus uint32
 	var line string
 	var err error
 	for {
-		_, err = fmt.Fprint(context.stdout, prompt)
-		if err != nil {
+		if context.pty {
+			_, err = fmt.Fprint(context.stdout, expandPrompt(context))
+			if err != nil {
+				return lastStatus, err
+			}
+		}
+		if timeout := context.cfg.Shell.IdleTimeout; timeout > 0 || context.state.input != nil {
+			if context.state.input == nil {
+				context.state.input = &idleReader{readLiner: context.stdin, results: make(chan readLineResult, 1)}
+			}
+			line, err = context.state.input.readLineTimeout(timeout)
+		} else {
+			line, err = context.stdin.ReadLine()
+		}
+		if err == errIdleTimeout {
+			_, err = fmt.Fprintf(context.stderr, "\r\n%v: auto-logout\r\n", errIdleTimeout)
 			return lastStatus, err
 		}
-		line, err = context.stdin.ReadLine()
 		if err != nil {
 			return lastStatus, err
 		}
-		args := strings.Fields(line)
-		if len(args) == 0 {
+		command := strings.TrimSpace(line)
+		if command == "" {
 			continue
 		}
-		if args[0] == "exit" {
-			var err error
-			var status = uint64(lastStatus)
-			if len(args) > 1 {
-				status, err = strconv.ParseUint(args[1], 10, 32)
-				if err != nil {
-					status = 255
-				}
+		context.state.history = append(context.state.history, command)
+		context.logEvent(shellCommandLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			Command:    command,
+		})
+		var exit bool
+		if lastStatus, exit, err = runCommandLine(context, command, lastStatus, len(context.state.history)); err != nil || exit {
+			return lastStatus, err
+		}
+	}
+}
+
+// homeDirectory returns the home directory of a user.
+func homeDirectory(user string) string {
+	if user == "root" {
+		return "/root"
+	}
+	return "/home/" + user
+}
+
+// expandPrompt expands the bash prompt escapes in the configured prompt, "\$ " if none is configured.
+func expandPrompt(context commandContext) string {
+	prompt := context.cfg.Shell.Prompt
+	if prompt == "" {
+		prompt = `\$ `
+	}
+	cwd := context.state.fs.Path
+	if home := homeDirectory(context.user); cwd == home || strings.HasPrefix(cwd, home+"/") {
+		cwd = "~" + strings.TrimPrefix(cwd, home)
+	}
+	hostname := hostname(context)
+	var result strings.Builder
+	for i := 0; i < len(prompt); i++ {
+		if prompt[i] != '\\' || i == len(prompt)-1 {
+			result.WriteByte(prompt[i])
+			continue
+		}
+		i++
+		switch prompt[i] {
+		case 'u':
+			result.WriteString(context.user)
+		case 'h':
+			result.WriteString(strings.SplitN(hostname, ".", 2)[0])
+		case 'H':
+			result.WriteString(hostname)
+		case 'w':
+			result.WriteString(cwd)
+		case 'W':
+			if cwd == "~" || cwd == "/" {
+				result.WriteString(cwd)
+			} else {
+				result.WriteString(filepath.Base(cwd))
+			}
+		case '$':
+			if context.user == "root" {
+				result.WriteByte('#')
+			} else {
+				result.WriteByte('$')
 			}
-			return uint32(status), nil
+		case '\\':
+			result.WriteByte('\\')
+		default:
+			result.WriteByte('\\')
+			result.WriteByte(prompt[i])
 		}
-		newContext := context
-		newContext.args = args
-		if lastStatus, err = executeProgram(newContext); err != nil {
-			return lastStatus, err
+	}
+	return result.String()
+}
+
+// runCommandLine parses and runs a single line of shell input, returning its status and whether the shell should exit.
+func runCommandLine(context commandContext, line string, lastStatus uint32, lineNumber int) (uint32, bool, error) {
+	args, parseErr := splitCommandLine(line, func(name string) string {
+		if name == "?" {
+			return fmt.Sprint(lastStatus)
+		}
+		return context.env[name]
+	})
+	if parseErr != nil {
+		_, err := fmt.Fprintf(context.stderr, "sh: %v: Syntax error: %v\n", lineNumber, parseErr)
+		return 2, false, err
+	}
+	if len(args) == 0 {
+		return lastStatus, false, nil
+	}
+	if args[0] == "exit" || args[0] == "logout" {
+		var err error
+		var status = uint64(lastStatus)
+		if len(args) > 1 {
+			status, err = strconv.ParseUint(args[1], 10, 32)
+			if err != nil {
+				status = 255
+			}
+		}
+		if args[0] == "logout" && context.pty {
+			_, err = fmt.Fprintln(context.stdout, "logout")
+			return uint32(status), true, err
+		}
+		return uint32(status), true, nil
+	}
+	delay, transientError := context.cfg.flakiness.next(args[0])
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-context.done:
+			timer.Stop()
+			return 129, true, nil
+		}
+	}
+	if transientError != "" {
+		_, err := fmt.Fprintln(context.stderr, transientError)
+		return 126, false, err
+	}
+	context.state.lineNumber = lineNumber
+	newContext := context
+	newContext.args = args
+	status, err := executeProgram(newContext)
+	return status, false, err
+}
+
+var errUnterminatedQuote = errors.New("Unterminated quoted string")
+
+// splitCommandLine splits line into words like sh does, honoring quotes and backslash escapes.
+// Variables are expanded using lookup, and unquoted words expanding to nothing are dropped.
+func splitCommandLine(line string, lookup func(name string) string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote byte
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\\' && (quote == 0 || (i+1 < len(line) && strings.IndexByte("$`\"\\", line[i+1]) >= 0)):
+			if i+1 < len(line) {
+				i++
+				word.WriteByte(line[i])
+			}
+			quoted = true
+		case c == '"' && quote == '"':
+			quote = 0
+		case c == '$':
+			name, length := variableName(line[i+1:])
+			if length == 0 {
+				word.WriteByte(c)
+				continue
+			}
+			word.WriteString(lookup(name))
+			i += length
+		case (c == '\'' || c == '"') && quote == 0:
+			quote = c
+			quoted = true
+		case (c == ' ' || c == '\t') && quote == 0:
+			if word.Len() > 0 || quoted {
+				words = append(words, word.String())
+			}
+			word.Reset()
+			quoted = false
+		default:
+			word.WriteByte(c)
 		}
 	}
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if word.Len() > 0 || quoted {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// variableName parses the name of the variable referenced after a $, returning it and how many bytes it spans.
+func variableName(s string) (string, int) {
+	if s == "" {
+		return "", 0
+	}
+	if s[0] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	if strings.IndexByte("?$#*@!-0123456789", s[0]) >= 0 {
+		return s[:1], 1
+	}
+	length := 0
+	for length < len(s) && (s[length] == '_' || unicode.IsLetter(rune(s[length])) || (length > 0 && unicode.IsDigit(rune(s[length])))) {
+		length++
+	}
+	return s[:length], length
 }
 
 type cmdTrue struct{}
@@ -113,98 +506,277 @@ func (cmdFalse) execute(context commandContext) (uint32, error) {
 	return 1, nil
 }
 
+type cmdClear struct{}
+
+// clearScreen moves the cursor home and erases the screen and scrollback, like clear does on an xterm.
+const clearScreen = "\x1b[H\x1b[2J\x1b[3J"
+
+func (cmdClear) execute(context commandContext) (uint32, error) {
+	if !context.pty {
+		return 0, nil
+	}
+	_, err := fmt.Fprint(context.stdout, clearScreen)
+	return 0, err
+}
+
 type cmdEcho struct{}
 
 func (cmdEcho) execute(context commandContext) (uint32, error) {
-	_, err := fmt.Fprintln(context.stdout, strings.Join(context.args[1:], " "))
+	args := context.args[1:]
+	newline, escapes := true, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && strings.Trim(args[0][1:], "neE") == "" {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+	output := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		output, stop = echoEscapes(output)
+		newline = newline && !stop
+	}
+	if newline {
+		output += "\n"
+	}
+	_, err := fmt.Fprint(context.stdout, output)
 	return 0, err
 }
 
-type FileSystemNode struct {
-	IsDir    bool
-	Content  string
-	Children map[string]*FileSystemNode
-	Parent   *FileSystemNode
+var echoEscapeChars = map[byte]byte{'a': '\a', 'b': '\b', 'e': 0x1b, 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v', '\\': '\\'}
+
+// echoEscapes interprets backslash escapes like echo -e, reporting whether \c stopped the output.
+func echoEscapes(s string) (string, bool) {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			result.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; {
+		case c == 'c':
+			return result.String(), true
+		case echoEscapeChars[c] != 0:
+			result.WriteByte(echoEscapeChars[c])
+		case c == '0' || c == 'x':
+			base, digits, maxDigits := 8, "01234567", 3
+			if c == 'x' {
+				base, digits, maxDigits = 16, "0123456789abcdefABCDEF", 2
+			}
+			end := i + 1
+			for end < len(s) && end-i-1 < maxDigits && strings.IndexByte(digits, s[end]) != -1 {
+				end++
+			}
+			if c == 'x' && end == i+1 {
+				result.WriteString("\\x")
+				continue
+			}
+			value, _ := strconv.ParseUint("0"+s[i+1:end], base, 8)
+			result.WriteByte(byte(value))
+			i = end - 1
+		default:
+			result.WriteByte('\\')
+			result.WriteByte(c)
+		}
+	}
+	return result.String(), false
 }
 
-type FileSystemType struct {
-	Root    *FileSystemNode
-	Current *FileSystemNode
-	Path    string
+type cmdDate struct{}
+
+func (cmdDate) execute(context commandContext) (uint32, error) {
+	now := context.cfg.clock.now()
+	format := "%a %b %e %H:%M:%S %Z %Y"
+	for _, arg := range context.args[1:] {
+		switch {
+		case arg == "-u" || arg == "--utc":
+			now = now.UTC()
+		case strings.HasPrefix(arg, "+"):
+			format = arg[1:]
+		default:
+			_, err := fmt.Fprintf(context.stderr, "date: invalid date '%v'\n", arg)
+			return 1, err
+		}
+	}
+	_, err := fmt.Fprintln(context.stdout, strftime(format, now))
+	return 0, err
 }
 
-var FileSystem = FileSystemType{
-	Root: &FileSystemNode{
-		IsDir:    true,
-		Children: make(map[string]*FileSystemNode),
-	},
-	Path: "/",
+type cmdHistory struct{}
+
+func (cmdHistory) execute(context commandContext) (uint32, error) {
+	for i, line := range append(savedHistory(context), context.state.history...) {
+		if _, err := fmt.Fprintf(context.stdout, "%5d  %v\n", i+1, line); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
 }
 
-func init() {
-	FileSystem.Current = FileSystem.Root
-	FileSystem.Root.Children["usr.txt"] = &FileSystemNode{Content: "eberk0, cswyne, edan, aroullier, john, henk"}
-	FileSystem.Root.Children["pwd.txt"] = &FileSystemNode{Content: "$2a$04$3ise9UoQ38ceyn6qUmb8neC8UyQnfNiog8ObMSPx.4KLV/vYU0XaC, $2a$04$Z2Orf4kkPuwncqrXae7L1uE5elj1Em9fhw4f8PmwS4POBAdvfzRPa, $2a$04$NkF1cDQf6CSkF83zfucmtO8.yChntXtG8HLB2zJJiZTiKIR2yHbTa, $2a$04$VFAUxOCo5hZuKjQqN6FW/.6TNoLQjFdId02Fk0pPhC0NmWiyUjwCW, $2a$04$y/dBmr4B7zWaNGpTNpjqUuZRHz9bxBaH0LwfEouan2283rBxoLWxu, $2a$04$ATK3lPdtQokdeoBJh.aOweV9h9yU6SMSQ24b7jXDZeUoHC0sMWmZS"}
-	FileSystem.Root.Children["checking_account.txt"] = &FileSystemNode{Content: "null, 4936739041871256, null, 5133014750298309, 3531203913896199, 4405957561612502"}
+type cmdEnv struct{}
+
+func (cmdEnv) execute(context commandContext) (uint32, error) {
+	names := make([]string, 0, len(context.env))
+	for name := range context.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := fmt.Fprintf(context.stdout, "%v=%v\n", name, context.env[name]); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
 }
 
 type cmdPwd struct{}
 
 func (cmdPwd) execute(context commandContext) (uint32, error) {
-	_, err := fmt.Fprintln(context.stdout, FileSystem.Path)
+	_, err := fmt.Fprintln(context.stdout, context.state.fs.Path)
 	return 0, err
 }
 
 type cmdMkdir struct{}
 
 func (cmdMkdir) execute(context commandContext) (uint32, error) {
-	if len(context.args) < 2 {
+	parents := false
+	var dirs []string
+	for _, arg := range context.args[1:] {
+		if arg == "--parents" || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "p") {
+			parents = true
+			continue
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			continue
+		}
+		dirs = append(dirs, arg)
+	}
+	if len(dirs) == 0 {
 		_, err := fmt.Fprintln(context.stderr, "mkdir: missing operand")
 		return 1, err
 	}
 
-	for _, dir := range context.args[1:] {
-		parts := strings.Split(filepath.Clean(dir), "/")
-		node := FileSystem.Current
-		for _, part := range parts {
-			if part == "" {
+	var status uint32
+	for _, dir := range dirs {
+		// fail reports why dir can't be created, giving up on it.
+		fail := func(reason interface{}) error {
+			status = 1
+			_, err := fmt.Fprintf(context.stderr, "mkdir: cannot create directory '%s': %v\n", dir, reason)
+			return err
+		}
+		node := context.state.fs.Current
+		if strings.HasPrefix(dir, "/") {
+			node = context.state.fs.Root
+		}
+		var parts []string
+		for _, part := range strings.Split(filepath.Clean(dir), "/") {
+			if part != "" && part != "." {
+				parts = append(parts, part)
+			}
+		}
+		// Without -p, the directory itself mustn't exist, which is always the case for "/" and ".".
+		if len(parts) == 0 {
+			if !parents {
+				if err := fail("File exists"); err != nil {
+					return 1, err
+				}
+			}
+			continue
+		}
+		for i, part := range parts {
+			last := i == len(parts)-1
+			if !node.IsDir {
+				if err := fail("Not a directory"); err != nil {
+					return 1, err
+				}
+				break
+			}
+			if part == ".." {
+				if node.Parent != nil {
+					node = node.Parent
+				}
+				continue
+			}
+			child, exists := node.Children[part]
+			if exists {
+				if last && (!parents || !child.IsDir) {
+					if err := fail("File exists"); err != nil {
+						return 1, err
+					}
+					break
+				}
+				node = child
 				continue
 			}
-			if _, exists := node.Children[part]; !exists {
-				node.Children[part] = &FileSystemNode{
-					IsDir:    true,
-					Children: make(map[string]*FileSystemNode),
-					Parent:   node, // Set parent reference
+			if !last && !parents {
+				if err := fail("No such file or directory"); err != nil {
+					return 1, err
 				}
+				break
 			}
-			node = node.Children[part]
+			if !node.canWrite(context.user) {
+				if err := fail("Permission denied"); err != nil {
+					return 1, err
+				}
+				break
+			}
+			child, err := context.state.fs.create(node, part, true, context.user)
+			if err != nil {
+				if err := fail(err); err != nil {
+					return 1, err
+				}
+				break
+			}
+			node = child
 		}
 	}
-	return 0, nil
+	return status, nil
 }
 
 type cmdCd struct{}
 
+// changeDirectory makes node at path the current directory, remembering the one it replaces.
+func changeDirectory(fs *FileSystemType, node *FileSystemNode, path string) {
+	fs.Previous, fs.PreviousPath = fs.Current, fs.Path
+	fs.Current, fs.Path = node, path
+}
+
 func (cmdCd) execute(context commandContext) (uint32, error) {
+	fs := context.state.fs
 	if len(context.args) < 2 {
-		FileSystem.Current = FileSystem.Root
-		FileSystem.Path = "/"
+		changeDirectory(fs, fs.Root, "/")
 		return 0, nil
 	}
+	if context.args[1] == "-" {
+		if fs.Previous == nil {
+			_, err := fmt.Fprintln(context.stderr, "cd: OLDPWD not set")
+			return 1, err
+		}
+		changeDirectory(fs, fs.Previous, fs.PreviousPath)
+		_, err := fmt.Fprintln(context.stdout, fs.Path)
+		return 0, err
+	}
 	targetPath := filepath.Clean(context.args[1])
 	if targetPath == "/" {
-		FileSystem.Current = FileSystem.Root
-		FileSystem.Path = "/"
+		changeDirectory(fs, fs.Root, "/")
 		return 0, nil
 	}
 	parts := strings.Split(targetPath, "/")
-	node := FileSystem.Current
+	node := context.state.fs.Current
 	var subfolders []string
 	if strings.HasPrefix(targetPath, "/") {
-		node = FileSystem.Root
+		node = context.state.fs.Root
 		subfolders = []string{}
 	} else {
-		subfolders = strings.Split(FileSystem.Path, "/")
+		subfolders = strings.Split(context.state.fs.Path, "/")
 	}
 
 	for _, part := range parts {
@@ -228,40 +800,433 @@ func (cmdCd) execute(context commandContext) (uint32, error) {
 		}
 	}
 
-	FileSystem.Current = node
-	FileSystem.Path = filepath.Clean("/" + strings.Join(subfolders, "/"))
+	changeDirectory(fs, node, filepath.Clean("/"+strings.Join(subfolders, "/")))
 	return 0, nil
 }
 
-type cmdCat struct{}
+// cmdCat prints files, as cat does or as pagers do when pager is set.
+type cmdCat struct {
+	name  string
+	pager bool
+}
 
-func (cmdCat) execute(context commandContext) (uint32, error) {
+func (c cmdCat) execute(context commandContext) (uint32, error) {
 	if len(context.args) < 2 {
-		_, err := fmt.Fprintln(context.stderr, "cat: missing operand")
+		_, err := fmt.Fprintf(context.stderr, "%v: missing operand\n", c.name)
 		return 1, err
 	}
+	var status uint32
 	for _, file := range context.args[1:] {
-		if node, exists := FileSystem.Current.Children[file]; exists && !node.IsDir {
-			_, err := fmt.Fprintln(context.stdout, node.Content)
-			return 0, err
-		} else {
-			_, err := fmt.Fprintf(context.stderr, "cat: %s: No such file or directory\n", file)
+		node := context.state.fs.lookup(file)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "%v: %s: No such file or directory\n", c.name, file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if node.IsDir {
+			if _, err := fmt.Fprintf(context.stderr, "%v: %s: Is a directory\n", c.name, file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if !node.canRead(context.user) {
+			if _, err := fmt.Fprintf(context.stderr, "%v: %s: Permission denied\n", c.name, file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if node.Canary {
+			context.logEvent(canaryLog{
+				channelLog: channelLog{ChannelID: context.channelID},
+				Path:       context.state.fs.absPath(file),
+			})
+		}
+		if node.Bait {
+			context.state.baitRead = append(context.state.baitRead, context.state.fs.absPath(file))
+		}
+		if node.ContentReader != nil {
+			// Lazy content is streamed rather than paged, so it's never held whole
+			if err := copyLines(context.stdout, node.reader()); err != nil {
+				return 1, err
+			}
+			continue
+		}
+		content := node.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if c.pager || context.cfg.Shell.Pager {
+			if err := writePaged(context, content); err != nil {
+				return 1, err
+			}
+		} else if _, err := fmt.Fprint(context.stdout, content); err != nil {
 			return 1, err
 		}
 	}
-	return 0, nil
+	return status, nil
+}
+
+// copyLines streams reader to writer, terminating the last line if it isn't.
+func copyLines(writer io.Writer, reader io.Reader) error {
+	buffer := make([]byte, 32*1024)
+	last := byte('\n')
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, err := writer.Write(buffer[:n]); err != nil {
+				return err
+			}
+			last = buffer[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if last != '\n' {
+		_, err := fmt.Fprintln(writer)
+		return err
+	}
+	return nil
 }
 
 type cmdLs struct{}
 
 func (cmdLs) execute(context commandContext) (uint32, error) {
-	for file := range FileSystem.Current.Children {
-		_, err := fmt.Fprintln(context.stdout, file)
+	long, all := false, false
+	var paths []string
+	for _, arg := range context.args[1:] {
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			long = long || strings.Contains(arg, "l")
+			all = all || strings.ContainsAny(arg, "aA")
+			continue
+		}
+		paths = append(paths, arg)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	now := context.cfg.clock.now()
+	printNode := func(node *FileSystemNode, name string) error {
+		if !long {
+			_, err := fmt.Fprintln(context.stdout, name)
+			return err
+		}
+		links, size := 1, node.size()
+		if node.IsDir {
+			links, size = 2, 4096
+			for _, child := range node.Children {
+				if child.IsDir {
+					links++
+				}
+			}
+		}
+		_, err := fmt.Fprintf(context.stdout, "%v %v %v %v %5v %v %v\n", node.fileMode(), links, node.owner(), node.group(), size, now.Format("Jan _2 15:04"), name)
+		return err
+	}
+	var status uint32
+	for i, path := range paths {
+		node := context.state.fs.lookup(path)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "ls: cannot access '%s': No such file or directory\n", path); err != nil {
+				return 2, err
+			}
+			status = 2
+			continue
+		}
+		if !node.IsDir {
+			if err := printNode(node, path); err != nil {
+				return 2, err
+			}
+			continue
+		}
+		if !node.canRead(context.user) {
+			if _, err := fmt.Fprintf(context.stderr, "ls: cannot open directory '%s': Permission denied\n", path); err != nil {
+				return 2, err
+			}
+			status = 2
+			continue
+		}
+		if len(paths) > 1 {
+			header := fmt.Sprintf("%v:", path)
+			if i > 0 {
+				header = "\n" + header
+			}
+			if _, err := fmt.Fprintln(context.stdout, header); err != nil {
+				return 2, err
+			}
+		}
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			if all || !strings.HasPrefix(name, ".") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if long {
+			if _, err := fmt.Fprintf(context.stdout, "total %v\n", 4*len(names)); err != nil {
+				return 2, err
+			}
+		}
+		for _, name := range names {
+			if err := printNode(node.Children[name], name); err != nil {
+				return 2, err
+			}
+		}
+	}
+	return status, nil
+}
+
+// parseMode applies a chmod mode, either octal or symbolic like "u+x,go-w", to the current permission bits.
+func parseMode(mode string, current os.FileMode, isDir bool) (os.FileMode, error) {
+	invalid := fmt.Errorf("invalid mode: '%v'", mode)
+	if mode == "" {
+		return 0, invalid
+	}
+	if mode[0] >= '0' && mode[0] <= '9' {
+		octal, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || octal > 0777 {
+			return 0, invalid
+		}
+		return os.FileMode(octal), nil
+	}
+	result := current
+	for _, clause := range strings.Split(mode, ",") {
+		var who os.FileMode
+		i := 0
+	who:
+		for ; i < len(clause); i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			case 'a':
+				who |= 0777
+			default:
+				break who
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		if i == len(clause) {
+			return 0, invalid
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if strings.IndexByte("+-=", op) < 0 {
+				return 0, invalid
+			}
+			i++
+			var perms os.FileMode
+			for ; i < len(clause) && strings.IndexByte("rwxX", clause[i]) >= 0; i++ {
+				switch clause[i] {
+				case 'r':
+					perms |= 0444
+				case 'w':
+					perms |= 0222
+				case 'x':
+					perms |= 0111
+				case 'X':
+					if isDir || current&0111 != 0 {
+						perms |= 0111
+					}
+				}
+			}
+			switch op {
+			case '+':
+				result |= perms & who
+			case '-':
+				result &^= perms & who
+			case '=':
+				result = result&^who | perms&who
+			}
+		}
+	}
+	return result, nil
+}
+
+type cmdChmod struct{}
+
+func (cmdChmod) execute(context commandContext) (uint32, error) {
+	var args []string
+	for _, arg := range context.args[1:] {
+		if arg != "-R" && arg != "-v" && arg != "-f" && arg != "-c" {
+			args = append(args, arg)
+		}
+	}
+	if len(args) < 2 {
+		_, err := fmt.Fprintln(context.stderr, "chmod: missing operand\nTry 'chmod --help' for more information.")
+		return 1, err
+	}
+	var status uint32
+	for _, file := range args[1:] {
+		node := context.state.fs.lookup(file)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "chmod: cannot access '%s': No such file or directory\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		mode, err := parseMode(args[0], node.Mode, node.IsDir)
 		if err != nil {
+			_, err := fmt.Fprintf(context.stderr, "chmod: %v\nTry 'chmod --help' for more information.\n", err)
 			return 1, err
 		}
+		if context.user != "root" && context.user != node.owner() {
+			if _, err := fmt.Fprintf(context.stderr, "chmod: changing permissions of '%s': Operation not permitted\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		node.Mode = mode
 	}
-	return 0, nil
+	return status, nil
+}
+
+type cmdChown struct{}
+
+func (cmdChown) execute(context commandContext) (uint32, error) {
+	var args []string
+	for _, arg := range context.args[1:] {
+		if arg != "-R" && arg != "-v" && arg != "-f" && arg != "-c" && arg != "-h" {
+			args = append(args, arg)
+		}
+	}
+	if len(args) < 2 {
+		_, err := fmt.Fprintln(context.stderr, "chown: missing operand\nTry 'chown --help' for more information.")
+		return 1, err
+	}
+	owner, group, hasGroup := strings.Cut(args[0], ":")
+	if !hasGroup {
+		owner, group, hasGroup = strings.Cut(args[0], ".")
+	}
+	if owner == "" && group == "" {
+		_, err := fmt.Fprintf(context.stderr, "chown: invalid spec: '%v'\n", args[0])
+		return 1, err
+	}
+	var status uint32
+	for _, file := range args[1:] {
+		node := context.state.fs.lookup(file)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "chown: cannot access '%s': No such file or directory\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		// Only root can give files away or change their group here
+		if context.user != "root" {
+			if _, err := fmt.Fprintf(context.stderr, "chown: changing ownership of '%s': Operation not permitted\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if owner != "" {
+			node.Group = node.group()
+			node.Owner = owner
+		}
+		if hasGroup && group != "" {
+			node.Group = group
+		}
+	}
+	return status, nil
+}
+
+type cmdFind struct{}
+
+func (cmdFind) execute(context commandContext) (uint32, error) {
+	var starts []string
+	args := context.args[1:]
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		starts = append(starts, args[0])
+		args = args[1:]
+	}
+	if len(starts) == 0 {
+		starts = []string{"."}
+	}
+	var namePattern, fileType string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-name", "-type":
+			if i+1 == len(args) {
+				_, err := fmt.Fprintf(context.stderr, "find: missing argument to `%v'\n", args[i])
+				return 1, err
+			}
+			if args[i] == "-name" {
+				namePattern = args[i+1]
+			} else {
+				fileType = args[i+1]
+			}
+			i++
+		default:
+			_, err := fmt.Fprintf(context.stderr, "find: unknown predicate `%v'\n", args[i])
+			return 1, err
+		}
+	}
+	if fileType != "" && fileType != "f" && fileType != "d" {
+		_, err := fmt.Fprintf(context.stderr, "find: Unknown argument to -type: %v\n", fileType)
+		return 1, err
+	}
+	if _, err := filepath.Match(namePattern, ""); err != nil {
+		_, err := fmt.Fprintf(context.stderr, "find: invalid pattern `%v'\n", namePattern)
+		return 1, err
+	}
+
+	var status uint32
+	var walk func(node *FileSystemNode, nodePath, name string) error
+	walk = func(node *FileSystemNode, nodePath, name string) error {
+		matched := namePattern == ""
+		if !matched {
+			matched, _ = filepath.Match(namePattern, name)
+		}
+		if matched && (fileType == "" || (fileType == "d") == node.IsDir) {
+			if _, err := fmt.Fprintln(context.stdout, nodePath); err != nil {
+				return err
+			}
+		}
+		names := make([]string, 0, len(node.Children))
+		for childName := range node.Children {
+			names = append(names, childName)
+		}
+		sort.Strings(names)
+		for _, childName := range names {
+			childPath := nodePath + "/" + childName
+			if strings.HasSuffix(nodePath, "/") {
+				childPath = nodePath + childName
+			}
+			if err := walk(node.Children[childName], childPath, childName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, start := range starts {
+		node := context.state.fs.lookup(start)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "find: '%s': No such file or directory\n", start); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if err := walk(node, start, filepath.Base(start)); err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
 }
 
 type cmdTouch struct{}
@@ -271,20 +1236,411 @@ func (cmdTouch) execute(context commandContext) (uint32, error) {
 		_, err := fmt.Fprintln(context.stderr, "usage: touch [-A [-][[hh]mm]SS] [-achm] [-r file] [-t [[CC]YY]MMDDhhmm[.SS]]\n[-d YYYY-MM-DDThh:mm:SS[.frac][tz]] file ...")
 		return 1, err
 	}
+	var status uint32
 	for _, file := range context.args[1:] {
-		FileSystem.Current.Children[file] = &FileSystemNode{Content: ""}
+		dir, name := filepath.Split(file)
+		parent := context.state.fs.lookup(dir)
+		if parent == nil || !parent.IsDir {
+			if _, err := fmt.Fprintf(context.stderr, "touch: cannot touch '%s': No such file or directory\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if _, exists := parent.Children[name]; exists || name == "" {
+			continue
+		}
+		if !parent.canWrite(context.user) {
+			if _, err := fmt.Fprintf(context.stderr, "touch: cannot touch '%s': Permission denied\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if _, err := context.state.fs.create(parent, name, false, context.user); err != nil {
+			if _, err := fmt.Fprintf(context.stderr, "touch: cannot touch '%s': %v\n", file, err); err != nil {
+				return 1, err
+			}
+			status = 1
+		}
 	}
-	return 0, nil
+	return status, nil
 }
 
 type cmdSu struct{}
 
 func (cmdSu) execute(context commandContext) (uint32, error) {
-	newContext := context
-	newContext.user = "root"
-	if len(context.args) > 1 {
-		newContext.user = context.args[1]
+	user := "root"
+	for _, arg := range context.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			user = arg
+			break
+		}
+	}
+	if context.user != "root" {
+		if !context.pty {
+			_, err := fmt.Fprintln(context.stderr, "su: must be run from a terminal")
+			return 1, err
+		}
+		password, err := readPassword(context, "Password: ")
+		if endOfInput(err) {
+			_, err := fmt.Fprintln(context.stderr, "\nsu: Authentication failure")
+			return 1, err
+		}
+		if err != nil {
+			return 1, err
+		}
+		accepted := context.cfg.validCredentials(user, password)
+		context.logEvent(suLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			authLog:    authLog{User: user, Accepted: authAccepted(accepted)},
+			Password:   password,
+		})
+		if !accepted {
+			_, err := fmt.Fprintln(context.stderr, "su: Authentication failure")
+			return 1, err
+		}
 	}
+	newContext := context
+	newContext.user = user
 	newContext.args = shellProgram
 	return executeProgram(newContext)
 }
+
+type cmdSudo struct{}
+
+const sudoUsage = `usage: sudo -h | -K | -k | -V
+usage: sudo -v [-ABknS] [-g group] [-h host] [-p prompt] [-u user]
+usage: sudo -l [-ABknS] [-g group] [-h host] [-p prompt] [-U user] [-u user] [command]
+usage: sudo [-ABbEHknPS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] [VAR=value] [-i|-s] [<command>]
+usage: sudo -e [-ABknS] [-r role] [-t type] [-C num] [-D directory] [-g group] [-h host] [-p prompt] [-R directory] [-T timeout] [-u user] file ...`
+
+func (cmdSudo) execute(context commandContext) (uint32, error) {
+	user := "root"
+	args := context.args[1:]
+	var shell bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-u":
+			if len(args) < 2 {
+				_, err := fmt.Fprintln(context.stderr, "sudo: option requires an argument -- 'u'\n"+sudoUsage)
+				return 1, err
+			}
+			user = args[1]
+			args = args[1:]
+		case "-i", "-s":
+			shell = true
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 && !shell {
+		_, err := fmt.Fprintln(context.stderr, sudoUsage)
+		return 1, err
+	}
+	if context.user != "root" {
+		if !context.pty {
+			_, err := fmt.Fprintln(context.stderr, "sudo: a terminal is required to read the password; either use the -S option to read from standard input or configure an askpass helper")
+			return 1, err
+		}
+		password, err := readPassword(context, fmt.Sprintf("[sudo] password for %v: ", context.user))
+		if endOfInput(err) {
+			_, err := fmt.Fprintln(context.stderr, "\nsudo: a password is required")
+			return 1, err
+		}
+		if err != nil {
+			return 1, err
+		}
+		context.logEvent(sudoLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			User:       context.user,
+			Password:   password,
+		})
+	}
+	newContext := context
+	newContext.user = user
+	newContext.args = args
+	if len(args) == 0 {
+		newContext.args = shellProgram
+	}
+	return executeProgram(newContext)
+}
+
+type cmdPasswd struct{}
+
+func (cmdPasswd) execute(context commandContext) (uint32, error) {
+	user := context.user
+	if len(context.args) > 1 && !strings.HasPrefix(context.args[1], "-") {
+		user = context.args[1]
+	}
+	if user != context.user && context.user != "root" {
+		_, err := fmt.Fprintf(context.stderr, "passwd: You may not view or modify password information for %v.\n", user)
+		return 1, err
+	}
+	if !context.pty {
+		_, err := fmt.Fprintln(context.stderr, "passwd: Authentication token manipulation error\npasswd: password unchanged")
+		return 10, err
+	}
+	if _, err := fmt.Fprintf(context.stdout, "Changing password for %v.\n", user); err != nil {
+		return 1, err
+	}
+	entry := passwdLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		User:       user,
+	}
+	// unchanged logs the attempt and fails, after ending the prompt line if the input ended on it.
+	unchanged := func(prompt bool) (uint32, error) {
+		context.logEvent(entry)
+		if prompt {
+			if _, err := fmt.Fprintln(context.stderr); err != nil {
+				return 10, err
+			}
+		}
+		_, err := fmt.Fprintln(context.stderr, "passwd: Authentication token manipulation error\npasswd: password unchanged")
+		return 10, err
+	}
+	if context.user != "root" {
+		current, err := readPassword(context, "Current password: ")
+		if endOfInput(err) {
+			return unchanged(true)
+		}
+		if err != nil {
+			return 1, err
+		}
+		entry.CurrentPassword = current
+	}
+	matched := false
+	for attempt := 0; attempt < 2 && !matched; attempt++ {
+		password, err := readPassword(context, "New password: ")
+		if endOfInput(err) {
+			return unchanged(true)
+		}
+		if err != nil {
+			return 1, err
+		}
+		retyped, err := readPassword(context, "Retype new password: ")
+		if endOfInput(err) {
+			entry.NewPasswords = append(entry.NewPasswords, password)
+			return unchanged(true)
+		}
+		if err != nil {
+			return 1, err
+		}
+		entry.NewPasswords = append(entry.NewPasswords, password, retyped)
+		if matched = password == retyped; !matched {
+			if _, err := fmt.Fprintln(context.stderr, "Sorry, passwords do not match."); err != nil {
+				return 1, err
+			}
+		}
+	}
+	if !matched {
+		return unchanged(false)
+	}
+	context.logEvent(entry)
+	_, err := fmt.Fprintln(context.stdout, "passwd: password updated successfully")
+	return 0, err
+}
+
+// fileTransferShell runs the interactive prompt of a file transfer client, logging every command entered.
+func fileTransferShell(context commandContext, client string, handle func(args []string) (string, bool)) (uint32, error) {
+	for {
+		if _, err := fmt.Fprintf(context.stdout, "%v> ", client); err != nil {
+			return 0, err
+		}
+		line, err := context.stdin.ReadLine()
+		if endOfInput(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		context.logEvent(fileTransferCommandLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			Client:     client,
+			Command:    line,
+		})
+		output, done := handle(args)
+		if output != "" {
+			if _, err := fmt.Fprintln(context.stdout, output); err != nil {
+				return 0, err
+			}
+		}
+		if done {
+			return 0, nil
+		}
+	}
+}
+
+type cmdSftp struct{}
+
+func (cmdSftp) execute(context commandContext) (uint32, error) {
+	var target string
+	for i := 1; i < len(context.args); i++ {
+		arg := context.args[i]
+		if strings.HasPrefix(arg, "-") {
+			if len(arg) == 2 && strings.ContainsRune("BbcDFiJloPRSs", rune(arg[1])) {
+				i++
+			}
+			continue
+		}
+		target = arg
+		break
+	}
+	if target == "" {
+		_, err := fmt.Fprintln(context.stderr, "usage: sftp [-46AaCfNpqrv] [-B buffer_size] [-b batchfile] [-c cipher]\n          [-D sftp_server_command] [-F ssh_config] [-i identity_file]\n          [-J destination] [-l limit] [-o ssh_option] [-P port]\n          [-R num_requests] [-S program] [-s subsystem | sftp_server]\n          destination")
+		return 1, err
+	}
+	user := context.user
+	host := target
+	if at := strings.LastIndex(host, "@"); at != -1 {
+		user, host = host[:at], host[at+1:]
+	}
+	if colon := strings.Index(host, ":"); colon != -1 {
+		host = host[:colon]
+	}
+	var password string
+	if context.pty {
+		var err error
+		password, err = readPassword(context, fmt.Sprintf("%v@%v's password: ", user, host))
+		if endOfInput(err) {
+			_, err := fmt.Fprintln(context.stderr, "Connection closed")
+			return 255, err
+		}
+		if err != nil {
+			return 255, err
+		}
+	}
+	context.logEvent(fileTransferLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Client:     "sftp",
+		Target:     host,
+		User:       user,
+		Password:   password,
+	})
+	if !context.pty {
+		_, err := fmt.Fprintln(context.stderr, "Connection closed")
+		return 255, err
+	}
+	if _, err := fmt.Fprintf(context.stdout, "Connected to %v.\n", host); err != nil {
+		return 0, err
+	}
+	home := homeDirectory(user)
+	return fileTransferShell(context, "sftp", func(args []string) (string, bool) {
+		switch args[0] {
+		case "bye", "exit", "quit":
+			return "", true
+		case "ls", "lls", "cd", "lcd", "mkdir", "rm":
+			return "", false
+		case "pwd":
+			return fmt.Sprintf("Remote working directory: %v", home), false
+		case "get":
+			if len(args) < 2 {
+				return "You must specify at least one path after a get command.", false
+			}
+			return fmt.Sprintf("File \"%v/%v\" not found.", home, args[1]), false
+		case "put":
+			if len(args) < 2 {
+				return "You must specify at least one path after a put command.", false
+			}
+			return fmt.Sprintf("Uploading %v to %v/%v", args[1], home, filepath.Base(args[1])), false
+		default:
+			return "Invalid command.", false
+		}
+	})
+}
+
+type cmdFtp struct{}
+
+func (cmdFtp) execute(context commandContext) (uint32, error) {
+	var host string
+	for _, arg := range context.args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			host = arg
+			break
+		}
+	}
+	if host == "" {
+		return fileTransferShell(context, "ftp", func(args []string) (string, bool) {
+			switch args[0] {
+			case "bye", "exit", "quit":
+				return "", true
+			default:
+				return "Not connected.", false
+			}
+		})
+	}
+	user := context.user
+	if at := strings.LastIndex(host, "@"); at != -1 {
+		user, host = host[:at], host[at+1:]
+	}
+	if !context.pty {
+		context.logEvent(fileTransferLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			Client:     "ftp",
+			Target:     host,
+			User:       user,
+		})
+		_, err := fmt.Fprintln(context.stderr, "ftp: connect: Connection refused")
+		return 1, err
+	}
+	if _, err := fmt.Fprintf(context.stdout, "Connected to %v.\n220 (vsFTPd 3.0.3)\nName (%v:%v): ", host, host, user); err != nil {
+		return 0, err
+	}
+	name, err := context.stdin.ReadLine()
+	if endOfInput(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if name != "" {
+		user = name
+	}
+	if _, err := fmt.Fprintln(context.stdout, "331 Please specify the password."); err != nil {
+		return 0, err
+	}
+	password, err := readPassword(context, "Password:")
+	if endOfInput(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	context.logEvent(fileTransferLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Client:     "ftp",
+		Target:     host,
+		User:       user,
+		Password:   password,
+	})
+	if _, err := fmt.Fprintln(context.stdout, "230 Login successful.\nRemote system type is UNIX.\nUsing binary mode to transfer files."); err != nil {
+		return 0, err
+	}
+	return fileTransferShell(context, "ftp", func(args []string) (string, bool) {
+		switch args[0] {
+		case "bye", "exit", "quit":
+			return "221 Goodbye.", true
+		case "ls", "dir":
+			return "200 PORT command successful. Consider using PASV.\n150 Here comes the directory listing.\n226 Directory send OK.", false
+		case "pwd":
+			return "257 \"/\" is the current directory", false
+		case "cd":
+			return "250 Directory successfully changed.", false
+		case "get":
+			if len(args) < 2 {
+				return "(remote-file) usage: get remote-file [local-file]", false
+			}
+			return fmt.Sprintf("local: %v remote: %v\n200 PORT command successful. Consider using PASV.\n550 Failed to open file.", filepath.Base(args[1]), args[1]), false
+		case "put":
+			if len(args) < 2 {
+				return "(local-file) usage: put local-file [remote-file]", false
+			}
+			return fmt.Sprintf("local: %v remote: %v\n200 PORT command successful. Consider using PASV.\n150 Ok to send data.\n226 Transfer complete.", args[1], filepath.Base(args[1])), false
+		default:
+			return "?Invalid command.", false
+		}
+	})
+}
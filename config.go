@@ -6,33 +6,109 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/big"
+	"net"
+	"net/url"
 	"os"
 	"path"
+	"strings"
+	"text/template"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
+type tlsConfig struct {
+	CommonName   string `yaml:"common_name"`
+	Organization string `yaml:"organization"`
+}
+
+type smtpConfig struct {
+	Hostname string `yaml:"hostname"`
+}
+
+type httpConfig struct {
+	MaxRequests int           `yaml:"max_requests"`
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+}
+
+type rateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+type accessListConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
 type serverConfig struct {
-	ListenAddress string            `yaml:"listen_address"`
-	HostKeys      []string          `yaml:"host_keys"`
-	TCPIPServices map[uint32]string `yaml:"tcpip_services"`
+	ListenAddress       string            `yaml:"listen_address"`
+	ListenAddresses     []string          `yaml:"listen_addresses"`
+	ProxyProtocol       bool              `yaml:"proxy_protocol"`
+	HostKeys            []string          `yaml:"host_keys"`
+	HostKeyTypes        []string          `yaml:"host_key_types"`
+	TCPIPServices       map[uint32]string `yaml:"tcpip_services"`
+	TCPIPProxy          tcpipProxyConfig  `yaml:"tcpip_proxy"`
+	DisabledServices    []string          `yaml:"disabled_services"`
+	TLS                 tlsConfig         `yaml:"tls"`
+	SMTP                smtpConfig        `yaml:"smtp"`
+	HTTP                httpConfig        `yaml:"http"`
+	RateLimit           rateLimitConfig   `yaml:"rate_limit"`
+	AccessList          accessListConfig  `yaml:"access_list"`
+	MaxConnections      int               `yaml:"max_connections"`
+	ShutdownGracePeriod time.Duration     `yaml:"shutdown_grace_period"`
+}
+
+// listenAddresses returns the addresses to listen on, listen_addresses replacing listen_address if set.
+func (cfg serverConfig) listenAddresses() []string {
+	if len(cfg.ListenAddresses) != 0 {
+		return cfg.ListenAddresses
+	}
+	return []string{cfg.ListenAddress}
+}
+
+type tcpipProxyConfig struct {
+	Backends map[uint32]string `yaml:"backends"`
+	MaxBytes int64             `yaml:"max_bytes"`
 }
 
 type loggingConfig struct {
-	File           string `yaml:"file"`
-	JSON           bool   `yaml:"json"`
-	Timestamps     bool   `yaml:"timestamps"`
-	MetricsAddress string `yaml:"metrics_address"`
-	Debug          bool   `yaml:"debug"`
-	SplitHostPort  bool   `yaml:"split_host_port"`
+	File              string       `yaml:"file"`
+	JSON              bool         `yaml:"json"`
+	Timestamps        bool         `yaml:"timestamps"`
+	MetricsAddress    string       `yaml:"metrics_address"`
+	Debug             bool         `yaml:"debug"`
+	SplitHostPort     bool         `yaml:"split_host_port"`
+	MaxExtraDataBytes int          `yaml:"max_extra_data_bytes"`
+	Audit             auditConfig  `yaml:"audit"`
+	Syslog            syslogConfig `yaml:"syslog"`
+	GeoIP             geoIPConfig  `yaml:"geoip"`
+}
+
+type geoIPConfig struct {
+	CityDatabase string `yaml:"city_database"`
+	ASNDatabase  string `yaml:"asn_database"`
+}
+
+type syslogConfig struct {
+	Network  string `yaml:"network"`
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"`
+}
+
+type auditConfig struct {
+	File    string `yaml:"file"`
+	MaxSize int64  `yaml:"max_size"`
 }
 
 type commonAuthConfig struct {
@@ -51,28 +127,210 @@ type keyboardInteractiveAuthQuestion struct {
 	Echo bool   `yaml:"echo"`
 }
 
+type keyboardInteractiveAuthRound struct {
+	Instruction string                            `yaml:"instruction"`
+	Questions   []keyboardInteractiveAuthQuestion `yaml:"questions"`
+}
+
+type publicKeyAuthConfig struct {
+	commonAuthConfig `yaml:",inline"`
+	// AcceptedFingerprints restricts accepted keys to those with these SHA256 fingerprints, if not empty.
+	AcceptedFingerprints []string `yaml:"accepted_fingerprints"`
+}
+
+// accepts reports whether a public key should be accepted.
+func (cfg publicKeyAuthConfig) accepts(key ssh.PublicKey) bool {
+	if !cfg.Accepted {
+		return false
+	}
+	if len(cfg.AcceptedFingerprints) == 0 {
+		return true
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, accepted := range cfg.AcceptedFingerprints {
+		if accepted == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
 type keyboardInteractiveAuthConfig struct {
 	commonAuthConfig `yaml:",inline"`
 	Instruction      string                            `yaml:"instruction"`
 	Questions        []keyboardInteractiveAuthQuestion `yaml:"questions"`
+	Rounds           []keyboardInteractiveAuthRound    `yaml:"rounds"`
+}
+
+// rounds returns the configured challenge rounds, or a single round made of the top level instruction and questions.
+func (cfg keyboardInteractiveAuthConfig) rounds() []keyboardInteractiveAuthRound {
+	if len(cfg.Rounds) != 0 {
+		return cfg.Rounds
+	}
+	return []keyboardInteractiveAuthRound{{cfg.Instruction, cfg.Questions}}
 }
 
 type authConfig struct {
 	MaxTries                int                           `yaml:"max_tries"`
 	NoAuth                  bool                          `yaml:"no_auth"`
 	PasswordAuth            commonAuthConfig              `yaml:"password_auth"`
-	PublicKeyAuth           commonAuthConfig              `yaml:"public_key_auth"`
+	PublicKeyAuth           publicKeyAuthConfig           `yaml:"public_key_auth"`
 	KeyboardInteractiveAuth keyboardInteractiveAuthConfig `yaml:"keyboard_interactive_auth"`
 	Password                customAuthConfig              `yaml:"custom_auth"`
+	OneShot                 bool                          `yaml:"one_shot"`
+	PasswordSpray           passwordSprayConfig           `yaml:"password_spray"`
+	RandomAuth              randomAuthConfig              `yaml:"random_auth"`
+}
+
+type randomAuthConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Probability float64 `yaml:"probability"`
+	Seed        int64   `yaml:"seed"`
+}
+
+type passwordSprayConfig struct {
+	Threshold    int           `yaml:"threshold"`
+	MaxPasswords int           `yaml:"max_passwords"`
+	Window       time.Duration `yaml:"window"`
 }
 
 type sshProtoConfig struct {
-	Version        string   `yaml:"version"`
-	Banner         string   `yaml:"banner"`
-	RekeyThreshold uint64   `yaml:"rekey_threshold"`
-	KeyExchanges   []string `yaml:"key_exchanges"`
-	Ciphers        []string `yaml:"ciphers"`
-	MACs           []string `yaml:"macs"`
+	Version              string          `yaml:"version"`
+	Banner               string          `yaml:"banner"`
+	RekeyThreshold       uint64          `yaml:"rekey_threshold"`
+	KeyExchanges         []string        `yaml:"key_exchanges"`
+	Ciphers              []string        `yaml:"ciphers"`
+	MACs                 []string        `yaml:"macs"`
+	ChannelRejectMessage string          `yaml:"channel_reject_message"`
+	GlobalRequestReplies map[string]bool `yaml:"global_request_replies"`
+}
+
+type flakinessConfig struct {
+	ErrorRate float64       `yaml:"error_rate"`
+	MinDelay  time.Duration `yaml:"min_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	Seed      int64         `yaml:"seed"`
+}
+
+type clockConfig struct {
+	Fixed    string        `yaml:"fixed"`
+	Offset   time.Duration `yaml:"offset"`
+	Timezone string        `yaml:"timezone"`
+}
+
+type cloudCLIConfig struct {
+	Responses map[string]string `yaml:"responses"`
+}
+
+type loginConfig struct {
+	User     string        `yaml:"user"`
+	TTY      string        `yaml:"tty"`
+	Source   string        `yaml:"source"`
+	Ago      time.Duration `yaml:"ago"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+type processConfig struct {
+	PID     int    `yaml:"pid"`
+	User    string `yaml:"user"`
+	Command string `yaml:"command"`
+}
+
+type dockerImageConfig struct {
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+	ID         string `yaml:"id"`
+	Created    string `yaml:"created"`
+	Size       string `yaml:"size"`
+}
+
+type socketConfig struct {
+	Proto          string `yaml:"proto"`
+	LocalAddress   string `yaml:"local_address"`
+	ForeignAddress string `yaml:"foreign_address"`
+	State          string `yaml:"state"`
+	PID            int    `yaml:"pid"`
+	Program        string `yaml:"program"`
+}
+
+type mountConfig struct {
+	Filesystem string `yaml:"filesystem"`
+	Size       uint64 `yaml:"size"`
+	Used       uint64 `yaml:"used"`
+	Inodes     uint64 `yaml:"inodes"`
+	InodesUsed uint64 `yaml:"inodes_used"`
+	MountedOn  string `yaml:"mounted_on"`
+}
+
+type etcConfig struct {
+	Passwd string `yaml:"passwd"`
+	Shadow string `yaml:"shadow"`
+}
+
+type distroConfig struct {
+	ID            string `yaml:"id"`
+	IDLike        string `yaml:"id_like"`
+	Name          string `yaml:"name"`
+	PrettyName    string `yaml:"pretty_name"`
+	Version       string `yaml:"version"`
+	VersionID     string `yaml:"version_id"`
+	Codename      string `yaml:"version_codename"`
+	KernelRelease string `yaml:"kernel_release"`
+	KernelVersion string `yaml:"kernel_version"`
+}
+
+type filesystemConfig struct {
+	MaxNodes int `yaml:"max_nodes"`
+	MaxBytes int `yaml:"max_bytes"`
+}
+
+type hardwareConfig struct {
+	CPUModel string `yaml:"cpu_model"`
+	CPUCores int    `yaml:"cpu_cores"`
+	MemoryMB int    `yaml:"memory_mb"`
+}
+
+type shellConfig struct {
+	Flavor           string              `yaml:"flavor"`
+	Hostname         string              `yaml:"hostname"`
+	Prompt           string              `yaml:"prompt"`
+	MOTD             motdConfig          `yaml:"motd"`
+	Flakiness        flakinessConfig     `yaml:"flakiness"`
+	Clock            clockConfig         `yaml:"clock"`
+	Cloud            cloudCLIConfig      `yaml:"cloud"`
+	Processes        []processConfig     `yaml:"processes"`
+	Sockets          []socketConfig      `yaml:"sockets"`
+	Mounts           []mountConfig       `yaml:"mounts"`
+	DockerImages     []dockerImageConfig `yaml:"docker_images"`
+	LoginHistory     []loginConfig       `yaml:"login_history"`
+	BashHistory      bashHistoryConfig   `yaml:"bash_history"`
+	FileSystem       filesystemConfig    `yaml:"filesystem"`
+	Etc              etcConfig           `yaml:"etc"`
+	Distro           distroConfig        `yaml:"distro"`
+	Hardware         hardwareConfig      `yaml:"hardware"`
+	IdleTimeout      time.Duration       `yaml:"idle_timeout"`
+	Pager            bool                `yaml:"pager"`
+	DisabledCommands []string            `yaml:"disabled_commands"`
+	YesMaxLines      int                 `yaml:"yes_max_lines"`
+}
+
+type bashHistoryConfig struct {
+	Content string `yaml:"content"`
+	Preload bool   `yaml:"preload"`
+}
+
+type motdConfig struct {
+	Message   string `yaml:"message"`
+	LastLogin bool   `yaml:"last_login"`
+}
+
+type notifyConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+type sessionConfig struct {
+	MaxDuration         time.Duration `yaml:"max_duration"`
+	TranscriptDirectory string        `yaml:"transcript_directory"`
 }
 
 type config struct {
@@ -82,10 +340,25 @@ type config struct {
 	validUser string
 	validPass string
 	SSHProto  sshProtoConfig `yaml:"ssh_proto"`
+	Shell     shellConfig    `yaml:"shell"`
+	Session   sessionConfig  `yaml:"session"`
+	Notify    notifyConfig   `yaml:"notify"`
 
 	parsedHostKeys []ssh.Signer
+	tlsCertificate tls.Certificate
+	tcpipServers   map[uint32]tcpipServer
 	sshConfig      *ssh.ServerConfig
 	logFileHandle  io.WriteCloser
+	syslogHandle   io.WriteCloser
+	auditLog       *auditLog
+	geoIP          *geoIP
+	notifier       *webhookNotifier
+	flakiness      *flakiness
+	oneShot        *oneShotGuard
+	spray          *sprayDetector
+	accessList     *accessList
+	clock          clock
+	motd           *template.Template
 }
 
 func (cfg *config) pickRandomCredentials() {
@@ -115,20 +388,41 @@ func (cfg *config) pickRandomCredentials() {
 	infoLogger.Printf("Random authentication credentials selected: User = %s, Password = %s", cfg.validUser, cfg.validPass)
 }
 
+// validCredentials reports whether user and password match the credentials selected by pickRandomCredentials.
+func (cfg *config) validCredentials(user, password string) bool {
+	return cfg.validUser != "" && user == cfg.validUser && password == cfg.validPass
+}
+
 func (cfg *config) setDefaults() {
 	cfg.Server.ListenAddress = "127.0.0.1:2022"
+	cfg.Server.TLS.CommonName = "localhost"
+	cfg.Server.ShutdownGracePeriod = 10 * time.Second
+	cfg.Server.TCPIPProxy.MaxBytes = 1 << 20
+	cfg.Shell.Hostname = "prod-db-01"
+	cfg.Shell.Etc.Passwd = defaultPasswd
+	cfg.Shell.Etc.Shadow = defaultShadow
+	cfg.Shell.Distro = defaultDistro
+	cfg.Shell.BashHistory.Content = defaultBashHistory
+	cfg.Shell.FileSystem.MaxNodes = 10000
+	cfg.Shell.FileSystem.MaxBytes = 10 << 20
+	cfg.Shell.Hardware.CPUModel = "Intel(R) Xeon(R) Platinum 8259CL CPU @ 2.50GHz"
+	cfg.Shell.Hardware.CPUCores = 2
+	cfg.Shell.Hardware.MemoryMB = 4096
 	cfg.Logging.Timestamps = true
+	cfg.Logging.MaxExtraDataBytes = 1024
 	cfg.Auth.PasswordAuth.Enabled = true
 	cfg.Auth.PasswordAuth.Accepted = true
 	cfg.Auth.PublicKeyAuth.Enabled = true
 	cfg.SSHProto.Version = "SSH-2.0-sshesame"
 	cfg.SSHProto.Banner = "This is an SSH honeypot. Everything is logged and monitored."
+	cfg.SSHProto.ChannelRejectMessage = "open failed"
 }
 
 var defaultTCPIPServices = map[uint32]string{
 	25:   "SMTP",
 	80:   "HTTP",
 	110:  "POP3",
+	443:  "HTTPS",
 	587:  "SMTP",
 	8080: "HTTP",
 }
@@ -141,6 +435,16 @@ const (
 	ed25519_key
 )
 
+// parseKeySignature parses a key type as returned by keySignature.String.
+func parseKeySignature(name string) (keySignature, error) {
+	for _, signature := range []keySignature{rsa_key, ecdsa_key, ed25519_key} {
+		if signature.String() == name {
+			return signature, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown host key type %q", name)
+}
+
 func (signature keySignature) String() string {
 	switch signature {
 	case rsa_key:
@@ -190,6 +494,81 @@ func generateKey(dataDir string, signature keySignature) (string, error) {
 	return keyFile, nil
 }
 
+func generateTLSCertificate(commonName, organization string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	subject := pkix.Name{CommonName: commonName}
+	if organization != "" {
+		subject.Organization = []string{organization}
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: key}, nil
+}
+
+// setupTCPIPServers builds the effective mapping of ports to fake services, leaving out disabled services.
+// Ports with a proxy backend are relayed to it instead.
+func (cfg *config) setupTCPIPServers() error {
+	disabled := map[string]bool{}
+	for _, service := range cfg.Server.DisabledServices {
+		if _, ok := servers[service]; !ok {
+			return fmt.Errorf("unknown service %q", service)
+		}
+		disabled[service] = true
+	}
+	cfg.tcpipServers = map[uint32]tcpipServer{}
+	for port, service := range cfg.Server.TCPIPServices {
+		server, ok := servers[service]
+		if !ok {
+			return fmt.Errorf("unknown service %q", service)
+		}
+		if disabled[service] {
+			continue
+		}
+		cfg.tcpipServers[port] = server
+	}
+	for port, address := range cfg.Server.TCPIPProxy.Backends {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid proxy backend for port %v: %w", port, err)
+		}
+		cfg.tcpipServers[port] = proxyServer{address: address, maxBytes: cfg.Server.TCPIPProxy.MaxBytes}
+	}
+	return nil
+}
+
+func (cfg *config) setupTLSCertificate() error {
+	for _, service := range cfg.Server.TCPIPServices {
+		if service != "HTTPS" {
+			continue
+		}
+		certificate, err := generateTLSCertificate(cfg.Server.TLS.CommonName, cfg.Server.TLS.Organization)
+		if err != nil {
+			return err
+		}
+		cfg.tlsCertificate = certificate
+		return nil
+	}
+	return nil
+}
+
 func loadKey(keyFile string) (ssh.Signer, error) {
 	keyBytes, err := os.ReadFile(keyFile)
 	if err != nil {
@@ -221,6 +600,15 @@ func (cfg *config) parseHostKeys() error {
 }
 
 func (cfg *config) setupSSHConfig() error {
+	bannerCallback, err := cfg.getBannerCallback()
+	if err != nil {
+		return fmt.Errorf("invalid banner: %w", err)
+	}
+	if cfg.Auth.OneShot {
+		cfg.oneShot = &oneShotGuard{}
+		infoLogger.Printf("Honeypot armed, only the first successful authentication will be accepted")
+	}
+	cfg.spray = newSprayDetector(cfg.Auth.PasswordSpray)
 	sshConfig := &ssh.ServerConfig{
 		Config: ssh.Config{
 			RekeyThreshold: cfg.SSHProto.RekeyThreshold,
@@ -229,18 +617,20 @@ func (cfg *config) setupSSHConfig() error {
 			MACs:           cfg.SSHProto.MACs,
 		},
 		NoClientAuth:                cfg.Auth.NoAuth,
+		NoClientAuthCallback:        cfg.getNoAuthCallback(),
 		MaxAuthTries:                cfg.Auth.MaxTries,
 		PasswordCallback:            cfg.getPasswordCallback(),
 		PublicKeyCallback:           cfg.getPublicKeyCallback(),
 		KeyboardInteractiveCallback: cfg.getKeyboardInteractiveCallback(),
 		AuthLogCallback:             cfg.getAuthLogCallback(),
 		ServerVersion:               cfg.SSHProto.Version,
-		BannerCallback:              cfg.getBannerCallback(),
+		BannerCallback:              bannerCallback,
 	}
 	if err := cfg.parseHostKeys(); err != nil {
 		return err
 	}
 	for _, key := range cfg.parsedHostKeys {
+		infoLogger.Printf("Using %v host key %v", key.PublicKey().Type(), ssh.FingerprintSHA256(key.PublicKey()))
 		sshConfig.AddHostKey(key)
 	}
 	cfg.sshConfig = sshConfig
@@ -256,15 +646,46 @@ func (cfg *config) setupLogging() error {
 			return err
 		}
 	}
-	if logFile == nil {
-		log.SetOutput(os.Stdout)
-	} else {
+	var syslog io.WriteCloser
+	if cfg.Logging.Syslog.Address != "" {
+		writer, err := dialSyslog(cfg.Logging.Syslog)
+		if err != nil {
+			warningLogger.Printf("Failed to connect to syslog, logging to standard error instead: %v", err)
+		} else {
+			syslog = writer
+		}
+	}
+	switch {
+	case logFile != nil && syslog != nil:
+		log.SetOutput(io.MultiWriter(logFile, syslog))
+	case logFile != nil:
 		log.SetOutput(logFile)
+	case syslog != nil:
+		log.SetOutput(syslog)
+	case cfg.Logging.Syslog.Address != "":
+		log.SetOutput(os.Stderr)
+	default:
+		log.SetOutput(os.Stdout)
 	}
 	if cfg.logFileHandle != nil {
 		cfg.logFileHandle.Close()
 	}
 	cfg.logFileHandle = logFile
+	if cfg.syslogHandle != nil {
+		cfg.syslogHandle.Close()
+	}
+	cfg.syslogHandle = syslog
+	if cfg.auditLog != nil {
+		cfg.auditLog.Close()
+		cfg.auditLog = nil
+	}
+	if cfg.Logging.Audit.File != "" {
+		audit, err := openAuditLog(cfg.Logging.Audit)
+		if err != nil {
+			return err
+		}
+		cfg.auditLog = audit
+	}
 	if !cfg.Logging.JSON && cfg.Logging.Timestamps {
 		log.SetFlags(log.LstdFlags)
 	} else {
@@ -274,6 +695,9 @@ func (cfg *config) setupLogging() error {
 }
 
 func (cfg *config) load(configString string, dataDir string) error {
+	if cfg.notifier != nil {
+		cfg.notifier.stop()
+	}
 	*cfg = config{}
 
 	cfg.setDefaults()
@@ -286,15 +710,125 @@ func (cfg *config) load(configString string, dataDir string) error {
 		cfg.Server.TCPIPServices = defaultTCPIPServices
 	}
 
-	for _, service := range cfg.Server.TCPIPServices {
-		if _, ok := servers[service]; !ok {
-			return fmt.Errorf("unknown service %q", service)
+	if cfg.Server.SMTP.Hostname == "" {
+		cfg.Server.SMTP.Hostname = cfg.Shell.Hostname
+	}
+
+	if cfg.Server.HostKeyTypes == nil {
+		cfg.Server.HostKeyTypes = []string{rsa_key.String(), ecdsa_key.String(), ed25519_key.String()}
+	}
+	var hostKeySignatures []keySignature
+	for _, name := range cfg.Server.HostKeyTypes {
+		signature, err := parseKeySignature(name)
+		if err != nil {
+			return err
+		}
+		hostKeySignatures = append(hostKeySignatures, signature)
+	}
+
+	if cfg.Shell.Cloud.Responses == nil {
+		cfg.Shell.Cloud.Responses = defaultCloudResponses
+	}
+
+	if cfg.Shell.Processes == nil {
+		cfg.Shell.Processes = defaultProcesses
+	}
+
+	if cfg.Shell.Sockets == nil {
+		cfg.Shell.Sockets = defaultSockets
+	}
+
+	if cfg.Shell.Mounts == nil {
+		cfg.Shell.Mounts = defaultMounts
+	}
+	for _, mount := range cfg.Shell.Mounts {
+		if mount.Used > mount.Size || mount.InodesUsed > mount.Inodes {
+			return fmt.Errorf("mount %q uses more than its size", mount.MountedOn)
+		}
+	}
+
+	if cfg.Shell.DockerImages == nil {
+		cfg.Shell.DockerImages = defaultDockerImages
+	}
+
+	if cfg.Shell.LoginHistory == nil {
+		cfg.Shell.LoginHistory = defaultLoginHistory
+	}
+
+	if err := cfg.setupTCPIPServers(); err != nil {
+		return err
+	}
+
+	accessList, err := newAccessList(cfg.Server.AccessList)
+	if err != nil {
+		return err
+	}
+	cfg.accessList = accessList
+
+	if cfg.Shell.Flakiness.ErrorRate < 0 || cfg.Shell.Flakiness.ErrorRate > 1 {
+		return fmt.Errorf("invalid flakiness error rate %v", cfg.Shell.Flakiness.ErrorRate)
+	}
+	cfg.flakiness = newFlakiness(cfg.Shell.Flakiness)
+
+	if cfg.Auth.RandomAuth.Probability < 0 || cfg.Auth.RandomAuth.Probability > 1 {
+		return fmt.Errorf("invalid random auth probability %v", cfg.Auth.RandomAuth.Probability)
+	}
+
+	switch cfg.Shell.Flavor {
+	case "", "bash", "dash":
+	default:
+		return fmt.Errorf("unknown shell flavor %q", cfg.Shell.Flavor)
+	}
+
+	if version := cfg.SSHProto.Version; version != "" && (!strings.HasPrefix(version, "SSH-2.0-") || strings.ContainsAny(version, "\r\n")) {
+		return fmt.Errorf("invalid SSH version %q", version)
+	}
+
+	for _, fingerprint := range cfg.Auth.PublicKeyAuth.AcceptedFingerprints {
+		if !strings.HasPrefix(fingerprint, "SHA256:") {
+			return fmt.Errorf("invalid public key fingerprint %q", fingerprint)
+		}
+	}
+
+	if _, ok := syslogFacilities[cfg.Logging.Syslog.Facility]; !ok && cfg.Logging.Syslog.Facility != "" {
+		return fmt.Errorf("unknown syslog facility %q", cfg.Logging.Syslog.Facility)
+	}
+
+	clock, err := newClock(cfg.Shell.Clock)
+	if err != nil {
+		return err
+	}
+	cfg.clock = clock
+
+	if cfg.Shell.MOTD.Message != "" {
+		if cfg.motd, err = template.New("motd").Parse(cfg.Shell.MOTD.Message); err != nil {
+			return fmt.Errorf("invalid motd: %w", err)
+		}
+	}
+
+	if cfg.Notify.WebhookURL != "" {
+		webhookURL, err := url.Parse(cfg.Notify.WebhookURL)
+		if err != nil {
+			return fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		if webhookURL.Scheme != "http" && webhookURL.Scheme != "https" {
+			return fmt.Errorf("invalid webhook URL scheme %q", webhookURL.Scheme)
 		}
 	}
 
+	geoIP, err := openGeoIP(cfg.Logging.GeoIP)
+	if err != nil {
+		return err
+	}
+	cfg.geoIP = geoIP
+
+	if err := cfg.setupTLSCertificate(); err != nil {
+		return err
+	}
+
 	if len(cfg.Server.HostKeys) == 0 {
 		infoLogger.Printf("No host keys configured, using keys at %q", dataDir)
-		if err := cfg.setDefaultHostKeys(dataDir, []keySignature{rsa_key, ecdsa_key, ed25519_key}); err != nil {
+		if err := cfg.setDefaultHostKeys(dataDir, hostKeySignatures); err != nil {
 			return err
 		}
 	}
@@ -308,5 +842,9 @@ func (cfg *config) load(configString string, dataDir string) error {
 
 	cfg.pickRandomCredentials()
 
+	if cfg.Notify.WebhookURL != "" {
+		cfg.notifier = newWebhookNotifier(cfg.Notify.WebhookURL)
+	}
+
 	return nil
 }
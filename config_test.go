@@ -8,6 +8,7 @@ import (
 	"path"
 	"reflect"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
@@ -149,6 +150,11 @@ func TestDefaultConfig(t *testing.T) {
 	}
 	expectedConfig := &config{}
 	expectedConfig.Server.ListenAddress = "127.0.0.1:2022"
+	expectedConfig.Server.TLS.CommonName = "localhost"
+	expectedConfig.Server.SMTP.Hostname = "prod-db-01"
+	expectedConfig.Server.ShutdownGracePeriod = 10 * time.Second
+	expectedConfig.Server.TCPIPProxy.MaxBytes = 1 << 20
+	expectedConfig.Server.HostKeyTypes = []string{"rsa", "ecdsa", "ed25519"}
 	expectedConfig.Server.HostKeys = []string{
 		path.Join(dataDir, "host_rsa_key"),
 		path.Join(dataDir, "host_ecdsa_key"),
@@ -158,15 +164,18 @@ func TestDefaultConfig(t *testing.T) {
 		25:   "SMTP",
 		80:   "HTTP",
 		110:  "POP3",
+		443:  "HTTPS",
 		587:  "SMTP",
 		8080: "HTTP",
 	}
 	expectedConfig.Logging.Timestamps = true
+	expectedConfig.Logging.MaxExtraDataBytes = 1024
 	expectedConfig.Auth.PasswordAuth.Enabled = true
 	expectedConfig.Auth.PasswordAuth.Accepted = true
 	expectedConfig.Auth.PublicKeyAuth.Enabled = true
 	expectedConfig.SSHProto.Version = "SSH-2.0-sshesame"
 	expectedConfig.SSHProto.Banner = "This is an SSH honeypot. Everything is logged and monitored."
+	expectedConfig.SSHProto.ChannelRejectMessage = "open failed"
 	verifyConfig(t, cfg, expectedConfig)
 	verifyDefaultKeys(t, dataDir)
 }
@@ -221,6 +230,11 @@ ssh_proto:
 	}
 	expectedConfig := &config{}
 	expectedConfig.Server.ListenAddress = "0.0.0.0:22"
+	expectedConfig.Server.TLS.CommonName = "localhost"
+	expectedConfig.Server.SMTP.Hostname = "prod-db-01"
+	expectedConfig.Server.ShutdownGracePeriod = 10 * time.Second
+	expectedConfig.Server.TCPIPProxy.MaxBytes = 1 << 20
+	expectedConfig.Server.HostKeyTypes = []string{"rsa", "ecdsa", "ed25519"}
 	expectedConfig.Server.HostKeys = []string{
 		path.Join(dataDir, "host_rsa_key"),
 		path.Join(dataDir, "host_ecdsa_key"),
@@ -230,6 +244,7 @@ ssh_proto:
 	expectedConfig.Logging.File = logFile
 	expectedConfig.Logging.JSON = true
 	expectedConfig.Logging.Timestamps = false
+	expectedConfig.Logging.MaxExtraDataBytes = 1024
 	expectedConfig.Logging.MetricsAddress = "0.0.0.0:2112"
 	expectedConfig.Logging.SplitHostPort = true
 	expectedConfig.Auth.MaxTries = 234
@@ -247,6 +262,7 @@ ssh_proto:
 	expectedConfig.SSHProto.KeyExchanges = []string{"kex"}
 	expectedConfig.SSHProto.Ciphers = []string{"cipher"}
 	expectedConfig.SSHProto.MACs = []string{"mac"}
+	expectedConfig.SSHProto.ChannelRejectMessage = "open failed"
 	verifyConfig(t, cfg, expectedConfig)
 	verifyDefaultKeys(t, dataDir)
 }
@@ -270,16 +286,23 @@ server:
 	}
 	expectedConfig := &config{}
 	expectedConfig.Server.ListenAddress = "127.0.0.1:2022"
+	expectedConfig.Server.TLS.CommonName = "localhost"
+	expectedConfig.Server.SMTP.Hostname = "prod-db-01"
+	expectedConfig.Server.ShutdownGracePeriod = 10 * time.Second
+	expectedConfig.Server.TCPIPProxy.MaxBytes = 1 << 20
+	expectedConfig.Server.HostKeyTypes = []string{"rsa", "ecdsa", "ed25519"}
 	expectedConfig.Server.HostKeys = []string{keyFile}
 	expectedConfig.Server.TCPIPServices = map[uint32]string{
 		8080: "HTTP",
 	}
 	expectedConfig.Logging.Timestamps = true
+	expectedConfig.Logging.MaxExtraDataBytes = 1024
 	expectedConfig.Auth.PasswordAuth.Enabled = true
 	expectedConfig.Auth.PasswordAuth.Accepted = true
 	expectedConfig.Auth.PublicKeyAuth.Enabled = true
 	expectedConfig.SSHProto.Version = "SSH-2.0-sshesame"
 	expectedConfig.SSHProto.Banner = "This is an SSH honeypot. Everything is logged and monitored."
+	expectedConfig.SSHProto.ChannelRejectMessage = "open failed"
 	verifyConfig(t, cfg, expectedConfig)
 	files, err := os.ReadDir(dataDir)
 	if err != nil {
@@ -481,3 +504,136 @@ server:
 		t.Errorf("len(cfg.Server.TCPIPServices)=%d, want 0", len(cfg.Server.TCPIPServices))
 	}
 }
+
+func TestTCPIPServers(t *testing.T) {
+	cfgString := `
+server:
+  tcpip_services:
+    25: SMTP
+    8081: HTTP
+  disabled_services: [SMTP]
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	expectedServers := map[uint32]tcpipServer{
+		8081: httpServer{},
+	}
+	if !reflect.DeepEqual(cfg.tcpipServers, expectedServers) {
+		t.Errorf("tcpipServers=%v, want %v", cfg.tcpipServers, expectedServers)
+	}
+}
+
+func TestUnknownDisabledService(t *testing.T) {
+	cfgString := `
+server:
+  disabled_services: [FTP]
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
+
+func TestUnknownSyslogFacility(t *testing.T) {
+	cfgString := `
+logging:
+  syslog:
+    address: 127.0.0.1:514
+    facility: local9
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
+
+func TestInvalidAcceptedFingerprint(t *testing.T) {
+	cfgString := `
+auth:
+  public_key_auth:
+    accepted_fingerprints: [9faRaLujz6HiqA3/g5tI2zbfNvqHbBzZ19UI86swh0Q]
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
+
+func TestHostKeyTypes(t *testing.T) {
+	cfgString := `
+server:
+  host_key_types: [ed25519]
+`
+	dataDir := t.TempDir()
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	files, err := os.ReadDir(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to list directory: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "host_ed25519_key" {
+		t.Errorf("files=%v, want only host_ed25519_key", files)
+	}
+	if len(cfg.parsedHostKeys) != 1 {
+		t.Fatalf("len(parsedHostKeys)=%v, want 1", len(cfg.parsedHostKeys))
+	}
+	fingerprint := ssh.FingerprintSHA256(cfg.parsedHostKeys[0].PublicKey())
+
+	restartedCfg := &config{}
+	if err := restartedCfg.load(cfgString, dataDir); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(restartedCfg.parsedHostKeys) != 1 || ssh.FingerprintSHA256(restartedCfg.parsedHostKeys[0].PublicKey()) != fingerprint {
+		t.Errorf("parsedHostKeys=%v, want the generated key %v reused", restartedCfg.parsedHostKeys, fingerprint)
+	}
+}
+
+func TestUnknownHostKeyType(t *testing.T) {
+	cfgString := `
+server:
+  host_key_types: [dsa]
+`
+	cfg := &config{}
+	if err := cfg.load(cfgString, t.TempDir()); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
+
+func TestInvalidMount(t *testing.T) {
+	cfgString := `
+shell:
+  mounts:
+    - { filesystem: /dev/root, size: 1024, used: 2048, mounted_on: / }
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
+
+func TestInvalidSSHVersion(t *testing.T) {
+	cfgString := `
+ssh_proto:
+  version: OpenSSH_8.9p1 Ubuntu-3ubuntu0.6
+`
+	dataDir := t.TempDir()
+	writeTestKeys(t, dataDir)
+	cfg := &config{}
+	if err := cfg.load(cfgString, dataDir); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+}
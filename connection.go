@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"sync"
 
 	"github.com/jaksi/sshutils"
@@ -15,14 +16,28 @@ type connContext struct {
 	noMoreSessions bool
 }
 
+func (context connContext) connectionID() string {
+	return formatConnectionID(context.SessionID())
+}
+
+// formatConnectionID shortens an SSH session ID to something readable in logs.
+func formatConnectionID(sessionID []byte) string {
+	if len(sessionID) > 8 {
+		sessionID = sessionID[:8]
+	}
+	return hex.EncodeToString(sessionID)
+}
+
 type channelContext struct {
 	connContext
-	channelID int
+	channelID    int
+	forwardIndex int
 }
 
 var channelHandlers = map[string]func(newChannel ssh.NewChannel, context channelContext) error{
-	"session":      handleSessionChannel,
-	"direct-tcpip": handleDirectTCPIPChannel,
+	"session":         handleSessionChannel,
+	"direct-tcpip":    handleDirectTCPIPChannel,
+	"forwarded-tcpip": handleForwardedTCPIPChannel,
 }
 
 var (
@@ -38,22 +53,45 @@ var (
 		Name: "sshesame_unknown_channels_total",
 		Help: "Total number of unknown channels",
 	})
+	rejectedChannelsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sshesame_channels_rejected_total",
+		Help: "Total number of rejected channels",
+	}, []string{"type"})
 )
 
+// rejectedChannelTypes are the types of channels clients are known to open that are rejected,
+// others being counted as "other" so that clients can't create arbitrary metric labels.
+var rejectedChannelTypes = map[string]bool{
+	"x11":                               true,
+	"auth-agent@openssh.com":            true,
+	"tun@openssh.com":                   true,
+	"direct-streamlocal@openssh.com":    true,
+	"forwarded-streamlocal@openssh.com": true,
+}
+
 func handleConnection(conn *sshutils.Conn, cfg *config) {
 	sshConnectionsMetric.Inc()
 	activeSSHConnectionsMetric.Inc()
 	defer activeSSHConnectionsMetric.Dec()
 	var channels sync.WaitGroup
 	context := connContext{ConnMetadata: conn, cfg: cfg}
+	start := timeSource()
+	channelID := 0
 	defer func() {
 		conn.Close()
 		channels.Wait()
-		context.logEvent(connectionCloseLog{})
+		context.logEvent(connectionCloseLog{
+			Duration:   timeSource().Sub(start).Seconds(),
+			Channels:   channelID,
+			User:       conn.User(),
+			AuthMethod: authMethod(conn.Conn),
+		})
 	}()
 
 	context.logEvent(connectionLog{
 		ClientVersion: string(conn.ClientVersion()),
+		Listener:      conn.LocalAddr().String(),
+		geoLog:        cfg.geoIP.lookup(conn.RemoteAddr()),
 	})
 
 	hostKeysPayload := make([][]byte, len(cfg.parsedHostKeys))
@@ -65,7 +103,7 @@ func handleConnection(conn *sshutils.Conn, cfg *config) {
 		return
 	}
 
-	channelID := 0
+	forwardIndex := 0
 	for conn.Requests != nil || conn.NewChannels != nil {
 		select {
 		case request, ok := <-conn.Requests:
@@ -89,16 +127,25 @@ func handleConnection(conn *sshutils.Conn, cfg *config) {
 				continue
 			}
 			context.logEvent(debugChannelLog{
-				channelLog:  channelLog{ChannelID: channelID},
-				ChannelType: newChannel.ChannelType(),
-				ExtraData:   string(newChannel.ExtraData()),
+				channelLog:      channelLog{ChannelID: channelID},
+				ChannelType:     newChannel.ChannelType(),
+				ExtraData:       truncateLogData(newChannel.ExtraData(), cfg.Logging.MaxExtraDataBytes),
+				ExtraDataLength: len(newChannel.ExtraData()),
 			})
 			channelType := newChannel.ChannelType()
 			handler := channelHandlers[channelType]
 			if handler == nil {
 				unknownChannelsMetric.Inc()
-				warningLogger.Printf("Unsupported channel type %v", channelType)
-				if err := newChannel.Reject(ssh.ConnectionFailed, "open failed"); err != nil {
+				if rejectedChannelTypes[channelType] {
+					rejectedChannelsMetric.WithLabelValues(channelType).Inc()
+				} else {
+					rejectedChannelsMetric.WithLabelValues("other").Inc()
+				}
+				context.logEvent(channelRejectLog{
+					ChannelType: channelType,
+					Reason:      cfg.SSHProto.ChannelRejectMessage,
+				})
+				if err := newChannel.Reject(ssh.ConnectionFailed, cfg.SSHProto.ChannelRejectMessage); err != nil {
 					warningLogger.Printf("Failed to reject channel: %v", err)
 					conn.NewChannels = nil
 					continue
@@ -112,8 +159,11 @@ func handleConnection(conn *sshutils.Conn, cfg *config) {
 					warningLogger.Printf("Failed to handle new channel: %v", err)
 					conn.Close()
 				}
-			}(channelContext{context, channelID})
+			}(channelContext{context, channelID, forwardIndex})
 			channelID++
+			if channelType == "direct-tcpip" {
+				forwardIndex++
+			}
 		}
 	}
 }
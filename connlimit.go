@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectionLimitedConnectionsMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sshesame_connection_limited_connections_total",
+		Help: "Total number of connections rejected by the connection limit",
+	})
+	connectionLimitUsedSlotsMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sshesame_connection_limit_used_slots",
+		Help: "Number of connections holding a slot in the connection limit",
+	})
+)
+
+// connectionLimitedListener closes connections after sending the version identification
+// once the maximum number of simultaneous connections is reached.
+type connectionLimitedListener struct {
+	net.Listener
+	// slots is a counting semaphore holding a value for each open connection.
+	slots chan struct{}
+	cfg   *config
+}
+
+// newConnectionLimitedListener limits the connections accepted by listener to the capacity of slots,
+// which can be shared by several listeners.
+func newConnectionLimitedListener(listener net.Listener, slots chan struct{}, cfg *config) net.Listener {
+	return &connectionLimitedListener{listener, slots, cfg}
+}
+
+func (listener *connectionLimitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case listener.slots <- struct{}{}:
+			connectionLimitUsedSlotsMetric.Set(float64(len(listener.slots)))
+			return &limitedConn{Conn: conn, slots: listener.slots}, nil
+		default:
+		}
+		connectionLimitedConnectionsMetric.Inc()
+		connContext{ConnMetadata: rawConnMetadata{conn}, cfg: listener.cfg}.logEvent(connectionLimitLog{})
+		if version := listener.cfg.SSHProto.Version; version != "" {
+			if _, err := fmt.Fprintf(conn, "%v\r\n", version); err != nil {
+				warningLogger.Printf("Failed to send version identification: %v", err)
+			}
+		}
+		conn.Close()
+	}
+}
+
+// limitedConn frees its slot in the connection limit when closed.
+type limitedConn struct {
+	net.Conn
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (conn *limitedConn) Close() error {
+	conn.once.Do(func() {
+		<-conn.slots
+		connectionLimitUsedSlotsMetric.Set(float64(len(conn.slots)))
+	})
+	return conn.Conn.Close()
+}
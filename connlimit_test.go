@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnectionLimitedListener(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.MaxConnections = 1
+	cfg.SSHProto.Version = "SSH-2.0-OpenSSH_8.9p1"
+	logs := setupLogBuffer(t, cfg)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newConnectionLimitedListener(tcpListener, make(chan struct{}, cfg.Server.MaxConnections), cfg)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	acceptNext := func() net.Conn {
+		t.Helper()
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		select {
+		case conn := <-accepted:
+			return conn
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the connection to be accepted")
+			return nil
+		}
+	}
+
+	first := acceptNext()
+	if slots := testutil.ToFloat64(connectionLimitUsedSlotsMetric); slots != 1 {
+		t.Errorf("used slots=%v, want 1", slots)
+	}
+	rejected, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if banner, err := io.ReadAll(rejected); err != nil || string(banner) != "SSH-2.0-OpenSSH_8.9p1\r\n" {
+		t.Errorf("banner=%q, err=%v, want the version identification before the connection is closed", banner, err)
+	}
+	if !strings.HasSuffix(logs.String(), "] connection rejected over the connection limit\n") {
+		t.Errorf("logs=%v, want the rejected connection logged", logs.String())
+	}
+
+	if slots := testutil.ToFloat64(connectionLimitUsedSlotsMetric); slots != 1 {
+		t.Errorf("used slots=%v, want 1 after the rejected connection", slots)
+	}
+
+	first.Close()
+	if slots := testutil.ToFloat64(connectionLimitUsedSlotsMetric); slots != 0 {
+		t.Errorf("used slots=%v, want 0 after the connection is closed", slots)
+	}
+	acceptNext().Close()
+	if len(accepted) != 0 {
+		t.Errorf("%v extra connections accepted, want 0", len(accepted))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// installCrontab stores and logs a new crontab for the user of the session.
+func installCrontab(context commandContext, crontab string) {
+	if context.state.crontabs == nil {
+		context.state.crontabs = map[string]string{}
+	}
+	context.state.crontabs[context.user] = crontab
+	context.logEvent(crontabLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		User:       context.user,
+		Crontab:    crontab,
+	})
+}
+
+// readCrontab reads lines from stdin until EOF or Ctrl-D, like an editor would be used to write them.
+func readCrontab(context commandContext) (string, error) {
+	var lines []string
+	for {
+		line, err := context.stdin.ReadLine()
+		if endOfInput(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line+"\n")
+	}
+	return strings.Join(lines, ""), nil
+}
+
+type cmdCrontab struct{}
+
+func (cmdCrontab) execute(context commandContext) (uint32, error) {
+	if len(context.args) != 2 {
+		_, err := fmt.Fprintf(context.stderr, "crontab: usage error: file name or - must be specified\nusage:\t%v [-u user] [ -e | -l | -r ] [file]\n", context.args[0])
+		return 1, err
+	}
+	crontab, exists := context.state.crontabs[context.user]
+	switch arg := context.args[1]; arg {
+	case "-l":
+		if !exists {
+			_, err := fmt.Fprintf(context.stderr, "no crontab for %v\n", context.user)
+			return 1, err
+		}
+		_, err := fmt.Fprint(context.stdout, crontab)
+		return 0, err
+	case "-r":
+		if !exists {
+			_, err := fmt.Fprintf(context.stderr, "no crontab for %v\n", context.user)
+			return 1, err
+		}
+		delete(context.state.crontabs, context.user)
+		return 0, nil
+	case "-e":
+		if !context.pty {
+			_, err := fmt.Fprintln(context.stderr, "crontab: no changes made to crontab")
+			return 0, err
+		}
+		if !exists {
+			if _, err := fmt.Fprintf(context.stderr, "no crontab for %v - using an empty one\n", context.user); err != nil {
+				return 1, err
+			}
+		}
+		crontab, err := readCrontab(context)
+		if err != nil {
+			return 1, err
+		}
+		installCrontab(context, crontab)
+		_, err = fmt.Fprintln(context.stderr, "crontab: installing new crontab")
+		return 0, err
+	case "-":
+		crontab, err := readCrontab(context)
+		if err != nil {
+			return 1, err
+		}
+		installCrontab(context, crontab)
+		return 0, nil
+	default:
+		node := context.state.fs.lookup(arg)
+		if node == nil || node.IsDir || !node.canRead(context.user) {
+			_, err := fmt.Fprintf(context.stderr, "%v: No such file or directory\n", arg)
+			return 1, err
+		}
+		installCrontab(context, node.content())
+		return 0, nil
+	}
+}
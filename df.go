@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var defaultMounts = []mountConfig{
+	{Filesystem: "/dev/root", Size: 101445540, Used: 18973528, Inodes: 12902400, InodesUsed: 512347, MountedOn: "/"},
+	{Filesystem: "tmpfs", Size: 2008404, Inodes: 502101, InodesUsed: 1, MountedOn: "/dev/shm"},
+	{Filesystem: "tmpfs", Size: 803364, Used: 1012, Inodes: 819200, InodesUsed: 798, MountedOn: "/run"},
+	{Filesystem: "tmpfs", Size: 5120, Inodes: 502101, InodesUsed: 3, MountedOn: "/run/lock"},
+	{Filesystem: "/dev/nvme0n1p15", Size: 106858, Used: 6186, MountedOn: "/boot/efi"},
+}
+
+// humanSize formats a size in bytes like the -h option of coreutils does, rounding up to 2 significant digits.
+func humanSize(size uint64) string {
+	if size < 1024 {
+		return fmt.Sprint(size)
+	}
+	const units = "KMGTPE"
+	value := float64(size)
+	unit := -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		if rounded := math.Ceil(value*10) / 10; rounded < 10 {
+			return fmt.Sprintf("%.1f%c", rounded, units[unit])
+		}
+	}
+	rounded := math.Ceil(value)
+	if rounded >= 1024 && unit < len(units)-1 {
+		return fmt.Sprintf("1.0%c", units[unit+1])
+	}
+	return fmt.Sprintf("%.0f%c", rounded, units[unit])
+}
+
+// usePercent is the used share of a total, rounded up like df does, or "-" if the total is zero.
+func usePercent(used, total uint64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%v%%", (used*100+total-1)/total)
+}
+
+type cmdDf struct{}
+
+func (cmdDf) execute(context commandContext) (uint32, error) {
+	var human, inodes bool
+	for _, arg := range context.args[1:] {
+		switch {
+		case arg == "--human-readable":
+			human = true
+		case arg == "--inodes":
+			inodes = true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			human = human || strings.Contains(arg, "h")
+			inodes = inodes || strings.Contains(arg, "i")
+		}
+	}
+	header := []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}
+	switch {
+	case inodes:
+		header = []string{"Filesystem", "Inodes", "IUsed", "IFree", "IUse%", "Mounted on"}
+	case human:
+		header = []string{"Filesystem", "Size", "Used", "Avail", "Use%", "Mounted on"}
+	}
+	rows := [][]string{header}
+	for _, mount := range context.cfg.Shell.Mounts {
+		total, used, scale := mount.Size, mount.Used, uint64(1024)
+		if inodes {
+			total, used, scale = mount.Inodes, mount.InodesUsed, 1
+		}
+		format := func(value uint64) string {
+			if human {
+				return humanSize(value * scale)
+			}
+			return fmt.Sprint(value)
+		}
+		rows = append(rows, []string{mount.Filesystem, format(total), format(used), format(total - used), usePercent(used, total), mount.MountedOn})
+	}
+	// Columns are at least as wide as df makes them, the filesystem and mount point are left aligned.
+	widths := []int{14, 5, 5, 5, 4, 0}
+	for _, row := range rows {
+		for i, field := range row {
+			if len(field) > widths[i] {
+				widths[i] = len(field)
+			}
+		}
+	}
+	var lines []string
+	for _, row := range rows {
+		line := fmt.Sprintf("%-*s", widths[0], row[0])
+		for i := 1; i < 5; i++ {
+			line += fmt.Sprintf(" %*s", widths[i], row[i])
+		}
+		lines = append(lines, line+" "+row[5])
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+var defaultDockerImages = []dockerImageConfig{
+	{Repository: "postgres", Tag: "15", ID: "b8fbf87cd5a6", Created: "3 weeks ago", Size: "412MB"},
+	{Repository: "nginx", Tag: "latest", ID: "a8758716bb6a", Created: "5 weeks ago", Size: "187MB"},
+	{Repository: "redis", Tag: "7-alpine", ID: "1b3b3fa1783e", Created: "2 months ago", Size: "41MB"},
+}
+
+const dockerVersion = `Client: Docker Engine - Community
+ Version:           24.0.7
+ API version:       1.43
+ Go version:        go1.20.10
+ Git commit:        afdd53b
+ Built:             Thu Oct 26 09:07:41 2023
+ OS/Arch:           linux/amd64
+ Context:           default
+
+Server: Docker Engine - Community
+ Engine:
+  Version:          24.0.7
+  API version:      1.43 (minimum version 1.12)
+  Go version:       go1.20.10
+  Git commit:       311b9ff
+  Built:            Thu Oct 26 09:07:41 2023
+  OS/Arch:          linux/amd64
+  Experimental:     false
+ containerd:
+  Version:          1.6.25
+  GitCommit:        d8f198a4ed8892c764191ef7b3b06d8a2eeb5c7f
+ runc:
+  Version:          1.1.10
+  GitCommit:        v1.1.10-0-g18a0cb0
+ docker-init:
+  Version:          0.19.0
+  GitCommit:        de40ad0`
+
+// dockerRunValueFlags are the options of docker run taking a separate value.
+var dockerRunValueFlags = map[string]bool{
+	"-e": true, "--env": true, "-v": true, "--volume": true, "-p": true, "--publish": true,
+	"--name": true, "-w": true, "--workdir": true, "-u": true, "--user": true, "-h": true, "--hostname": true,
+	"--entrypoint": true, "--network": true, "--net": true, "--mount": true, "--restart": true, "--label": true, "-l": true,
+}
+
+// newDockerTable returns a writer aligning columns the way the docker CLI does.
+func newDockerTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 10, 1, 3, ' ', 0)
+}
+
+func init() {
+	registerCommand("docker", cmdDocker{})
+}
+
+type cmdDocker struct{}
+
+func (cmdDocker) execute(context commandContext) (uint32, error) {
+	if len(context.args) < 2 {
+		_, err := fmt.Fprint(context.stdout, "\nUsage:  docker [OPTIONS] COMMAND\n\nA self-sufficient runtime for containers\n\nRun 'docker COMMAND --help' for more information on a command.\n")
+		return 0, err
+	}
+	switch subcommand := context.args[1]; subcommand {
+	case "ps":
+		table := newDockerTable(context.stdout)
+		fmt.Fprintln(table, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPORTS\tNAMES")
+		return 0, table.Flush()
+	case "images":
+		table := newDockerTable(context.stdout)
+		fmt.Fprintln(table, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE")
+		for _, image := range context.cfg.Shell.DockerImages {
+			fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", image.Repository, image.Tag, image.ID, image.Created, image.Size)
+		}
+		return 0, table.Flush()
+	case "version":
+		_, err := fmt.Fprintln(context.stdout, dockerVersion)
+		return 0, err
+	case "run":
+		return dockerRun(context, context.args[2:])
+	default:
+		_, err := fmt.Fprintf(context.stderr, "docker: '%v' is not a docker command.\nSee 'docker --help'\n", subcommand)
+		return 1, err
+	}
+}
+
+func dockerRun(context commandContext, args []string) (uint32, error) {
+	i := 0
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if dockerRunValueFlags[args[i]] {
+			i++
+		}
+	}
+	if i >= len(args) {
+		_, err := fmt.Fprint(context.stderr, "\"docker run\" requires at least 1 argument.\nSee 'docker run --help'.\n\nUsage:  docker run [OPTIONS] IMAGE [COMMAND] [ARG...]\n\nCreate and run a new container from an image\n")
+		return 125, err
+	}
+	image, command := args[i], args[i+1:]
+	context.logEvent(dockerRunLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Image:      image,
+		Command:    command,
+	})
+	reference := image
+	if !strings.Contains(reference, ":") {
+		reference += ":latest"
+	}
+	for _, known := range context.cfg.Shell.DockerImages {
+		if reference == known.Repository+":"+known.Tag {
+			// Nothing is ever run, the container fails to start like it would in an unprivileged environment.
+			_, err := fmt.Fprint(context.stderr, "docker: Error response from daemon: failed to create task for container: failed to create shim task: OCI runtime create failed: runc create failed: unable to start container process: error during container init: error mounting \"proc\" to rootfs at \"/proc\": permission denied: unknown.\n")
+			return 126, err
+		}
+	}
+	_, err := fmt.Fprintf(context.stderr, "Unable to find image '%v' locally\ndocker: Error response from daemon: Get \"https://registry-1.docker.io/v2/\": dial tcp: lookup registry-1.docker.io on 127.0.0.53:53: server misbehaving.\nSee 'docker run --help'.\n", reference)
+	return 125, err
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// parseDownloadURL parses a URL the way wget and curl do, defaulting to HTTP when the scheme is missing.
+func parseDownloadURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Hostname() == "" {
+		return nil, errors.New("no host")
+	}
+	return parsed, nil
+}
+
+// remoteName is the name of the file a URL is saved to by default.
+func remoteName(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "index.html"
+	}
+	return name
+}
+
+// resolveHost makes up a stable documentation address for host names.
+func resolveHost(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(host))
+	return fmt.Sprintf("203.0.113.%v", hash.Sum32()%254+1)
+}
+
+// saveDownload writes an empty file to the fake filesystem, replacing the content of an existing one.
+func saveDownload(context commandContext, file string) error {
+	dir, name := filepath.Split(file)
+	parent := context.state.fs.lookup(dir)
+	if parent == nil || !parent.IsDir || name == "" {
+		return errors.New("No such file or directory")
+	}
+	if node, exists := parent.Children[name]; exists {
+		if node.IsDir {
+			return errors.New("Is a directory")
+		}
+		if !node.canWrite(context.user) {
+			return errors.New("Permission denied")
+		}
+		return context.state.fs.write(node, "")
+	}
+	if !parent.canWrite(context.user) {
+		return errors.New("Permission denied")
+	}
+	_, err := context.state.fs.create(parent, name, false, context.user)
+	return err
+}
+
+type cmdWget struct{}
+
+func (cmdWget) execute(context commandContext) (uint32, error) {
+	var urls []string
+	var output string
+	quiet := false
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "-O" && i+1 < len(args):
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "--output-document="):
+			output = strings.TrimPrefix(arg, "--output-document=")
+		case strings.HasPrefix(arg, "-O") && len(arg) > 2:
+			output = arg[2:]
+		case arg == "-q" || arg == "--quiet":
+			quiet = true
+		case (arg == "-o" || arg == "-P" || arg == "-U") && i+1 < len(args):
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			urls = append(urls, arg)
+		}
+	}
+	if len(urls) == 0 {
+		_, err := fmt.Fprintln(context.stderr, "wget: missing URL\nUsage: wget [OPTION]... [URL]...\n\nTry `wget --help' for more options.")
+		return 1, err
+	}
+	var status uint32
+	for _, rawURL := range urls {
+		u, err := parseDownloadURL(rawURL)
+		if err != nil {
+			if _, err := fmt.Fprintf(context.stderr, "%v: Invalid host name.\n", rawURL); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		context.logEvent(downloadLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			Client:     "wget",
+			URL:        u.String(),
+		})
+		file := output
+		if file == "" {
+			file = remoteName(u)
+			for i := 1; context.state.fs.lookup(file) != nil; i++ {
+				file = fmt.Sprintf("%v.%v", remoteName(u), i)
+			}
+		}
+		if quiet {
+			if file != "-" {
+				if err := saveDownload(context, file); err != nil {
+					status = 3
+				}
+			}
+			continue
+		}
+		now := context.cfg.clock.now().Format("2006-01-02 15:04:05")
+		host, port := u.Hostname(), u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		address := resolveHost(host)
+		var lines []string
+		lines = append(lines, fmt.Sprintf("--%v--  %v", now, u))
+		if address == host {
+			lines = append(lines, fmt.Sprintf("Connecting to %v:%v... connected.", host, port))
+		} else {
+			lines = append(lines,
+				fmt.Sprintf("Resolving %v (%v)... %v", host, host, address),
+				fmt.Sprintf("Connecting to %v (%v)|%v|:%v... connected.", host, host, address, port))
+		}
+		lines = append(lines, "HTTP request sent, awaiting response... 200 OK", "Length: 0 [application/octet-stream]")
+		if file == "-" {
+			lines = append(lines, "Saving to: ‘STDOUT’", "", fmt.Sprintf("%v (0.00 B/s) - written to stdout [0/0]", now), "")
+		} else {
+			if err := saveDownload(context, file); err != nil {
+				lines = append(lines, fmt.Sprintf("%v: %v", file, err), "")
+				status = 3
+				if _, err := fmt.Fprintln(context.stderr, strings.Join(lines, "\n")); err != nil {
+					return status, err
+				}
+				continue
+			}
+			lines = append(lines,
+				fmt.Sprintf("Saving to: ‘%v’", file),
+				"",
+				fmt.Sprintf("%-23.23s [ <=>                ]       0  --.-KB/s    in 0s", file),
+				"",
+				fmt.Sprintf("%v (0.00 B/s) - ‘%v’ saved [0/0]", now, file),
+				"")
+		}
+		if _, err := fmt.Fprintln(context.stderr, strings.Join(lines, "\n")); err != nil {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+type cmdCurl struct{}
+
+func (cmdCurl) execute(context commandContext) (uint32, error) {
+	var urls []string
+	var output string
+	remote, silent := false, false
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "--output":
+			if i+1 < len(args) {
+				i++
+				output = args[i]
+			}
+		case arg == "-O" || arg == "--remote-name":
+			remote = true
+		case arg == "-s" || arg == "--silent":
+			silent = true
+		case arg == "-H" || arg == "-d" || arg == "-A" || arg == "-X" || arg == "-u" || arg == "-e" || arg == "-x":
+			i++
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--"):
+			remote = remote || strings.Contains(arg, "O")
+			silent = silent || strings.Contains(arg, "s")
+		case !strings.HasPrefix(arg, "-"):
+			urls = append(urls, arg)
+		}
+	}
+	if len(urls) == 0 {
+		_, err := fmt.Fprintln(context.stderr, "curl: try 'curl --help' or 'curl --manual' for more information")
+		return 2, err
+	}
+	for _, rawURL := range urls {
+		u, err := parseDownloadURL(rawURL)
+		if err != nil {
+			_, err := fmt.Fprintf(context.stderr, "curl: (3) URL using bad/illegal format or missing URL\n")
+			return 3, err
+		}
+		context.logEvent(downloadLog{
+			channelLog: channelLog{ChannelID: context.channelID},
+			Client:     "curl",
+			URL:        u.String(),
+		})
+		file := output
+		if remote {
+			file = remoteName(u)
+		}
+		if file == "" || file == "-" {
+			continue
+		}
+		if !silent {
+			if _, err := fmt.Fprintln(context.stderr, "  % Total    % Received % Xferd  Average Speed   Time    Time     Time  Current\n                                 Dload  Upload   Total   Spent    Left  Speed\n  0     0    0     0    0     0      0      0 --:--:-- --:--:-- --:--:--     0"); err != nil {
+				return 0, err
+			}
+		}
+		if err := saveDownload(context, file); err != nil {
+			_, err := fmt.Fprintf(context.stderr, "curl: (23) Failed writing body\n")
+			return 23, err
+		}
+	}
+	return 0, nil
+}
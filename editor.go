@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ctrlX is what nano is exited with. Terminals drop control characters from lines, keepCtrlX preserves it.
+const ctrlX = '\x18'
+
+// keepCtrlX is a terminal key callback inserting Ctrl-X into the line rather than ignoring it.
+func keepCtrlX(line string, pos int, key rune) (string, int, bool) {
+	if key != ctrlX {
+		return "", 0, false
+	}
+	return line[:pos] + string(key) + line[pos:], pos + 1, true
+}
+
+// editor is a line based imitation of an interactive text editor. Lines typed are appended to the file,
+// until the editor is exited in a way that tells whether they're saved.
+type editor struct {
+	name string
+	// notTerminal is printed when there's no pty.
+	notTerminal string
+	// banner is printed before the content of the file, with the path substituted for %v.
+	banner string
+	// exit reports whether line ends the editing session, what's left of it to add to the file and whether to save.
+	exit func(line string) (rest string, done, save bool)
+}
+
+func (e editor) execute(context commandContext) (uint32, error) {
+	if !context.pty {
+		_, err := fmt.Fprintln(context.stderr, e.notTerminal)
+		return 1, err
+	}
+	var path string
+	for _, arg := range context.args[1:] {
+		if !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+			path = arg
+			break
+		}
+	}
+	node := context.state.fs.lookup(path)
+	if path != "" && node != nil && node.IsDir {
+		_, err := fmt.Fprintf(context.stderr, "%v: %v: Is a directory\n", e.name, path)
+		return 1, err
+	}
+	content := ""
+	if node != nil && node.canRead(context.user) {
+		content = node.content()
+	}
+	if _, err := fmt.Fprintf(context.stdout, e.banner+"%v", path, content); err != nil {
+		return 1, err
+	}
+
+	var lines []string
+	for {
+		line, err := context.stdin.ReadLine()
+		if endOfInput(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 1, err
+		}
+		rest, done, save := e.exit(line)
+		if rest != "" {
+			lines = append(lines, rest)
+		}
+		if !done {
+			continue
+		}
+		if !save || path == "" {
+			return 0, nil
+		}
+		break
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	if err := e.save(context, path, node, content); err != nil {
+		_, err := fmt.Fprintf(context.stderr, "%v: cannot write '%v': %v\n", e.name, path, err)
+		return 1, err
+	}
+	context.logEvent(fileWriteLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Path:       context.state.fs.absPath(path),
+		Editor:     e.name,
+		Content:    content,
+	})
+	return 0, nil
+}
+
+// save writes content to node at path, creating it if it's nil.
+func (e editor) save(context commandContext, path string, node *FileSystemNode, content string) error {
+	if node == nil {
+		dir, name := filepath.Split(path)
+		parent := context.state.fs.lookup(dir)
+		if parent == nil || !parent.IsDir {
+			return errors.New("No such file or directory")
+		}
+		if !parent.canWrite(context.user) {
+			return errors.New("Permission denied")
+		}
+		var err error
+		if node, err = context.state.fs.create(parent, name, false, context.user); err != nil {
+			return err
+		}
+	} else if !node.canWrite(context.user) {
+		return errors.New("Permission denied")
+	}
+	return context.state.fs.write(node, content)
+}
+
+var cmdNano = editor{
+	name:        "nano",
+	notTerminal: "Too many errors from stdin",
+	banner:      "  GNU nano 6.2                 %v\n",
+	exit: func(line string) (string, bool, bool) {
+		before, answer, found := strings.Cut(line, string(ctrlX))
+		if !found {
+			return line, false, false
+		}
+		// Ctrl-X asks whether to save the modified buffer, which does unless answered with N.
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return before, true, !strings.HasPrefix(answer, "n")
+	},
+}
+
+var cmdVi = editor{
+	name:        "vi",
+	notTerminal: "Vim: Warning: Input is not from a terminal",
+	banner:      "\"%v\"\n",
+	exit: func(line string) (string, bool, bool) {
+		switch strings.TrimSpace(line) {
+		case ":wq", ":wq!", ":x", ":x!", "ZZ":
+			return "", true, true
+		case ":q", ":q!", "ZQ":
+			return "", true, false
+		}
+		return line, false, false
+	},
+}
+
+func init() {
+	registerCommand("nano", cmdNano)
+	registerCommand("vi", cmdVi)
+	registerCommand("vim", cmdVi)
+}
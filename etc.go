@@ -0,0 +1,80 @@
+package main
+
+const defaultPasswd = `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+bin:x:2:2:bin:/bin:/usr/sbin/nologin
+sys:x:3:3:sys:/dev:/usr/sbin/nologin
+sync:x:4:65534:sync:/bin:/bin/sync
+games:x:5:60:games:/usr/games:/usr/sbin/nologin
+man:x:6:12:man:/var/cache/man:/usr/sbin/nologin
+lp:x:7:7:lp:/var/spool/lpd:/usr/sbin/nologin
+mail:x:8:8:mail:/var/mail:/usr/sbin/nologin
+news:x:9:9:news:/var/spool/news:/usr/sbin/nologin
+uucp:x:10:10:uucp:/var/spool/uucp:/usr/sbin/nologin
+proxy:x:13:13:proxy:/bin:/usr/sbin/nologin
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+backup:x:34:34:backup:/var/backups:/usr/sbin/nologin
+list:x:38:38:Mailing List Manager:/var/list:/usr/sbin/nologin
+irc:x:39:39:ircd:/run/ircd:/usr/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin
+systemd-network:x:100:102:systemd Network Management,,,:/run/systemd:/usr/sbin/nologin
+systemd-resolve:x:101:103:systemd Resolver,,,:/run/systemd:/usr/sbin/nologin
+messagebus:x:102:105::/nonexistent:/usr/sbin/nologin
+systemd-timesync:x:103:106:systemd Time Synchronization,,,:/run/systemd:/usr/sbin/nologin
+syslog:x:104:111::/home/syslog:/usr/sbin/nologin
+_apt:x:105:65534::/nonexistent:/usr/sbin/nologin
+uuidd:x:107:114::/run/uuidd:/usr/sbin/nologin
+sshd:x:110:65534::/run/sshd:/usr/sbin/nologin
+mysql:x:113:119:MySQL Server,,,:/nonexistent:/bin/false
+ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash
+deploy:x:1001:1001::/home/deploy:/bin/bash
+`
+
+const defaultShadow = `root:$6$PtYgjmUhBel31iEl$2hpChYgCfrL1spNxnyVmihA/2O76UMFxFkM/R5Kjp1vRt.1fjORS/6ilI8ihN5KXSc7Tvo/hBKqFYY/kv5ZJr3:19417:0:99999:7:::
+daemon:*:19358:0:99999:7:::
+bin:*:19358:0:99999:7:::
+sys:*:19358:0:99999:7:::
+sync:*:19358:0:99999:7:::
+games:*:19358:0:99999:7:::
+man:*:19358:0:99999:7:::
+lp:*:19358:0:99999:7:::
+mail:*:19358:0:99999:7:::
+news:*:19358:0:99999:7:::
+uucp:*:19358:0:99999:7:::
+proxy:*:19358:0:99999:7:::
+www-data:*:19358:0:99999:7:::
+backup:*:19358:0:99999:7:::
+list:*:19358:0:99999:7:::
+irc:*:19358:0:99999:7:::
+nobody:*:19358:0:99999:7:::
+systemd-network:*:19358:0:99999:7:::
+systemd-resolve:*:19358:0:99999:7:::
+messagebus:*:19358:0:99999:7:::
+systemd-timesync:*:19358:0:99999:7:::
+syslog:*:19358:0:99999:7:::
+_apt:*:19358:0:99999:7:::
+uuidd:*:19358:0:99999:7:::
+sshd:*:19358:0:99999:7:::
+mysql:!:19360:0:99999:7:::
+ubuntu:$6$J1TWDtkwtDDb.xHK$as1VOqg6YYZYn9ZhyiA4uoRgnatmUdjAWtGSU8po.799NksnRH9ucAUsdMlHUvTCQCyEZDz/TddJ8HyS5SUkCn:19358:0:99999:7:::
+deploy:$6$D8zRA9a9SkpXz9w3$QlY7Zkuvqdt7s8Stqcbnr3yBdGBLEPH1qhT61qtc4xatws8phP9nhFyJfm5di4PzJ59FHz5r1pY4OjE2jBMptU:19417:0:99999:7:::
+`
+
+// addOSRelease seeds /etc/os-release with the configured distribution, if any.
+func (fs *FileSystemType) addOSRelease(cfg distroConfig) {
+	if content := osRelease(cfg); content != "" {
+		fs.addFile("/etc/os-release", content)
+	}
+}
+
+// addEtcFiles seeds the configured account databases, /etc/shadow only being readable by root and the shadow group.
+func (fs *FileSystemType) addEtcFiles(cfg etcConfig) {
+	if cfg.Passwd != "" {
+		fs.addFile("/etc/passwd", cfg.Passwd)
+	}
+	if cfg.Shadow != "" {
+		shadow := fs.addFile("/etc/shadow", cfg.Shadow)
+		shadow.Mode = 0640
+		shadow.Group = "shadow"
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	mathRand "math/rand"
+	"sync"
+	"time"
+)
+
+var transientErrors = []string{
+	"%v: Resource temporarily unavailable",
+	"%v: Text file busy",
+	"%v: Device or resource busy",
+}
+
+// flakiness injects occasional imperfections into the fake shell, driven by a seeded RNG shared by all sessions.
+type flakiness struct {
+	flakinessConfig
+	mutex sync.Mutex
+	rand  *mathRand.Rand
+}
+
+func newFlakiness(cfg flakinessConfig) *flakiness {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &flakiness{flakinessConfig: cfg, rand: mathRand.New(mathRand.NewSource(seed))}
+}
+
+// next returns how long to delay the named command and, if it should fail, the transient error to print instead.
+func (f *flakiness) next(name string) (time.Duration, string) {
+	if f == nil {
+		return 0, ""
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delay := f.MinDelay
+	if f.MaxDelay > f.MinDelay {
+		delay += time.Duration(f.rand.Int63n(int64(f.MaxDelay - f.MinDelay)))
+	}
+	if f.ErrorRate > 0 && f.rand.Float64() < f.ErrorRate {
+		return delay, fmt.Sprintf(transientErrors[f.rand.Intn(len(transientErrors))], name)
+	}
+	return delay, ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlakinessErrorRate(t *testing.T) {
+	f := newFlakiness(flakinessConfig{ErrorRate: 0.2, MaxDelay: 10 * time.Millisecond, Seed: 1234})
+	const runs = 10000
+	failures := 0
+	for i := 0; i < runs; i++ {
+		delay, transientError := f.next("ls")
+		if delay < 0 || delay >= 10*time.Millisecond {
+			t.Fatalf("delay=%v, want between 0 and 10ms", delay)
+		}
+		if transientError != "" {
+			failures++
+		}
+	}
+	if rate := float64(failures) / runs; rate < 0.18 || rate > 0.22 {
+		t.Errorf("rate=%v, want about 0.2", rate)
+	}
+}
+
+func TestFlakinessReproducible(t *testing.T) {
+	cfg := flakinessConfig{ErrorRate: 0.5, MaxDelay: time.Second, Seed: 42}
+	f1, f2 := newFlakiness(cfg), newFlakiness(cfg)
+	for i := 0; i < 100; i++ {
+		delay1, transientError1 := f1.next("cat")
+		delay2, transientError2 := f2.next("cat")
+		if delay1 != delay2 || transientError1 != transientError2 {
+			t.Fatalf("run %v: (%v, %q) != (%v, %q)", i, delay1, transientError1, delay2, transientError2)
+		}
+	}
+}
+
+func TestFlakinessDisabled(t *testing.T) {
+	var f *flakiness
+	if delay, transientError := f.next("ls"); delay != 0 || transientError != "" {
+		t.Errorf("next()=(%v, %q), want (0, \"\")", delay, transientError)
+	}
+	f = newFlakiness(flakinessConfig{})
+	for i := 0; i < 100; i++ {
+		if delay, transientError := f.next("ls"); delay != 0 || transientError != "" {
+			t.Fatalf("next()=(%v, %q), want (0, \"\")", delay, transientError)
+		}
+	}
+}
+
+func TestShellFlakiness(t *testing.T) {
+	cfg := &config{flakiness: newFlakiness(flakinessConfig{ErrorRate: 1, Seed: 1})}
+	test := newCommandTest(t, cfg, false, "true")
+	test.run(t, "sh")
+	if test.stderr.String() == "" {
+		t.Errorf("stderr is empty, want a transient error")
+	}
+}
+
+func TestShellDelay(t *testing.T) {
+	cfg := &config{flakiness: newFlakiness(flakinessConfig{MinDelay: 50 * time.Millisecond, MaxDelay: 60 * time.Millisecond, Seed: 1})}
+	test := newCommandTest(t, cfg, false, "echo hi")
+	start := time.Now()
+	test.run(t, "sh")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("elapsed=%v, want the command to be delayed by at least 50ms", elapsed)
+	}
+	if test.stdout.String() != "hi\n" {
+		t.Errorf("stdout=%q, want %q", test.stdout.String(), "hi\n")
+	}
+}
+
+func TestShellDelayInterrupted(t *testing.T) {
+	cfg := &config{flakiness: newFlakiness(flakinessConfig{MinDelay: time.Hour})}
+	test := newCommandTest(t, cfg, false, "echo hi")
+	done := make(chan struct{})
+	close(done)
+	test.context.done = done
+	finished := make(chan uint32)
+	go func() {
+		finished <- test.run(t, "sh")
+	}()
+	select {
+	case status := <-finished:
+		if status != 129 {
+			t.Errorf("status=%v, want 129", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the delay to be interrupted by the end of the session")
+	}
+	if test.stdout.String() != "" {
+		t.Errorf("stdout=%q, want no output", test.stdout.String())
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// freeHumanSize formats a size in KiB like the -h option of free does, with binary unit suffixes.
+func freeHumanSize(size int) string {
+	if size == 0 {
+		return "0B"
+	}
+	value := float64(size)
+	for _, unit := range "KMGTP" {
+		if value < 10 {
+			return fmt.Sprintf("%.1f%ci", value, unit)
+		}
+		if value < 1024 {
+			return fmt.Sprintf("%d%ci", int(value), unit)
+		}
+		value /= 1024
+	}
+	return fmt.Sprintf("%d%ci", int(value), 'E')
+}
+
+type cmdFree struct{}
+
+func (cmdFree) execute(context commandContext) (uint32, error) {
+	format := func(size int) string { return fmt.Sprint(size) }
+	for _, arg := range context.args[1:] {
+		switch arg {
+		case "-b", "--bytes":
+			format = func(size int) string { return fmt.Sprint(size * 1024) }
+		case "-k", "--kibi":
+			format = func(size int) string { return fmt.Sprint(size) }
+		case "-m", "--mebi":
+			format = func(size int) string { return fmt.Sprint(size / 1024) }
+		case "-g", "--gibi":
+			format = func(size int) string { return fmt.Sprint(size / 1024 / 1024) }
+		case "-h", "--human":
+			format = freeHumanSize
+		default:
+			_, err := fmt.Fprintf(context.stderr, "free: invalid option -- '%v'\nUsage:\n free [options]\n", strings.TrimLeft(arg, "-"))
+			return 1, err
+		}
+	}
+	usage := newMemoryUsage(context.cfg.Shell.Hardware)
+	buffCache := usage.buffers + usage.cached
+	row := func(label string, values ...int) string {
+		line := fmt.Sprintf("%-7s", label)
+		for _, value := range values {
+			line += fmt.Sprintf(" %11s", format(value))
+		}
+		return line
+	}
+	lines := []string{
+		fmt.Sprintf("%7s %11s %11s %11s %11s %11s %11s", "", "total", "used", "free", "shared", "buff/cache", "available"),
+		row("Mem:", usage.total, usage.total-usage.free-buffCache, usage.free, usage.shared, buffCache, usage.available()),
+		row("Swap:", 0, 0, 0),
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
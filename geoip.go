@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIP looks up source addresses in MaxMind GeoLite2 City and ASN databases.
+type geoIP struct {
+	city, asn *maxminddb.Reader
+}
+
+func openGeoIP(cfg geoIPConfig) (*geoIP, error) {
+	if cfg.CityDatabase == "" && cfg.ASNDatabase == "" {
+		return nil, nil
+	}
+	result := &geoIP{}
+	if cfg.CityDatabase != "" {
+		reader, err := maxminddb.Open(cfg.CityDatabase)
+		if err != nil {
+			return nil, err
+		}
+		result.city = reader
+	}
+	if cfg.ASNDatabase != "" {
+		reader, err := maxminddb.Open(cfg.ASNDatabase)
+		if err != nil {
+			if result.city != nil {
+				result.city.Close()
+			}
+			return nil, err
+		}
+		result.asn = reader
+	}
+	return result, nil
+}
+
+type geoIPCityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type geoIPASNRecord struct {
+	AutonomousSystemNumber uint `maxminddb:"autonomous_system_number"`
+}
+
+// lookup returns what the databases know about the IP of addr, or nothing if no database is configured.
+func (g *geoIP) lookup(addr net.Addr) geoLog {
+	var result geoLog
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if g == nil || !ok {
+		return result
+	}
+	if g.city != nil {
+		var record geoIPCityRecord
+		if err := g.city.Lookup(tcpAddr.IP, &record); err != nil {
+			warningLogger.Printf("Failed to look up %v in the GeoIP city database: %v", tcpAddr.IP, err)
+		}
+		result.Country = record.Country.ISOCode
+		result.City = record.City.Names["en"]
+	}
+	if g.asn != nil {
+		var record geoIPASNRecord
+		if err := g.asn.Lookup(tcpAddr.IP, &record); err != nil {
+			warningLogger.Printf("Failed to look up %v in the GeoIP ASN database: %v", tcpAddr.IP, err)
+		}
+		result.ASN = record.AutonomousSystemNumber
+	}
+	return result
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+// encodeMMDBValue encodes a value in the MaxMind DB data section format.
+func encodeMMDBValue(value interface{}) []byte {
+	control := func(dataType, size int) []byte {
+		if dataType > 7 {
+			return []byte{byte(size), byte(dataType - 7)}
+		}
+		return []byte{byte(dataType<<5 | size)}
+	}
+	unsigned := func(dataType int, value uint64) []byte {
+		var encoded []byte
+		for ; value != 0; value >>= 8 {
+			encoded = append([]byte{byte(value)}, encoded...)
+		}
+		return append(control(dataType, len(encoded)), encoded...)
+	}
+	switch value := value.(type) {
+	case string:
+		return append(control(2, len(value)), value...)
+	case uint16:
+		return unsigned(5, uint64(value))
+	case uint32:
+		return unsigned(6, uint64(value))
+	case uint64:
+		return unsigned(9, value)
+	case []interface{}:
+		encoded := control(11, len(value))
+		for _, element := range value {
+			encoded = append(encoded, encodeMMDBValue(element)...)
+		}
+		return encoded
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encoded := control(7, len(value))
+		for _, key := range keys {
+			encoded = append(encoded, encodeMMDBValue(key)...)
+			encoded = append(encoded, encodeMMDBValue(value[key])...)
+		}
+		return encoded
+	default:
+		panic("unsupported value")
+	}
+}
+
+// writeTestMMDB writes an IPv4 MaxMind DB mapping a single network to a record.
+func writeTestMMDB(t *testing.T, network string, record map[string]interface{}) string {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixLength, _ := ipNet.Mask.Size()
+	ip := binary.BigEndian.Uint32(ipNet.IP.To4())
+	nodeCount := uint32(prefixLength)
+	var file bytes.Buffer
+	for node := uint32(0); node < nodeCount; node++ {
+		records := [2]uint32{nodeCount, nodeCount}
+		next := node + 1
+		if next == nodeCount {
+			next = nodeCount + 16
+		}
+		records[ip>>(31-node)&1] = next
+		for _, value := range records {
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(encodeMMDBValue(record))
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.Write(encodeMMDBValue(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1600000000),
+		"database_type":               "Test",
+		"description":                 map[string]interface{}{"en": "Test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(24),
+	}))
+	filePath := path.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(filePath, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	geoIP, err := openGeoIP(geoIPConfig{
+		CityDatabase: writeTestMMDB(t, "127.0.0.0/8", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "NL"},
+			"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Amsterdam"}},
+		}),
+		ASNDatabase: writeTestMMDB(t, "127.0.0.0/24", map[string]interface{}{
+			"autonomous_system_number":       uint32(1136),
+			"autonomous_system_organization": "KPN B.V.",
+		}),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open GeoIP databases: %v", err)
+	}
+	expected := geoLog{Country: "NL", City: "Amsterdam", ASN: 1136}
+	if result := geoIP.lookup(mockConnContext{}.RemoteAddr()); result != expected {
+		t.Errorf("lookup(127.0.0.1)=%v, want %v", result, expected)
+	}
+	if result := geoIP.lookup(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}); result != (geoLog{}) {
+		t.Errorf("lookup(10.0.0.1)=%v, want nothing", result)
+	}
+
+	cfg := &config{geoIP: geoIP}
+	cfg.Logging.JSON = true
+	logs := setupLogBuffer(t, cfg)
+	connContext{ConnMetadata: mockConnContext{}, cfg: cfg}.logEvent(passwordAuthLog{
+		authLog:  authLog{User: "root", geoLog: cfg.geoIP.lookup(mockConnContext{}.RemoteAddr())},
+		Password: "hunter2",
+	})
+	var entry struct {
+		Event map[string]interface{} `json:"event"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse logs: %v", err)
+	}
+	if entry.Event["country"] != "NL" || entry.Event["city"] != "Amsterdam" || entry.Event["asn"] != 1136.0 {
+		t.Errorf("event=%v, want the GeoIP fields", entry.Event)
+	}
+}
+
+func TestGeoIPDisabled(t *testing.T) {
+	geoIP, err := openGeoIP(geoIPConfig{})
+	if err != nil {
+		t.Fatalf("Failed to open GeoIP databases: %v", err)
+	}
+	if result := geoIP.lookup(mockConnContext{}.RemoteAddr()); result != (geoLog{}) {
+		t.Errorf("lookup(127.0.0.1)=%v, want nothing", result)
+	}
+}
@@ -5,6 +5,8 @@ go 1.22
 require (
 	github.com/adrg/xdg v0.5.0
 	github.com/jaksi/sshutils v0.0.13
+	github.com/oschwald/maxminddb-golang v1.13.1
+	github.com/pkg/sftp v1.13.7
 	github.com/prometheus/client_golang v1.19.1
 	golang.org/x/crypto v0.33.0
 	golang.org/x/term v0.29.0
@@ -14,6 +16,8 @@ require (
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/prometheus/client_model v0.5.0 // indirect
 	github.com/prometheus/common v0.48.0 // indirect
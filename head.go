@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultHeadLines = 10
+
+func init() {
+	registerCommand("head", cmdHead{})
+}
+
+type cmdHead struct{}
+
+func (cmdHead) execute(context commandContext) (uint32, error) {
+	lines := defaultHeadLines
+	var files []string
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var count string
+		switch {
+		case arg == "-n" || arg == "--lines":
+			if i+1 == len(args) {
+				_, err := fmt.Fprintf(context.stderr, "head: option requires an argument -- 'n'\nTry 'head --help' for more information.\n")
+				return 1, err
+			}
+			i++
+			count = args[i]
+		case strings.HasPrefix(arg, "--lines="):
+			count = strings.TrimPrefix(arg, "--lines=")
+		case strings.HasPrefix(arg, "-n"):
+			count = arg[2:]
+		case len(arg) > 1 && strings.HasPrefix(arg, "-"):
+			count = arg[1:]
+		default:
+			files = append(files, arg)
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			_, err := fmt.Fprintf(context.stderr, "head: invalid number of lines: '%v'\n", count)
+			return 1, err
+		}
+		lines = n
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var status uint32
+	for i, file := range files {
+		if len(files) > 1 {
+			header := fmt.Sprintf("==> %v <==", file)
+			if file == "-" {
+				header = "==> standard input <=="
+			}
+			if i > 0 {
+				header = "\n" + header
+			}
+			if _, err := fmt.Fprintln(context.stdout, header); err != nil {
+				return 1, err
+			}
+		}
+		if file == "-" {
+			for n := 0; n < lines; n++ {
+				line, err := context.stdin.ReadLine()
+				if endOfInput(err) {
+					break
+				}
+				if err != nil {
+					return 1, err
+				}
+				if _, err := fmt.Fprintln(context.stdout, line); err != nil {
+					return 1, err
+				}
+			}
+			continue
+		}
+		node := context.state.fs.lookup(file)
+		switch {
+		case node == nil:
+			if _, err := fmt.Fprintf(context.stderr, "head: cannot open '%v' for reading: No such file or directory\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		case node.IsDir:
+			if _, err := fmt.Fprintf(context.stderr, "head: error reading '%v': Is a directory\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		case !node.canRead(context.user):
+			if _, err := fmt.Fprintf(context.stderr, "head: cannot open '%v' for reading: Permission denied\n", file); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		if node.Canary {
+			context.logEvent(canaryLog{
+				channelLog: channelLog{ChannelID: context.channelID},
+				Path:       context.state.fs.absPath(file),
+			})
+		}
+		if node.Bait {
+			context.state.baitRead = append(context.state.baitRead, context.state.fs.absPath(file))
+		}
+		// Only read as far as needed, lazy content may be huge
+		reader := bufio.NewReader(node.reader())
+		for n := 0; n < lines; n++ {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				if _, err := fmt.Fprint(context.stdout, line); err != nil {
+					return 1, err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 1, err
+			}
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultLoginHistory = []loginConfig{
+	{User: "root", TTY: "pts/1", Source: "198.51.100.23", Ago: 2*time.Hour + 14*time.Minute, Duration: 47 * time.Minute},
+	{User: "deploy", TTY: "pts/0", Source: "10.0.4.12", Ago: 26*time.Hour + 3*time.Minute, Duration: 3*time.Hour + 12*time.Minute},
+	{User: "root", TTY: "pts/0", Source: "198.51.100.23", Ago: 50*time.Hour + 41*time.Minute, Duration: 12 * time.Minute},
+}
+
+const lastTimeFormat = "Mon Jan _2 15:04"
+
+// formatLoginDuration formats how long a session lasted like last does, with days only if there are any.
+func formatLoginDuration(duration time.Duration) string {
+	minutes := int(duration / time.Minute)
+	if days := minutes / (24 * 60); days > 0 {
+		return fmt.Sprintf("(%v+%02d:%02d)", days, minutes/60%24, minutes%60)
+	}
+	return fmt.Sprintf("(%02d:%02d)", minutes/60, minutes%60)
+}
+
+func init() {
+	registerCommand("last", cmdLast{})
+}
+
+type cmdLast struct{}
+
+func (cmdLast) execute(context commandContext) (uint32, error) {
+	limit := -1
+	users := map[string]bool{}
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		switch {
+		case arg == "-n" && i+1 < len(args):
+			i++
+			value = args[i]
+		case strings.HasPrefix(arg, "-"):
+			value = arg[1:]
+		default:
+			users[arg] = true
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			_, err := fmt.Fprintf(context.stderr, "last: failed to parse number of lines: '%v'\n", value)
+			return 1, err
+		}
+		limit = n
+	}
+
+	now := context.cfg.clock.now()
+	source, _, err := net.SplitHostPort(context.RemoteAddr().String())
+	if err != nil {
+		source = context.RemoteAddr().String()
+	}
+	var rows []string
+	addRow := func(user, tty, source string, login time.Time, status string) {
+		if (len(users) == 0 || users[user]) && (limit < 0 || len(rows) < limit) {
+			rows = append(rows, fmt.Sprintf("%-8.8v %-12.12v %-16.16v %-16.16v %v", user, tty, source, login.Format(lastTimeFormat), status))
+		}
+	}
+	// Only interactive sessions get a terminal and are recorded in wtmp.
+	if context.pty {
+		addRow(context.user, "pts/2", source, now, "  still logged in")
+	}
+	for _, login := range context.cfg.Shell.LoginHistory {
+		start := now.Add(-login.Ago)
+		addRow(login.User, login.TTY, login.Source, start, fmt.Sprintf("- %v  %v", start.Add(login.Duration).Format("15:04"), formatLoginDuration(login.Duration)))
+	}
+	boot := context.cfg.clock.boot()
+	addRow("reboot", "system boot", context.cfg.Shell.Distro.KernelRelease, boot, "  still running")
+
+	output := ""
+	for _, row := range rows {
+		output += strings.TrimRight(row, " ") + "\n"
+	}
+	_, err = fmt.Fprintf(context.stdout, "%v\nwtmp begins %v\n", output, boot.Format("Mon Jan _2 15:04:05 2006"))
+	return 0, err
+}
@@ -44,9 +44,17 @@ func (accepted authAccepted) String() string {
 	return "rejected"
 }
 
+// geoLog is what is known about the location of a source address, empty unless GeoIP databases are configured.
+type geoLog struct {
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+}
+
 type authLog struct {
 	User     string       `json:"user"`
 	Accepted authAccepted `json:"accepted"`
+	geoLog
 }
 
 type noAuthLog struct {
@@ -75,6 +83,9 @@ func (entry passwordAuthLog) eventType() string {
 type publicKeyAuthLog struct {
 	authLog
 	PublicKeyFingerprint string `json:"public_key"`
+	PublicKeyType        string `json:"public_key_type"`
+	// PublicKey is the base64 encoded wire format of the key, as found in authorized_keys files.
+	PublicKey string `json:"public_key_data"`
 }
 
 func (entry publicKeyAuthLog) String() string {
@@ -96,22 +107,93 @@ func (entry keyboardInteractiveAuthLog) eventType() string {
 	return "keyboard_interactive_auth"
 }
 
+type passwordSprayLog struct {
+	Users     int    `json:"users"`
+	Passwords int    `json:"passwords"`
+	Window    string `json:"window"`
+}
+
+func (entry passwordSprayLog) String() string {
+	return fmt.Sprintf("password spray detected: %v users tried with %v passwords within %v", entry.Users, entry.Passwords, entry.Window)
+}
+func (entry passwordSprayLog) eventType() string {
+	return "password_spray_detected"
+}
+
+type rateLimitLog struct{}
+
+func (entry rateLimitLog) String() string {
+	return "connection rate limited"
+}
+func (entry rateLimitLog) eventType() string {
+	return "rate_limited"
+}
+
+type accessDeniedLog struct {
+	Reason string `json:"reason"`
+}
+
+func (entry accessDeniedLog) String() string {
+	return fmt.Sprintf("connection denied: %v", entry.Reason)
+}
+func (entry accessDeniedLog) eventType() string {
+	return "access_denied"
+}
+
+type connectionLimitLog struct{}
+
+func (entry connectionLimitLog) String() string {
+	return "connection rejected over the connection limit"
+}
+func (entry connectionLimitLog) eventType() string {
+	return "connection_limited"
+}
+
+type oneShotTrippedLog struct {
+	User string `json:"user"`
+}
+
+func (entry oneShotTrippedLog) String() string {
+	return fmt.Sprintf("honeypot tripped by user %q, rejecting further connections", entry.User)
+}
+func (entry oneShotTrippedLog) eventType() string {
+	return "one_shot_tripped"
+}
+
+type oneShotRejectLog struct{}
+
+func (entry oneShotRejectLog) String() string {
+	return "connection rejected after the honeypot tripped"
+}
+func (entry oneShotRejectLog) eventType() string {
+	return "one_shot_rejected"
+}
+
 type connectionLog struct {
 	ClientVersion string `json:"client_version"`
+	// Listener is the local address the connection was accepted on.
+	Listener string `json:"listener"`
+	geoLog
 }
 
 func (entry connectionLog) String() string {
-	return fmt.Sprintf("connection with client version %q established", entry.ClientVersion)
+	return fmt.Sprintf("connection with client version %q established on %v", entry.ClientVersion, entry.Listener)
 }
 func (entry connectionLog) eventType() string {
 	return "connection"
 }
 
 type connectionCloseLog struct {
+	// Duration is how long the connection lasted in seconds.
+	Duration   float64 `json:"duration"`
+	Channels   int     `json:"channels"`
+	User       string  `json:"user"`
+	AuthMethod string  `json:"auth_method"`
 }
 
 func (entry connectionCloseLog) String() string {
-	return "connection closed"
+	duration := time.Duration(entry.Duration * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("connection closed after %v (%v channels, user %q, auth method %v)", duration, entry.Channels, entry.User, entry.AuthMethod)
 }
 func (entry connectionCloseLog) eventType() string {
 	return "connection_close"
@@ -190,6 +272,17 @@ func (entry sessionCloseLog) eventType() string {
 	return "session_close"
 }
 
+type sessionTimeoutLog struct {
+	channelLog
+}
+
+func (entry sessionTimeoutLog) String() string {
+	return fmt.Sprintf("[channel %v] session duration limit reached", entry.ChannelID)
+}
+func (entry sessionTimeoutLog) eventType() string {
+	return "session_timeout"
+}
+
 type sessionInputLog struct {
 	channelLog
 	Input string `json:"input"`
@@ -202,32 +295,44 @@ func (entry sessionInputLog) eventType() string {
 	return "session_input"
 }
 
-type directTCPIPLog struct {
+type forwardLog struct {
 	channelLog
-	From interface{} `json:"from"`
-	To   interface{} `json:"to"`
+	ConnectionID string `json:"connection_id"`
+	ForwardIndex int    `json:"forward_index"`
+}
+
+type directTCPIPLog struct {
+	forwardLog
+	From           interface{} `json:"from"`
+	To             interface{} `json:"to"`
+	OriginatorIP   string      `json:"originator_ip,omitempty"`
+	OriginatorPort uint32      `json:"originator_port"`
 }
 
 func (entry directTCPIPLog) String() string {
-	return fmt.Sprintf("[channel %v] direct TCP/IP forwarding from %v to %v requested", entry.ChannelID, entry.From, entry.To)
+	return fmt.Sprintf("[channel %v] direct TCP/IP forwarding #%v of connection %v from %v to %v requested", entry.ChannelID, entry.ForwardIndex, entry.ConnectionID, entry.From, entry.To)
 }
 func (entry directTCPIPLog) eventType() string {
 	return "direct_tcpip"
 }
 
 type directTCPIPCloseLog struct {
-	channelLog
+	forwardLog
+	Service       string `json:"service"`
+	Requests      int    `json:"requests"`
+	BytesReceived int64  `json:"bytes_received"`
+	BytesSent     int64  `json:"bytes_sent"`
 }
 
 func (entry directTCPIPCloseLog) String() string {
-	return fmt.Sprintf("[channel %v] closed", entry.ChannelID)
+	return fmt.Sprintf("[channel %v] closed (%v, %v requests, %v bytes received, %v bytes sent)", entry.ChannelID, entry.Service, entry.Requests, entry.BytesReceived, entry.BytesSent)
 }
 func (entry directTCPIPCloseLog) eventType() string {
 	return "direct_tcpip_close"
 }
 
 type directTCPIPInputLog struct {
-	channelLog
+	forwardLog
 	Input string `json:"input"`
 }
 
@@ -238,14 +343,59 @@ func (entry directTCPIPInputLog) eventType() string {
 	return "direct_tcpip_input"
 }
 
+type directTCPIPOutputLog struct {
+	forwardLog
+	Output string `json:"output"`
+}
+
+func (entry directTCPIPOutputLog) String() string {
+	return fmt.Sprintf("[channel %v] output: %q", entry.ChannelID, entry.Output)
+}
+func (entry directTCPIPOutputLog) eventType() string {
+	return "direct_tcpip_output"
+}
+
+type forwardedTCPIPLog struct {
+	channelLog
+	Connected      interface{} `json:"connected"`
+	Originator     interface{} `json:"originator"`
+	OriginatorIP   string      `json:"originator_ip,omitempty"`
+	OriginatorPort uint32      `json:"originator_port"`
+}
+
+func (entry forwardedTCPIPLog) String() string {
+	return fmt.Sprintf("[channel %v] forwarded TCP/IP connection to %v from %v opened", entry.ChannelID, entry.Connected, entry.Originator)
+}
+func (entry forwardedTCPIPLog) eventType() string {
+	return "forwarded_tcpip"
+}
+
+type forwardedTCPIPInputLog struct {
+	channelLog
+	Input string `json:"input"`
+}
+
+func (entry forwardedTCPIPInputLog) String() string {
+	return fmt.Sprintf("[channel %v] input: %q", entry.ChannelID, entry.Input)
+}
+func (entry forwardedTCPIPInputLog) eventType() string {
+	return "forwarded_tcpip_input"
+}
+
 type ptyLog struct {
 	channelLog
-	Terminal string `json:"terminal"`
-	Width    uint32 `json:"width"`
-	Height   uint32 `json:"height"`
+	Terminal    string            `json:"terminal"`
+	Width       uint32            `json:"width"`
+	Height      uint32            `json:"height"`
+	PixelWidth  uint32            `json:"pixel_width,omitempty"`
+	PixelHeight uint32            `json:"pixel_height,omitempty"`
+	Modes       map[string]uint32 `json:"modes,omitempty"`
 }
 
 func (entry ptyLog) String() string {
+	if modes := formatNotableTerminalModes(entry.Modes); modes != "" {
+		return fmt.Sprintf("[channel %v] PTY using terminal %q (size %vx%v, modes %v) requested", entry.ChannelID, entry.Terminal, entry.Width, entry.Height, modes)
+	}
 	return fmt.Sprintf("[channel %v] PTY using terminal %q (size %vx%v) requested", entry.ChannelID, entry.Terminal, entry.Width, entry.Height)
 }
 func (entry ptyLog) eventType() string {
@@ -275,6 +425,41 @@ func (entry execLog) eventType() string {
 	return "exec"
 }
 
+type shellCommandLog struct {
+	channelLog
+	Command string `json:"command"`
+}
+
+func (entry shellCommandLog) String() string {
+	return fmt.Sprintf("[channel %v] shell command %q entered", entry.ChannelID, entry.Command)
+}
+func (entry shellCommandLog) eventType() string {
+	return "shell_command"
+}
+
+type commandLog struct {
+	channelLog
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	ExitStatus uint32   `json:"exit_status"`
+	User       string   `json:"user"`
+	Cwd        string   `json:"cwd"`
+	// BaitAccessed is set if the command read any bait files, listed in BaitFiles.
+	BaitAccessed bool     `json:"bait_accessed,omitempty"`
+	BaitFiles    []string `json:"bait_files,omitempty"`
+}
+
+func (entry commandLog) String() string {
+	message := fmt.Sprintf("[channel %v] command %q with arguments %q run as user %q exited with status %v", entry.ChannelID, entry.Command, entry.Args, entry.User, entry.ExitStatus)
+	if entry.BaitAccessed {
+		message += fmt.Sprintf(", bait files %q accessed", entry.BaitFiles)
+	}
+	return message
+}
+func (entry commandLog) eventType() string {
+	return "command"
+}
+
 type subsystemLog struct {
 	channelLog
 	Subsystem string `json:"subsystem"`
@@ -325,6 +510,315 @@ func (entry windowChangeLog) eventType() string {
 	return "window_change"
 }
 
+type fileTransferLog struct {
+	channelLog
+	Client   string `json:"client"`
+	Target   string `json:"target"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func (entry fileTransferLog) String() string {
+	return fmt.Sprintf("[channel %v] %v connection to %q as user %q with password %q attempted", entry.ChannelID, entry.Client, entry.Target, entry.User, entry.Password)
+}
+func (entry fileTransferLog) eventType() string {
+	return "file_transfer"
+}
+
+type fileTransferCommandLog struct {
+	channelLog
+	Client  string `json:"client"`
+	Command string `json:"command"`
+}
+
+func (entry fileTransferCommandLog) String() string {
+	return fmt.Sprintf("[channel %v] %v command %q", entry.ChannelID, entry.Client, entry.Command)
+}
+func (entry fileTransferCommandLog) eventType() string {
+	return "file_transfer_command"
+}
+
+type sudoLog struct {
+	channelLog
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func (entry sudoLog) String() string {
+	return fmt.Sprintf("[channel %v] sudo password for user %q entered: %q", entry.ChannelID, entry.User, entry.Password)
+}
+func (entry sudoLog) eventType() string {
+	return "sudo"
+}
+
+type suLog struct {
+	channelLog
+	authLog
+	Password string `json:"password"`
+}
+
+func (entry suLog) String() string {
+	return fmt.Sprintf("[channel %v] su to user %q with password %q %v", entry.ChannelID, entry.User, entry.Password, entry.Accepted)
+}
+func (entry suLog) eventType() string {
+	return "su"
+}
+
+type passwdLog struct {
+	channelLog
+	User            string   `json:"user"`
+	CurrentPassword string   `json:"current_password,omitempty"`
+	NewPasswords    []string `json:"new_passwords"`
+}
+
+func (entry passwdLog) String() string {
+	return fmt.Sprintf("[channel %v] password change for user %q with current password %q and new passwords %q", entry.ChannelID, entry.User, entry.CurrentPassword, entry.NewPasswords)
+}
+func (entry passwdLog) eventType() string {
+	return "passwd"
+}
+
+type downloadLog struct {
+	channelLog
+	Client string `json:"client"`
+	URL    string `json:"url"`
+}
+
+func (entry downloadLog) String() string {
+	return fmt.Sprintf("[channel %v] download of %q attempted with %v", entry.ChannelID, entry.URL, entry.Client)
+}
+func (entry downloadLog) eventType() string {
+	return "download"
+}
+
+type killLog struct {
+	channelLog
+	Signal string   `json:"signal"`
+	PIDs   []string `json:"pids"`
+}
+
+func (entry killLog) String() string {
+	return fmt.Sprintf("[channel %v] kill of processes %q with signal %v attempted", entry.ChannelID, entry.PIDs, entry.Signal)
+}
+func (entry killLog) eventType() string {
+	return "kill"
+}
+
+type pkillLog struct {
+	channelLog
+	Signal   string   `json:"signal"`
+	Patterns []string `json:"patterns"`
+	PIDs     []int    `json:"pids"`
+	Commands []string `json:"commands"`
+}
+
+func (entry pkillLog) String() string {
+	return fmt.Sprintf("[channel %v] kill of processes matching %q with signal %v attempted, matched %q", entry.ChannelID, entry.Patterns, entry.Signal, entry.Commands)
+}
+func (entry pkillLog) eventType() string {
+	return "pkill"
+}
+
+type crontabLog struct {
+	channelLog
+	User    string `json:"user"`
+	Crontab string `json:"crontab"`
+}
+
+func (entry crontabLog) String() string {
+	return fmt.Sprintf("[channel %v] crontab for user %q installed: %q", entry.ChannelID, entry.User, entry.Crontab)
+}
+func (entry crontabLog) eventType() string {
+	return "crontab"
+}
+
+type packageLog struct {
+	channelLog
+	Manager   string   `json:"manager"`
+	Operation string   `json:"operation"`
+	Packages  []string `json:"packages"`
+	// Denied is set if the package manager refused to run for a user other than root.
+	Denied bool `json:"denied,omitempty"`
+}
+
+func (entry packageLog) String() string {
+	message := fmt.Sprintf("[channel %v] %v %v of packages %q attempted", entry.ChannelID, entry.Manager, entry.Operation, entry.Packages)
+	if entry.Denied {
+		message += ", denied without root"
+	}
+	return message
+}
+func (entry packageLog) eventType() string {
+	return "package"
+}
+
+type base64DecodeLog struct {
+	channelLog
+	Decoded string `json:"decoded"`
+}
+
+func (entry base64DecodeLog) String() string {
+	return fmt.Sprintf("[channel %v] base64 decoded %q", entry.ChannelID, entry.Decoded)
+}
+func (entry base64DecodeLog) eventType() string {
+	return "base64_decode"
+}
+
+type pingLog struct {
+	channelLog
+	Target string `json:"target"`
+	Count  int    `json:"count"`
+}
+
+func (entry pingLog) String() string {
+	return fmt.Sprintf("[channel %v] ping of %q attempted", entry.ChannelID, entry.Target)
+}
+func (entry pingLog) eventType() string {
+	return "ping"
+}
+
+type sleepLog struct {
+	channelLog
+	Duration string `json:"duration"`
+}
+
+func (entry sleepLog) String() string {
+	return fmt.Sprintf("[channel %v] sleep of %v requested", entry.ChannelID, entry.Duration)
+}
+func (entry sleepLog) eventType() string {
+	return "sleep"
+}
+
+type tarLog struct {
+	channelLog
+	Operation string   `json:"operation"`
+	Archive   string   `json:"archive"`
+	Files     []string `json:"files"`
+}
+
+func (entry tarLog) String() string {
+	return fmt.Sprintf("[channel %v] tar %v of archive %q with files %q", entry.ChannelID, entry.Operation, entry.Archive, entry.Files)
+}
+func (entry tarLog) eventType() string {
+	return "tar"
+}
+
+type sftpRequestLog struct {
+	channelLog
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+}
+
+func (entry sftpRequestLog) String() string {
+	if entry.Target != "" {
+		return fmt.Sprintf("[channel %v] sftp %v of %q to %q requested", entry.ChannelID, entry.Method, entry.Path, entry.Target)
+	}
+	return fmt.Sprintf("[channel %v] sftp %v of %q requested", entry.ChannelID, entry.Method, entry.Path)
+}
+func (entry sftpRequestLog) eventType() string {
+	return "sftp_request"
+}
+
+type scpUploadLog struct {
+	channelLog
+	Path   string `json:"path"`
+	Mode   string `json:"mode"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func (entry scpUploadLog) String() string {
+	return fmt.Sprintf("[channel %v] scp upload of %q (mode %v, %v bytes, SHA-256 %v)", entry.ChannelID, entry.Path, entry.Mode, entry.Size, entry.SHA256)
+}
+func (entry scpUploadLog) eventType() string {
+	return "scp_upload"
+}
+
+type fileWriteLog struct {
+	channelLog
+	Path    string `json:"path"`
+	Editor  string `json:"editor"`
+	Content string `json:"content"`
+}
+
+func (entry fileWriteLog) String() string {
+	return fmt.Sprintf("[channel %v] %v wrote %q: %q", entry.ChannelID, entry.Editor, entry.Path, entry.Content)
+}
+func (entry fileWriteLog) eventType() string {
+	return "file_write"
+}
+
+type canaryLog struct {
+	channelLog
+	Path string `json:"path"`
+}
+
+func (entry canaryLog) String() string {
+	return fmt.Sprintf("[channel %v] canary file %q read", entry.ChannelID, entry.Path)
+}
+func (entry canaryLog) eventType() string {
+	return "canary"
+}
+
+type dockerRunLog struct {
+	channelLog
+	Image   string   `json:"image"`
+	Command []string `json:"command"`
+}
+
+func (entry dockerRunLog) String() string {
+	return fmt.Sprintf("[channel %v] docker run of image %q with command %q", entry.ChannelID, entry.Image, entry.Command)
+}
+func (entry dockerRunLog) eventType() string {
+	return "docker_run"
+}
+
+type cloudReconLog struct {
+	channelLog
+	Command string `json:"command"`
+	Profile string `json:"profile"`
+}
+
+func (entry cloudReconLog) String() string {
+	return fmt.Sprintf("[channel %v] cloud recon %q with profile %q", entry.ChannelID, entry.Command, entry.Profile)
+}
+func (entry cloudReconLog) eventType() string {
+	return "cloud_recon"
+}
+
+type channelRejectLog struct {
+	ChannelType string `json:"channel_type"`
+	Reason      string `json:"reason"`
+}
+
+func (entry channelRejectLog) String() string {
+	return fmt.Sprintf("%v channel rejected: %v", entry.ChannelType, entry.Reason)
+}
+func (entry channelRejectLog) eventType() string {
+	return "channel_rejected"
+}
+
+type globalRequestLog struct {
+	RequestType string `json:"request_type"`
+	WantReply   bool   `json:"want_reply"`
+	Accepted    bool   `json:"accepted"`
+}
+
+func (entry globalRequestLog) String() string {
+	reply := "failure"
+	if entry.Accepted {
+		reply = "success"
+	}
+	if !entry.WantReply {
+		reply = "no reply"
+	}
+	return fmt.Sprintf("global request %q received, replied with %v", entry.RequestType, reply)
+}
+func (entry globalRequestLog) eventType() string {
+	return "global_request"
+}
+
 type debugGlobalRequestLog struct {
 	RequestType string `json:"request_type"`
 	WantReply   bool   `json:"want_reply"`
@@ -345,8 +839,9 @@ func (entry debugGlobalRequestLog) eventType() string {
 
 type debugChannelLog struct {
 	channelLog
-	ChannelType string `json:"channel_type"`
-	ExtraData   string `json:"extra_data"`
+	ChannelType     string `json:"channel_type"`
+	ExtraData       string `json:"extra_data"`
+	ExtraDataLength int    `json:"extra_data_length"`
 }
 
 func (entry debugChannelLog) String() string {
@@ -361,6 +856,14 @@ func (entry debugChannelLog) eventType() string {
 	return "debug_channel"
 }
 
+// truncateLogData returns data as a string, cut to maxBytes with a marker if it's longer. Zero means unlimited.
+func truncateLogData(data []byte, maxBytes int) string {
+	if maxBytes <= 0 || len(data) <= maxBytes {
+		return string(data)
+	}
+	return string(data[:maxBytes]) + "...(truncated)"
+}
+
 type debugChannelRequestLog struct {
 	channelLog
 	RequestType string `json:"request_type"`
@@ -380,29 +883,43 @@ func (entry debugChannelRequestLog) eventType() string {
 	return "debug_channel_request"
 }
 
+// jsonLogEntry marshals an event with its source and type, and optionally the current time.
+func (context connContext) jsonLogEntry(entry logEntry, timestamp bool) ([]byte, error) {
+	var jsonEntry interface{}
+	tcpSource := context.RemoteAddr().(*net.TCPAddr)
+	source := getAddressLog(tcpSource.IP.String(), tcpSource.Port, context.cfg)
+	if timestamp {
+		jsonEntry = struct {
+			Time      string      `json:"time"`
+			Source    interface{} `json:"source"`
+			EventType string      `json:"event_type"`
+			Event     logEntry    `json:"event"`
+		}{time.Now().Format(time.RFC3339), source, entry.eventType(), entry}
+	} else {
+		jsonEntry = struct {
+			Source    interface{} `json:"source"`
+			EventType string      `json:"event_type"`
+			Event     logEntry    `json:"event"`
+		}{source, entry.eventType(), entry}
+	}
+	return json.Marshal(jsonEntry)
+}
+
 func (context connContext) logEvent(entry logEntry) {
 	if strings.HasPrefix(entry.eventType(), "debug_") && !context.cfg.Logging.Debug {
 		return
 	}
-	if context.cfg.Logging.JSON {
-		var jsonEntry interface{}
-		tcpSource := context.RemoteAddr().(*net.TCPAddr)
-		source := getAddressLog(tcpSource.IP.String(), tcpSource.Port, context.cfg)
-		if context.cfg.Logging.Timestamps {
-			jsonEntry = struct {
-				Time      string      `json:"time"`
-				Source    interface{} `json:"source"`
-				EventType string      `json:"event_type"`
-				Event     logEntry    `json:"event"`
-			}{time.Now().Format(time.RFC3339), source, entry.eventType(), entry}
-		} else {
-			jsonEntry = struct {
-				Source    interface{} `json:"source"`
-				EventType string      `json:"event_type"`
-				Event     logEntry    `json:"event"`
-			}{source, entry.eventType(), entry}
+	if context.cfg.auditLog != nil {
+		record, err := context.jsonLogEntry(entry, true)
+		if err == nil {
+			err = context.cfg.auditLog.write(record)
 		}
-		logBytes, err := json.Marshal(jsonEntry)
+		if err != nil {
+			warningLogger.Printf("Failed to write audit log: %v", err)
+		}
+	}
+	if context.cfg.Logging.JSON {
+		logBytes, err := context.jsonLogEntry(entry, context.cfg.Logging.Timestamps)
 		if err != nil {
 			warningLogger.Printf("Failed to log event: %v", err)
 			return
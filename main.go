@@ -62,13 +62,34 @@ func main() {
 	}()
 	signal.Notify(reloadSignals, syscall.SIGHUP)
 
-	listener, err := sshutils.Listen(cfg.Server.ListenAddress, cfg.sshConfig)
-	if err != nil {
-		errorLogger.Fatalf("Failed to listen for connections: %v", err)
+	// The limits apply to all listeners together
+	limiter := newRateLimiter(cfg.Server.RateLimit)
+	connectionSlots := make(chan struct{}, cfg.Server.MaxConnections)
+	var listeners []*sshutils.Listener
+	for _, address := range cfg.Server.listenAddresses() {
+		listener, err := sshutils.Listen(address, cfg.sshConfig)
+		if err != nil {
+			errorLogger.Fatalf("Failed to listen for connections: %v", err)
+		}
+		defer listener.Close()
+		if cfg.Server.ProxyProtocol {
+			listener.Listener = newProxyProtocolListener(listener.Listener)
+		}
+		if cfg.accessList != nil {
+			listener.Listener = newAccessListListener(listener.Listener, cfg)
+		}
+		if cfg.Server.RateLimit.Rate > 0 {
+			listener.Listener = newRateLimitedListener(listener.Listener, limiter, cfg)
+		}
+		if cfg.Server.MaxConnections > 0 {
+			listener.Listener = newConnectionLimitedListener(listener.Listener, connectionSlots, cfg)
+		}
+		if cfg.Auth.OneShot {
+			listener.Listener = newOneShotListener(listener.Listener, cfg)
+		}
+		infoLogger.Printf("Listening on %v", listener.Addr())
+		listeners = append(listeners, listener)
 	}
-	defer listener.Close()
-
-	infoLogger.Printf("Listening on %v", listener.Addr())
 
 	if cfg.Logging.MetricsAddress != "" {
 		http.Handle("/metrics", promhttp.Handler())
@@ -80,12 +101,16 @@ func main() {
 		}()
 	}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			warningLogger.Printf("Failed to accept connection: %v", err)
-			continue
-		}
-		go handleConnection(conn, cfg)
-	}
+	server := newSSHServer(listeners, cfg)
+	shutdownSignals := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		signal := <-shutdownSignals
+		infoLogger.Printf("Shutting down due to %s", signal)
+		server.shutdown(cfg.Server.ShutdownGracePeriod)
+	}()
+	server.serve()
+	<-shutdownDone
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+// motdData is what the MOTD template is executed with.
+type motdData struct {
+	Hostname string
+	User     string
+}
+
+// lastLogin fabricates the previous login of a user, from an address and at a time derived from the user name.
+func lastLogin(user string, now time.Time) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(user))
+	sum := hash.Sum32()
+	when := now.Add(-time.Hour - time.Duration(sum%(72*60*60))*time.Second)
+	return fmt.Sprintf("Last login: %v from 198.51.100.%v", when.Format("Mon Jan _2 15:04:05 2006"), sum%254+1)
+}
+
+// writeMOTD writes the configured message of the day and last login line, like sshd does for interactive logins.
+func writeMOTD(context commandContext, w io.Writer) error {
+	if context.cfg.motd != nil {
+		if err := context.cfg.motd.Execute(w, motdData{hostname(context), context.user}); err != nil {
+			return err
+		}
+	}
+	if context.cfg.Shell.MOTD.LastLogin {
+		if _, err := fmt.Fprintln(w, lastLogin(context.user, context.cfg.clock.now())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+var defaultSockets = []socketConfig{
+	{Proto: "tcp", LocalAddress: "0.0.0.0:22", ForeignAddress: "0.0.0.0:*", State: "LISTEN", PID: 845, Program: "sshd: /usr/sbin/sshd"},
+	{Proto: "tcp", LocalAddress: "127.0.0.1:3306", ForeignAddress: "0.0.0.0:*", State: "LISTEN", PID: 1120, Program: "mysqld"},
+	{Proto: "tcp6", LocalAddress: ":::22", ForeignAddress: ":::*", State: "LISTEN", PID: 845, Program: "sshd: /usr/sbin/sshd"},
+	{Proto: "udp", LocalAddress: "0.0.0.0:68", ForeignAddress: "0.0.0.0:*", PID: 611, Program: "systemd-networkd"},
+}
+
+// listSockets returns the configured sockets followed by the connection of the session itself.
+func listSockets(context commandContext) []socketConfig {
+	sockets := append([]socketConfig{}, context.cfg.Shell.Sockets...)
+	localHost, _, err := net.SplitHostPort(context.LocalAddr().String())
+	if err != nil {
+		return sockets
+	}
+	return append(sockets, socketConfig{
+		Proto:          "tcp",
+		LocalAddress:   net.JoinHostPort(localHost, "22"),
+		ForeignAddress: context.RemoteAddr().String(),
+		State:          "ESTABLISHED",
+		PID:            sshdSessionPID(context.cfg.Shell.Processes),
+		Program:        fmt.Sprintf("sshd: %v", context.user),
+	})
+}
+
+// listening reports whether the socket is a server socket, which UDP sockets without a state are.
+func (socket socketConfig) listening() bool {
+	return socket.State == "LISTEN" || socket.State == ""
+}
+
+func (socket socketConfig) udp() bool {
+	return strings.HasPrefix(socket.Proto, "udp")
+}
+
+// socketFilter holds the socket selection options shared by netstat and ss.
+type socketFilter struct {
+	tcp, udp, listening, all, programs bool
+}
+
+func parseSocketFilter(args []string) socketFilter {
+	var filter socketFilter
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") {
+			continue
+		}
+		filter.tcp = filter.tcp || strings.Contains(arg, "t")
+		filter.udp = filter.udp || strings.Contains(arg, "u")
+		filter.listening = filter.listening || strings.Contains(arg, "l")
+		filter.all = filter.all || strings.Contains(arg, "a")
+		filter.programs = filter.programs || strings.Contains(arg, "p")
+	}
+	return filter
+}
+
+func (filter socketFilter) match(socket socketConfig) bool {
+	if (filter.tcp || filter.udp) && !(filter.tcp && !socket.udp() || filter.udp && socket.udp()) {
+		return false
+	}
+	return filter.all || filter.listening == socket.listening()
+}
+
+type cmdNetstat struct{}
+
+func (cmdNetstat) execute(context commandContext) (uint32, error) {
+	filter := parseSocketFilter(context.args[1:])
+	var lines []string
+	switch {
+	case filter.all:
+		lines = append(lines, "Active Internet connections (servers and established)")
+	case filter.listening:
+		lines = append(lines, "Active Internet connections (only servers)")
+	default:
+		lines = append(lines, "Active Internet connections (w/o servers)")
+	}
+	header := fmt.Sprintf("%-5s %6s %6s %-23s %-23s %-11s", "Proto", "Recv-Q", "Send-Q", "Local Address", "Foreign Address", "State")
+	if filter.programs {
+		header += " PID/Program name"
+	}
+	lines = append(lines, strings.TrimRight(header, " "))
+	for _, socket := range listSockets(context) {
+		if !filter.match(socket) {
+			continue
+		}
+		line := fmt.Sprintf("%-5s %6d %6d %-23s %-23s %-11s", socket.Proto, 0, 0, socket.LocalAddress, socket.ForeignAddress, socket.State)
+		if filter.programs {
+			program := fmt.Sprintf("%v/%v", socket.PID, socket.Program)
+			if len(program) > 19 {
+				program = program[:19]
+			}
+			line += " " + program
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
+
+// ssAddress converts a netstat style address to the bracketed IPv6 form used by ss.
+func ssAddress(address string) string {
+	i := strings.LastIndex(address, ":")
+	if i == -1 {
+		return address
+	}
+	if host := address[:i]; strings.Contains(host, ":") {
+		return "[" + host + "]" + address[i:]
+	}
+	return address
+}
+
+type cmdSs struct{}
+
+func (cmdSs) execute(context commandContext) (uint32, error) {
+	filter := parseSocketFilter(context.args[1:])
+	lines := []string{fmt.Sprintf("%-6s %-7s %-7s %-7s %22s %-22s %v", "Netid", "State", "Recv-Q", "Send-Q", "Local Address:Port", "Peer Address:Port", "Process")}
+	for _, socket := range listSockets(context) {
+		if !filter.match(socket) {
+			continue
+		}
+		netid, state, sendQ := "tcp", socket.State, 0
+		switch {
+		case socket.udp():
+			netid, state = "udp", "UNCONN"
+		case state == "LISTEN":
+			sendQ = 128
+		case state == "ESTABLISHED":
+			state = "ESTAB"
+		}
+		var process string
+		// Sockets without a configured program have no process to show, like those of other users
+		if fields := strings.Fields(socket.Program); filter.programs && len(fields) > 0 {
+			name := path.Base(strings.TrimSuffix(fields[0], ":"))
+			process = fmt.Sprintf("users:((%q,pid=%v,fd=3))", name, socket.PID)
+		}
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%-6s %-7s %-7d %-7d %22s %-22s %v", netid, state, 0, sendQ, ssAddress(socket.LocalAddress), ssAddress(socket.ForeignAddress), process), " "))
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
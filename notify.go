@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// webhookQueueSize is how many notifications can wait for delivery before new ones are dropped.
+const webhookQueueSize = 64
+
+// webhookNotifier POSTs JSON events to a webhook in the background, so slow webhooks don't hold up connections.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	queue  chan []byte
+	done   chan struct{}
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	notifier := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan []byte, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go notifier.run()
+	return notifier
+}
+
+func (notifier *webhookNotifier) run() {
+	for {
+		select {
+		case <-notifier.done:
+			return
+		case payload := <-notifier.queue:
+			if err := notifier.deliver(payload); err != nil {
+				warningLogger.Printf("Failed to deliver webhook notification: %v", err)
+			}
+		}
+	}
+}
+
+func (notifier *webhookNotifier) deliver(payload []byte) error {
+	response, err := notifier.client.Post(notifier.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response status %v", response.Status)
+	}
+	return nil
+}
+
+func (notifier *webhookNotifier) notify(payload []byte) {
+	select {
+	case notifier.queue <- payload:
+	default:
+		warningLogger.Printf("Webhook notification queue full, dropping notification")
+	}
+}
+
+// stop makes the notifier stop delivering notifications, any queued ones are dropped.
+func (notifier *webhookNotifier) stop() {
+	close(notifier.done)
+}
+
+// notifyLogin sends a successful authentication to the webhook, if one is configured.
+func (cfg *config) notifyLogin(conn ssh.ConnMetadata, entry logEntry) {
+	if cfg.notifier == nil {
+		return
+	}
+	payload, err := connContext{ConnMetadata: conn, cfg: cfg}.jsonLogEntry(entry, true)
+	if err != nil {
+		warningLogger.Printf("Failed to encode webhook notification: %v", err)
+		return
+	}
+	cfg.notifier.notify(payload)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookPasswordSuccess(t *testing.T) {
+	payloads := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request=%v %v, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read request body: %v", err)
+		}
+		payloads <- body
+	}))
+	defer server.Close()
+	cfg := &config{validUser: "root", validPass: "hunter2"}
+	cfg.Auth.PasswordAuth.Enabled = true
+	cfg.Auth.PasswordAuth.Accepted = true
+	cfg.notifier = newWebhookNotifier(server.URL)
+	defer cfg.notifier.stop()
+	setupLogBuffer(t, cfg)
+	callback := cfg.getPasswordCallback()
+	if _, err := callback(mockConnContext{}, []byte("wrong")); err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+	if _, err := callback(mockConnContext{}, []byte("hunter2")); err != nil {
+		t.Errorf("err=%v, want nil", err)
+	}
+	select {
+	case body := <-payloads:
+		var payload struct {
+			Time      string `json:"time"`
+			Source    string `json:"source"`
+			EventType string `json:"event_type"`
+			Event     struct {
+				User     string `json:"user"`
+				Accepted bool   `json:"accepted"`
+				Password string `json:"password"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("Failed to parse payload %q: %v", body, err)
+		}
+		if payload.Time == "" || payload.Source != "127.0.0.1:1234" || payload.EventType != "password_auth" ||
+			payload.Event.User != "root" || !payload.Event.Accepted || payload.Event.Password != "hunter2" {
+			t.Errorf("payload=%s, want the accepted password", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the webhook notification")
+	}
+	select {
+	case body := <-payloads:
+		t.Errorf("payload=%s, want only the accepted password notified", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// oneShotGuard lets a single connection authenticate successfully, tripping once it has.
+type oneShotGuard struct {
+	mutex     sync.Mutex
+	sessionID []byte
+}
+
+// accept reports whether conn may authenticate, tripping the guard if it's the first one to.
+// A nil guard accepts every connection.
+func (guard *oneShotGuard) accept(conn ssh.ConnMetadata, cfg *config) bool {
+	if guard == nil {
+		return true
+	}
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	if guard.sessionID != nil {
+		return bytes.Equal(guard.sessionID, conn.SessionID())
+	}
+	guard.sessionID = conn.SessionID()
+	connContext{ConnMetadata: conn, cfg: cfg}.logEvent(oneShotTrippedLog{User: conn.User()})
+	return true
+}
+
+// permits reports whether conn may authenticate without tripping the guard,
+// as public keys are queried before their signature is checked.
+func (guard *oneShotGuard) permits(conn ssh.ConnMetadata) bool {
+	if guard == nil {
+		return true
+	}
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	return guard.sessionID == nil || bytes.Equal(guard.sessionID, conn.SessionID())
+}
+
+func (guard *oneShotGuard) tripped() bool {
+	if guard == nil {
+		return false
+	}
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+	return guard.sessionID != nil
+}
+
+// oneShotListener closes new connections before the SSH handshake once the one-shot guard has tripped.
+type oneShotListener struct {
+	net.Listener
+	cfg *config
+}
+
+func newOneShotListener(listener net.Listener, cfg *config) net.Listener {
+	return &oneShotListener{listener, cfg}
+}
+
+func (listener *oneShotListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !listener.cfg.oneShot.tripped() {
+			return conn, nil
+		}
+		connContext{ConnMetadata: rawConnMetadata{conn}, cfg: listener.cfg}.logEvent(oneShotRejectLog{})
+		conn.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOneShot(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.OneShot = true
+	setupTestSSHConfig(t, cfg)
+	logs := setupLogBuffer(t, cfg)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newOneShotListener(tcpListener, cfg)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				serverConn, newChannels, requests, err := ssh.NewServerConn(conn, cfg.sshConfig)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer serverConn.Close()
+				go ssh.DiscardRequests(requests)
+				for newChannel := range newChannels {
+					newChannel.Reject(ssh.Prohibited, "")
+				}
+			}()
+		}
+	}()
+	clientConfig := &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Failed to connect the first time: %v", err)
+	}
+	defer client.Close()
+	if !strings.Contains(logs.String(), `] honeypot tripped by user "root", rejecting further connections`) {
+		t.Errorf("logs=%v, want the honeypot tripped", logs.String())
+	}
+
+	if client, err := ssh.Dial("tcp", listener.Addr().String(), clientConfig); err == nil {
+		client.Close()
+		t.Errorf("Second connection succeeded, want it refused")
+	}
+	if !strings.HasSuffix(logs.String(), "] connection rejected after the honeypot tripped\n") {
+		t.Errorf("logs=%v, want the second connection rejected", logs.String())
+	}
+}
+
+func TestOneShotRejectsOtherAuthentications(t *testing.T) {
+	cfg := &config{}
+	guard := &oneShotGuard{}
+	if !guard.accept(mockConnContext{}, cfg) {
+		t.Errorf("First authentication rejected, want it accepted")
+	}
+	if !guard.accept(mockConnContext{}, cfg) {
+		t.Errorf("Authentication of the same connection rejected, want it accepted")
+	}
+	if guard.accept(otherConnContext{}, cfg) {
+		t.Errorf("Authentication of another connection accepted, want it rejected")
+	}
+}
+
+func TestOneShotPublicKeyQuery(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.PublicKeyAuth.Enabled = true
+	cfg.Auth.PublicKeyAuth.Accepted = true
+	cfg.oneShot = &oneShotGuard{}
+	logs := setupLogBuffer(t, cfg)
+	callback := cfg.getPublicKeyCallback()
+	if _, err := callback(mockConnContext{}, mockPublicKey{}); err != nil {
+		t.Errorf("err=%v, want the key accepted", err)
+	}
+	if cfg.oneShot.tripped() {
+		t.Errorf("guard tripped by a public key query, want it tripped after the signature is verified")
+	}
+	cfg.getAuthLogCallback()(mockConnContext{}, "publickey", nil)
+	if !cfg.oneShot.tripped() || !strings.Contains(logs.String(), `] honeypot tripped by user "root"`) {
+		t.Errorf("logs=%v, want the guard tripped by the successful authentication", logs.String())
+	}
+	if _, err := callback(otherConnContext{}, mockPublicKey{}); err == nil {
+		t.Errorf("err=nil, want the key of another connection rejected")
+	}
+}
+
+type otherConnContext struct {
+	mockConnContext
+}
+
+func (otherConnContext) SessionID() []byte {
+	return []byte("other")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// fakePackage makes up a stable version and size for a package name.
+func fakePackage(name string) (version string, sizeKB uint32) {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	sum := hash.Sum32()
+	return fmt.Sprintf("%v.%v.%v-%vubuntu1", sum%9+1, sum/9%20, sum/180%10, sum/1800%4+1), sum/7200%4000 + 40
+}
+
+// packageArgs splits package manager arguments into the operation and the package names, ignoring options.
+func packageArgs(args []string) (string, []string) {
+	var operation string
+	var packages []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-"):
+		case operation == "":
+			operation = arg
+		default:
+			packages = append(packages, arg)
+		}
+	}
+	return operation, packages
+}
+
+// logPackages logs a package manager operation, including ones denied because the user isn't root.
+func logPackages(context commandContext, operation string, packages []string) {
+	context.logEvent(packageLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Manager:    context.args[0],
+		Operation:  operation,
+		Packages:   packages,
+		Denied:     context.user != "root",
+	})
+}
+
+type cmdApt struct{}
+
+func (cmdApt) execute(context commandContext) (uint32, error) {
+	operation, packages := packageArgs(context.args[1:])
+	switch operation {
+	case "":
+		_, err := fmt.Fprintf(context.stderr, "%v: missing command\nUsage: %v [options] command\n", context.args[0], context.args[0])
+		return 1, err
+	case "update", "install", "remove", "purge", "upgrade":
+	default:
+		_, err := fmt.Fprintf(context.stderr, "E: Invalid operation %v\n", operation)
+		return 100, err
+	}
+	logPackages(context, operation, packages)
+	if context.user != "root" {
+		_, err := fmt.Fprintln(context.stderr, "E: Could not open lock file /var/lib/dpkg/lock-frontend - open (13: Permission denied)\nE: Unable to acquire the dpkg frontend lock (/var/lib/dpkg/lock-frontend), are you root?")
+		return 100, err
+	}
+	var lines []string
+	if operation == "update" {
+		lines = append(lines,
+			"Hit:1 http://archive.ubuntu.com/ubuntu jammy InRelease",
+			"Get:2 http://archive.ubuntu.com/ubuntu jammy-updates InRelease [119 kB]",
+			"Get:3 http://security.ubuntu.com/ubuntu jammy-security InRelease [110 kB]",
+			"Fetched 229 kB in 1s (254 kB/s)",
+			"Reading package lists... Done")
+		_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+		return 0, err
+	}
+	lines = append(lines, "Reading package lists... Done", "Building dependency tree... Done", "Reading state information... Done")
+	if len(packages) == 0 {
+		lines = append(lines, "0 upgraded, 0 newly installed, 0 to remove and 0 not upgraded.")
+		_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+		return 0, err
+	}
+	var totalKB uint32
+	for _, name := range packages {
+		_, sizeKB := fakePackage(name)
+		totalKB += sizeKB
+	}
+	if operation == "remove" || operation == "purge" {
+		lines = append(lines,
+			"The following packages will be REMOVED:",
+			"  "+strings.Join(packages, " "),
+			fmt.Sprintf("0 upgraded, 0 newly installed, %v to remove and 0 not upgraded.", len(packages)),
+			fmt.Sprintf("After this operation, %v kB disk space will be freed.", 4*totalKB),
+			"(Reading database ... 64183 files and directories currently installed.)")
+		for _, name := range packages {
+			version, _ := fakePackage(name)
+			lines = append(lines, fmt.Sprintf("Removing %v (%v) ...", name, version))
+		}
+		_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+		return 0, err
+	}
+	lines = append(lines,
+		"The following NEW packages will be installed:",
+		"  "+strings.Join(packages, " "),
+		fmt.Sprintf("0 upgraded, %v newly installed, 0 to remove and 0 not upgraded.", len(packages)),
+		fmt.Sprintf("Need to get %v kB of archives.", totalKB),
+		fmt.Sprintf("After this operation, %v kB of additional disk space will be used.", 4*totalKB))
+	for i, name := range packages {
+		version, sizeKB := fakePackage(name)
+		lines = append(lines, fmt.Sprintf("Get:%v http://archive.ubuntu.com/ubuntu jammy/universe amd64 %v amd64 %v [%v kB]", i+1, name, version, sizeKB))
+	}
+	lines = append(lines, fmt.Sprintf("Fetched %v kB in 1s (%v kB/s)", totalKB, totalKB))
+	for i, name := range packages {
+		version, _ := fakePackage(name)
+		lines = append(lines,
+			fmt.Sprintf("Selecting previously unselected package %v.", name),
+			fmt.Sprintf("(Reading database ... %v files and directories currently installed.)", 64183+i*37),
+			fmt.Sprintf("Preparing to unpack .../%v_%v_amd64.deb ...", name, version),
+			fmt.Sprintf("Unpacking %v (%v) ...", name, version))
+	}
+	for _, name := range packages {
+		version, _ := fakePackage(name)
+		lines = append(lines, fmt.Sprintf("Setting up %v (%v) ...", name, version))
+	}
+	lines = append(lines, "Processing triggers for man-db (2.10.2-1) ...")
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
+
+type cmdYum struct{}
+
+func (cmdYum) execute(context commandContext) (uint32, error) {
+	operation, packages := packageArgs(context.args[1:])
+	switch operation {
+	case "":
+		_, err := fmt.Fprintln(context.stderr, "You need to give some command")
+		return 1, err
+	case "update", "install", "remove", "erase":
+	default:
+		_, err := fmt.Fprintf(context.stderr, "No such command: %v. Please use /usr/bin/%v --help\n", operation, context.args[0])
+		return 1, err
+	}
+	logPackages(context, operation, packages)
+	if context.user != "root" {
+		_, err := fmt.Fprintln(context.stderr, "Loaded plugins: fastestmirror\nYou need to be root to perform this command.")
+		return 1, err
+	}
+	lines := []string{"Loaded plugins: fastestmirror", "Loading mirror speeds from cached hostfile"}
+	if operation == "update" || len(packages) == 0 {
+		lines = append(lines, "No packages marked for "+operation)
+		_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+		return 0, err
+	}
+	action, done := "installed", "Installed:"
+	if operation != "install" {
+		action, done = "erased", "Removed:"
+	}
+	lines = append(lines, "Resolving Dependencies", "--> Running transaction check")
+	var summary []string
+	for _, name := range packages {
+		version, _ := fakePackage(name)
+		nevra := fmt.Sprintf("%v.x86_64 0:%v.el7", name, strings.TrimSuffix(version, "ubuntu1"))
+		lines = append(lines, fmt.Sprintf("---> Package %v will be %v", nevra, action))
+		summary = append(summary, nevra)
+	}
+	lines = append(lines, "--> Finished Dependency Resolution", "Running transaction check", "Running transaction test",
+		"Transaction test succeeded", "Running transaction", "", done, "  "+strings.Join(summary, " "), "", "Complete!")
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+func init() {
+	registerCommand("more", cmdCat{name: "more", pager: true})
+	registerCommand("less", cmdCat{name: "less", pager: true})
+	registerCommand("view", cmdCat{name: "view", pager: true})
+}
+
+// writePaged writes content a screen at a time if there's a pty,
+// prompting like more does before each following screen and stopping if q is entered.
+func writePaged(context commandContext, content string) error {
+	written := 0
+	for {
+		// The height is read for every screen, as the terminal may have been resized while paging.
+		height := atomic.LoadUint32(context.height)
+		if !context.pty || height < 2 {
+			_, err := fmt.Fprint(context.stdout, content[written:])
+			return err
+		}
+		end := written
+		for lines := 0; lines < int(height)-1 && end < len(content); lines++ {
+			if i := strings.IndexByte(content[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(content)
+			}
+		}
+		if _, err := fmt.Fprint(context.stdout, content[written:end]); err != nil {
+			return err
+		}
+		written = end
+		if written == len(content) {
+			return nil
+		}
+		if _, err := fmt.Fprintf(context.stdout, "--More--(%v%%)", written*100/len(content)); err != nil {
+			return err
+		}
+		line, err := context.stdin.ReadLine()
+		if endOfInput(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "q" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPings bounds the number of replies of a ping, with or without a count.
+	maxPings = 100
+	// minPingInterval is the smallest interval ping allows users, maxPingInterval keeps sessions from lingering.
+	minPingInterval = 200 * time.Millisecond
+	maxPingInterval = time.Minute
+)
+
+type cmdPing struct{}
+
+func (cmdPing) execute(context commandContext) (uint32, error) {
+	count := 0
+	interval := time.Second
+	quiet := false
+	var target string
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case (arg == "-c" || arg == "-i" || arg == "-W" || arg == "-w" || arg == "-s" || arg == "-t") && i+1 < len(args):
+			i++
+			value := args[i]
+			switch arg {
+			case "-c":
+				n, err := strconv.Atoi(value)
+				if err != nil || n <= 0 {
+					_, err := fmt.Fprintf(context.stderr, "ping: invalid argument: '%v': out of range: 1 <= value <= 9223372036854775807\n", value)
+					return 1, err
+				}
+				count = n
+			case "-i":
+				seconds, err := strconv.ParseFloat(value, 64)
+				if err != nil || seconds < 0 {
+					_, err := fmt.Fprintf(context.stderr, "ping: bad timing interval: %v\n", value)
+					return 1, err
+				}
+				interval = time.Duration(math.Min(seconds, maxPingInterval.Seconds()) * float64(time.Second))
+				if interval < minPingInterval {
+					interval = minPingInterval
+				}
+			}
+		case arg == "-q":
+			quiet = true
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+		default:
+			target = arg
+		}
+	}
+	if target == "" {
+		_, err := fmt.Fprintln(context.stderr, "ping: usage error: Destination address required")
+		return 1, err
+	}
+	context.logEvent(pingLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Target:     target,
+		Count:      count,
+	})
+	address := resolveHost(target)
+	hash := fnv.New32a()
+	hash.Write([]byte(address))
+	baseLatency := float64(hash.Sum32()%6000)/100 + 1
+	ttl := 64 - hash.Sum32()%20
+	if hash.Sum32()%2 == 0 {
+		ttl += 64
+	}
+	from := address
+	if address != target {
+		from = fmt.Sprintf("%v (%v)", target, address)
+	}
+	if _, err := fmt.Fprintf(context.stdout, "PING %v (%v) 56(84) bytes of data.\n", target, address); err != nil {
+		return 1, err
+	}
+	if count == 0 && context.state.input == nil {
+		context.state.input = &idleReader{readLiner: context.stdin, results: make(chan readLineResult, 1)}
+	}
+	if count > maxPings {
+		count = maxPings
+	}
+	transmitted := 0
+	min, max, sum, squares := math.Inf(1), 0.0, 0.0, 0.0
+	for seq := 1; count == 0 && seq <= maxPings || seq <= count; seq++ {
+		latency := baseLatency * (1 + rand.Float64()*0.1)
+		transmitted++
+		min = math.Min(min, latency)
+		max = math.Max(max, latency)
+		sum += latency
+		squares += latency * latency
+		if !quiet {
+			if _, err := fmt.Fprintf(context.stdout, "64 bytes from %v: icmp_seq=%v ttl=%v time=%.*f ms\n", from, seq, ttl, latencyPrecision(latency), latency); err != nil {
+				return 1, err
+			}
+		}
+		if seq == count {
+			break
+		}
+		// Without a count, any input or the end of it interrupts ping like ^C would.
+		if count == 0 {
+			if _, err := context.state.input.readLineTimeout(interval); err != errIdleTimeout {
+				break
+			}
+		} else {
+			select {
+			case <-time.After(interval):
+			case <-context.done:
+				// The session is gone, so the status is only what a hung up process would report.
+				return 129, nil
+			}
+		}
+	}
+	avg := sum / float64(transmitted)
+	mdev := math.Sqrt(math.Max(squares/float64(transmitted)-avg*avg, 0))
+	elapsed := time.Duration(transmitted-1)*interval + time.Duration(max*float64(time.Millisecond))
+	_, err := fmt.Fprintf(context.stdout, "\n--- %v ping statistics ---\n%v packets transmitted, %v received, 0%% packet loss, time %vms\nrtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+		target, transmitted, transmitted, elapsed.Milliseconds(), min, avg, max, mdev)
+	return 0, err
+}
+
+// latencyPrecision is the number of decimals ping shows a latency in milliseconds with.
+func latencyPrecision(latency float64) int {
+	switch {
+	case latency < 10:
+		return 2
+	case latency < 100:
+		return 1
+	default:
+		return 0
+	}
+}
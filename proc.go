@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const cpuFlags = "fpu vme de pse tsc msr pae mce cx8 apic sep mtrr pge mca cmov pat pse36 clflush mmx fxsr sse sse2 ss ht syscall nx pdpe1gb rdtscp lm constant_tsc rep_good nopl xtopology nonstop_tsc cpuid tsc_known_freq pni pclmulqdq ssse3 fma cx16 pcid sse4_1 sse4_2 x2apic movbe popcnt tsc_deadline_timer aes xsave avx f16c rdrand hypervisor lahf_lm abm 3dnowprefetch invpcid_single pti fsgsbase tsc_adjust bmi1 avx2 smep bmi2 erms invpcid mpx avx512f avx512dq rdseed adx smap clflushopt clwb avx512cd avx512bw avx512vl xsaveopt xsavec xgetbv1 xsaves ida arat pku ospke"
+
+func cpuinfo(cfg hardwareConfig) string {
+	var blocks []string
+	for i := 0; i < cfg.CPUCores; i++ {
+		blocks = append(blocks, fmt.Sprintf(`processor	: %[1]v
+vendor_id	: GenuineIntel
+cpu family	: 6
+model		: 85
+model name	: %[2]v
+stepping	: 7
+microcode	: 0x5003604
+cpu MHz		: 2499.998
+cache size	: 36608 KB
+physical id	: 0
+siblings	: %[3]v
+core id		: %[1]v
+cpu cores	: %[3]v
+apicid		: %[1]v
+initial apicid	: %[1]v
+fpu		: yes
+fpu_exception	: yes
+cpuid level	: 13
+wp		: yes
+flags		: %[4]v
+bugs		: cpu_meltdown spectre_v1 spectre_v2 spec_store_bypass l1tf mds swapgs itlb_multihit
+bogomips	: 4999.99
+clflush size	: 64
+cache_alignment	: 64
+address sizes	: 46 bits physical, 48 bits virtual
+power management:
+`, i, cfg.CPUModel, cfg.CPUCores, cpuFlags))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// memoryUsage is how the configured memory is used, in KiB, as reported by /proc/meminfo and free.
+type memoryUsage struct {
+	total, free, buffers, cached, shared int
+}
+
+func newMemoryUsage(cfg hardwareConfig) memoryUsage {
+	// The kernel reserves some of the installed memory.
+	total := cfg.MemoryMB * 1024 * 975 / 1000
+	return memoryUsage{total: total, free: total * 3 / 5, buffers: total / 40, cached: total / 5, shared: 1024}
+}
+
+func (usage memoryUsage) available() int {
+	return usage.free + usage.buffers + usage.cached
+}
+
+func meminfo(cfg hardwareConfig) string {
+	usage := newMemoryUsage(cfg)
+	total, free, buffers, cached := usage.total, usage.free, usage.buffers, usage.cached
+	return fmt.Sprintf(`MemTotal:       %8d kB
+MemFree:        %8d kB
+MemAvailable:   %8d kB
+Buffers:        %8d kB
+Cached:         %8d kB
+SwapCached:            0 kB
+Active:         %8d kB
+Inactive:       %8d kB
+SwapTotal:             0 kB
+SwapFree:              0 kB
+Dirty:                 8 kB
+Writeback:             0 kB
+AnonPages:      %8d kB
+Mapped:         %8d kB
+Shmem:          %8d kB
+Slab:           %8d kB
+PageTables:         4512 kB
+CommitLimit:    %8d kB
+Committed_AS:   %8d kB
+VmallocTotal:   34359738367 kB
+HugePages_Total:       0
+HugePages_Free:        0
+Hugepagesize:       2048 kB
+`, total, free, usage.available(), buffers, cached, total/4, total/10, total/8, total/20, usage.shared, total/25, total/2, total/3)
+}
+
+// addProcFiles seeds the hardware description files of /proc, if any hardware is configured.
+func (fs *FileSystemType) addProcFiles(cfg hardwareConfig) {
+	if cfg.CPUCores > 0 {
+		fs.addFile("/proc/cpuinfo", cpuinfo(cfg)).Mode = 0444
+	}
+	if cfg.MemoryMB > 0 {
+		fs.addFile("/proc/meminfo", meminfo(cfg)).Mode = 0444
+	}
+}
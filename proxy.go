@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+const proxyDialTimeout = 5 * time.Second
+
+// proxyServer relays a direct-tcpip channel to a real backend, so every byte exchanged with it is logged.
+type proxyServer struct {
+	address string
+	// maxBytes is the number of bytes relayed in both directions after which the channel is closed, zero meaning unlimited.
+	maxBytes int64
+}
+
+// readChunks sends what's read from reader on chunks until it fails or stop is closed.
+func readChunks(reader io.Reader, chunks chan<- []byte, stop <-chan struct{}) {
+	defer close(chunks)
+	for {
+		buffer := make([]byte, 32*1024)
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			select {
+			case chunks <- buffer[:n]:
+			case <-stop:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (server proxyServer) serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext) {
+	backend, err := net.DialTimeout("tcp", server.address, proxyDialTimeout)
+	if err != nil {
+		warningLogger.Printf("Failed to connect to proxy backend %v: %v", server.address, err)
+		return
+	}
+	defer backend.Close()
+
+	// The client's reads only end once the channel is closed, which happens after serve returns.
+	stop := make(chan struct{})
+	defer close(stop)
+	fromClient := make(chan []byte)
+	fromBackend := make(chan []byte)
+	go readChunks(readWriter, fromClient, stop)
+	go readChunks(backend, fromBackend, stop)
+
+	var relayed int64
+	// limit truncates data to what's left of the budget, reporting whether any budget is left after it.
+	limit := func(data []byte) ([]byte, bool) {
+		if server.maxBytes <= 0 {
+			return data, true
+		}
+		if remaining := server.maxBytes - relayed; int64(len(data)) >= remaining {
+			relayed = server.maxBytes
+			return data[:remaining], false
+		}
+		relayed += int64(len(data))
+		return data, true
+	}
+	for {
+		select {
+		case data, ok := <-fromClient:
+			if !ok {
+				fromClient = nil
+				if tcpConn, ok := backend.(*net.TCPConn); ok {
+					if err := tcpConn.CloseWrite(); err != nil {
+						warningLogger.Printf("Error sending EOF to proxy backend: %v", err)
+						return
+					}
+				}
+				continue
+			}
+			data, more := limit(data)
+			if _, err := backend.Write(data); err != nil {
+				return
+			}
+			input <- string(data)
+			if !more {
+				return
+			}
+		case data, ok := <-fromBackend:
+			if !ok {
+				return
+			}
+			data, more := limit(data)
+			if _, err := readWriter.Write(data); err != nil {
+				return
+			}
+			output <- string(data)
+			if !more {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout is how long a client has to send its PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// parseProxyHeader reads a PROXY protocol v1 or v2 header, returning the source and destination addresses it carries.
+// They're nil if the header doesn't carry any, such as v1 UNKNOWN or v2 LOCAL headers sent by health checks.
+func parseProxyHeader(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	if signature, err := reader.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(signature, proxyV2Signature) {
+		return parseProxyV2Header(reader)
+	}
+	return parseProxyV1Header(reader)
+}
+
+func parseProxyV1Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	// The longest v1 header is 107 bytes including the CRLF
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == 107 {
+			return nil, nil, errors.New("PROXY protocol v1 header too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, errors.New("missing PROXY protocol header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	source, err := parseProxyV1Address(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := parseProxyV1Address(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, destination, nil
+}
+
+func parseProxyV1Address(host, port string) (net.Addr, error) {
+	ip := net.ParseIP(host)
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 address %v:%v", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(portNumber)}, nil
+}
+
+func parseProxyV2Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %v", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, err
+	}
+	command, family := header[12]&0xf, header[13]>>4
+	if command == 0 {
+		return nil, nil, nil
+	}
+	if command != 1 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol v2 command %v", command)
+	}
+	var ipLength int
+	switch family {
+	case 1:
+		ipLength = net.IPv4len
+	case 2:
+		ipLength = net.IPv6len
+	default:
+		// Unix sockets and unspecified families don't carry IP addresses
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLength+4 {
+		return nil, nil, errors.New("truncated PROXY protocol v2 addresses")
+	}
+	source := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLength:])),
+	}
+	destination := &net.TCPAddr{
+		IP:   net.IP(payload[ipLength : 2*ipLength]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLength+2:])),
+	}
+	return source, destination, nil
+}
+
+// proxiedConn reports the source address from the PROXY protocol header instead of that of the load balancer.
+// The local address is still the one the connection was accepted on, so that it names the listener.
+type proxiedConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (conn *proxiedConn) Read(p []byte) (int, error) {
+	return conn.reader.Read(p)
+}
+
+func (conn *proxiedConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// proxyProtocolListener parses the PROXY protocol header of connections before the SSH handshake,
+// closing those without a valid one.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func newProxyProtocolListener(listener net.Listener) net.Listener {
+	return &proxyProtocolListener{listener}
+}
+
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+			warningLogger.Printf("Failed to set PROXY protocol header deadline: %v", err)
+		}
+		reader := bufio.NewReader(conn)
+		source, _, err := parseProxyHeader(reader)
+		if err != nil {
+			warningLogger.Printf("Failed to read PROXY protocol header from %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		if err := conn.SetReadDeadline(time.Time{}); err != nil {
+			warningLogger.Printf("Failed to clear PROXY protocol header deadline: %v", err)
+		}
+		proxied := &proxiedConn{Conn: conn, reader: reader, remoteAddr: conn.RemoteAddr()}
+		if source != nil {
+			proxied.remoteAddr = source
+		}
+		return proxied, nil
+	}
+}
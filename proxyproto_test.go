@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jaksi/sshutils"
+	"golang.org/x/crypto/ssh"
+)
+
+// proxiedConnectionLogs connects through a PROXY protocol listener after sending header,
+// returning the logs of the connection and the address of the listener.
+func proxiedConnectionLogs(t *testing.T, header string) (string, string) {
+	t.Helper()
+	cfg := &config{}
+	setupTestSSHConfig(t, cfg)
+	logs := setupLogBuffer(t, cfg)
+	listener, err := sshutils.Listen("127.0.0.1:0", cfg.sshConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Listener = newProxyProtocolListener(listener.Listener)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		handleConnection(conn, cfg)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte(header)); err != nil {
+		t.Fatal(err)
+	}
+	sshConn, _, requests, err := ssh.NewClientConn(conn, listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	go ssh.DiscardRequests(requests)
+	sshConn.Close()
+	<-done
+	return logs.String(), listener.Addr().String()
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	logs, listener := proxiedConnectionLogs(t, "PROXY TCP4 203.0.113.7 192.0.2.1 51234 22\r\n")
+	if !strings.Contains(logs, `[203.0.113.7:51234] connection with client version "SSH-2.0-Go" established on `+listener+"\n") {
+		t.Errorf("logs=%v, want the source from the PROXY protocol header and the listener", logs)
+	}
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	header := string(proxyV2Signature) + "\x21\x21\x00\x24" +
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x07" +
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\xc8\x22\x00\x16"
+	logs, listener := proxiedConnectionLogs(t, header)
+	if !strings.Contains(logs, `[[2001:db8::7]:51234] connection with client version "SSH-2.0-Go" established on `+listener+"\n") {
+		t.Errorf("logs=%v, want the source from the PROXY protocol header and the listener", logs)
+	}
+}
+
+func TestProxyProtocolInvalid(t *testing.T) {
+	for _, header := range []string{
+		"SSH-2.0-OpenSSH_9.6\r\n",
+		"PROXY TCP4 203.0.113.7\r\n",
+		string(proxyV2Signature) + "\x31\x11\x00\x00",
+	} {
+		if _, _, err := parseProxyHeader(bufio.NewReader(strings.NewReader(header))); err == nil {
+			t.Errorf("header=%q: err=nil, want an error", header)
+		}
+	}
+	for _, header := range []string{"PROXY UNKNOWN\r\n", string(proxyV2Signature) + "\x20\x00\x00\x00"} {
+		source, _, err := parseProxyHeader(bufio.NewReader(strings.NewReader(header)))
+		if err != nil || source != nil {
+			t.Errorf("header=%q: source=%v, err=%v, want no address", header, source, err)
+		}
+	}
+}
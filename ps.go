@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultProcesses = []processConfig{
+	{PID: 1, User: "root", Command: "/sbin/init"},
+	{PID: 2, User: "root", Command: "[kthreadd]"},
+	{PID: 412, User: "root", Command: "/lib/systemd/systemd-journald"},
+	{PID: 447, User: "root", Command: "/lib/systemd/systemd-udevd"},
+	{PID: 611, User: "systemd-network", Command: "/lib/systemd/systemd-networkd"},
+	{PID: 742, User: "root", Command: "/usr/sbin/cron -f"},
+	{PID: 751, User: "messagebus", Command: "/usr/bin/dbus-daemon --system --address=systemd: --nofork --nopidfile --systemd-activation --syslog-only"},
+	{PID: 803, User: "syslog", Command: "/usr/sbin/rsyslogd -n -iNONE"},
+	{PID: 845, User: "root", Command: "sshd: /usr/sbin/sshd -D [listener] 0 of 10-100 startups"},
+	{PID: 1120, User: "mysql", Command: "/usr/sbin/mysqld"},
+}
+
+// bootTime is how long the fake system has been up when sshesame starts, the configured processes being started at boot.
+const bootTime = 73 * time.Hour
+
+// formatUptime formats how long the system has been up the way uptime does, such as "3 days,  1:00".
+func formatUptime(uptime time.Duration) string {
+	days := int(uptime.Hours()) / 24
+	clock := fmt.Sprintf("%2d:%02d", int(uptime.Hours())%24, int(uptime.Minutes())%60)
+	switch days {
+	case 0:
+		return clock
+	case 1:
+		return "1 day, " + clock
+	default:
+		return fmt.Sprintf("%v days, %v", days, clock)
+	}
+}
+
+type process struct {
+	pid, ppid int
+	user      string
+	tty       string
+	stat      string
+	start     time.Time
+	command   string
+	// session processes are listed even without -e, as they share the terminal of ps.
+	session bool
+}
+
+// name is the executable name, as shown in the CMD column of the short format.
+func (p process) name() string {
+	fields := strings.Fields(p.command)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(path.Base(strings.TrimSuffix(fields[0], ":")), "[]-")
+}
+
+// cpuTime is a stable, made up amount of CPU time used by the process.
+func (p process) cpuTime() time.Duration {
+	if p.session {
+		return 0
+	}
+	return time.Duration(p.pid%17) * time.Second
+}
+
+func (p process) rss() int {
+	if strings.HasPrefix(p.command, "[") {
+		return 0
+	}
+	return 2048 + p.pid*37%16384
+}
+
+func (p process) vsz() int {
+	if strings.HasPrefix(p.command, "[") {
+		return 0
+	}
+	return 8 * p.rss()
+}
+
+// psUser truncates user names like procps does.
+func psUser(user string) string {
+	if len(user) > 8 {
+		return user[:7] + "+"
+	}
+	return user
+}
+
+func psStart(start, now time.Time) string {
+	if now.Sub(start) < 24*time.Hour {
+		return start.Format("15:04")
+	}
+	return start.Format("Jan02")
+}
+
+// sshdSessionPID is the PID of the sshd process handling the session, started after all configured processes.
+func sshdSessionPID(processes []processConfig) int {
+	lastPID := 0
+	for _, p := range processes {
+		if p.PID > lastPID {
+			lastPID = p.PID
+		}
+	}
+	return lastPID + 1103
+}
+
+func listProcesses(context commandContext) []process {
+	now := context.cfg.clock.now()
+	var processes []process
+	for _, p := range context.cfg.Shell.Processes {
+		ppid := 1
+		switch {
+		case p.PID <= 2:
+			ppid = 0
+		case strings.HasPrefix(p.Command, "["):
+			ppid = 2
+		}
+		stat := "Ss"
+		if strings.HasPrefix(p.Command, "[") {
+			stat = "S"
+		}
+		processes = append(processes, process{p.PID, ppid, p.User, "?", stat, context.cfg.clock.boot(), p.Command, false})
+	}
+	tty := "?"
+	if context.pty {
+		tty = "pts/0"
+	}
+	sshdPID := sshdSessionPID(context.cfg.Shell.Processes)
+	return append(processes,
+		process{sshdPID, 845, "root", "?", "Ss", now.Add(-time.Minute), fmt.Sprintf("sshd: %v@%v", context.user, tty), false},
+		process{sshdPID + 8, sshdPID, context.user, tty, "Ss", now.Add(-time.Minute), "-bash", true},
+		process{sshdPID + 9 + len(context.state.history), sshdPID + 8, context.user, tty, "R+", now, strings.Join(context.args, " "), true},
+	)
+}
+
+type cmdPs struct{}
+
+func (cmdPs) execute(context commandContext) (uint32, error) {
+	bsd, all, full := false, false, false
+	for _, arg := range context.args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			all = all || strings.ContainsAny(arg, "eA")
+			full = full || strings.ContainsAny(arg, "fF")
+		} else {
+			bsd = bsd || strings.ContainsAny(arg, "aux")
+		}
+	}
+	now := context.cfg.clock.now()
+	processes := listProcesses(context)
+	var lines []string
+	switch {
+	case bsd:
+		lines = append(lines, fmt.Sprintf("%-8s %7s %4s %4s %6s %5s %-8s %-4s %5s %6s %v", "USER", "PID", "%CPU", "%MEM", "VSZ", "RSS", "TTY", "STAT", "START", "TIME", "COMMAND"))
+		for _, p := range processes {
+			cpuTime := p.cpuTime()
+			lines = append(lines, fmt.Sprintf("%-8s %7d %4.1f %4.1f %6d %5d %-8s %-4s %5s %6s %v",
+				psUser(p.user), p.pid, 0.0, float64(p.rss())/40960, p.vsz(), p.rss(), p.tty, p.stat, psStart(p.start, now),
+				fmt.Sprintf("%d:%02d", int(cpuTime.Minutes()), int(cpuTime.Seconds())%60), p.command))
+		}
+	case full:
+		lines = append(lines, fmt.Sprintf("%-8s %7s %7s %2s %5s %-8s %8s %v", "UID", "PID", "PPID", "C", "STIME", "TTY", "TIME", "CMD"))
+		for _, p := range processes {
+			if !all && !p.session {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%-8s %7d %7d %2d %5s %-8s %8s %v",
+				psUser(p.user), p.pid, p.ppid, 0, psStart(p.start, now), p.tty, psTime(p.cpuTime()), p.command))
+		}
+	default:
+		lines = append(lines, fmt.Sprintf("%7s %-8s %8s %v", "PID", "TTY", "TIME", "CMD"))
+		for _, p := range processes {
+			if !all && !p.session {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%7d %-8s %8s %v", p.pid, p.tty, psTime(p.cpuTime()), p.name()))
+		}
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(lines, "\n"))
+	return 0, err
+}
+
+func psTime(cpuTime time.Duration) string {
+	seconds := int(cpuTime.Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// signals are the numbers of the signals kill accepts by name.
+var signals = map[string]int{
+	"HUP": 1, "INT": 2, "QUIT": 3, "KILL": 9, "USR1": 10, "USR2": 12, "TERM": 15, "CONT": 18, "STOP": 19,
+}
+
+// parseSignal parses a signal given by number or by name, with or without the SIG prefix.
+func parseSignal(spec string) (int, bool) {
+	if number, err := strconv.Atoi(spec); err == nil {
+		return number, number >= 0 && number <= 64
+	}
+	number, ok := signals[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	return number, ok
+}
+
+type cmdKill struct{}
+
+func (cmdKill) execute(context commandContext) (uint32, error) {
+	signal := "15"
+	var pids []string
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case (arg == "-s" || arg == "-n") && i+1 < len(args):
+			i++
+			signal = args[i]
+		case strings.HasPrefix(arg, "-") && len(pids) == 0 && len(arg) > 1:
+			signal = arg[1:]
+		default:
+			pids = append(pids, arg)
+		}
+	}
+	if len(pids) == 0 {
+		_, err := fmt.Fprintln(context.stderr, "kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+		return 2, err
+	}
+	if _, ok := parseSignal(signal); !ok {
+		_, err := fmt.Fprintf(context.stderr, "kill: unknown signal: %v\n", signal)
+		return 1, err
+	}
+	context.logEvent(killLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Signal:     signal,
+		PIDs:       pids,
+	})
+	processes := map[int]process{}
+	for _, p := range listProcesses(context) {
+		processes[p.pid] = p
+	}
+	var status uint32
+	for _, arg := range pids {
+		pid, err := strconv.Atoi(arg)
+		if err != nil {
+			if _, err := fmt.Fprintf(context.stderr, "kill: failed to parse argument: '%v'\n", arg); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		p, exists := processes[pid]
+		switch {
+		case !exists:
+			if _, err := fmt.Fprintf(context.stderr, "kill: (%v): No such process\n", pid); err != nil {
+				return 1, err
+			}
+			status = 1
+		case context.user != "root" && p.user != context.user:
+			if _, err := fmt.Fprintf(context.stderr, "kill: (%v): Operation not permitted\n", pid); err != nil {
+				return 1, err
+			}
+			status = 1
+		}
+	}
+	return status, nil
+}
+
+func init() {
+	registerCommand("pkill", cmdPkill{name: "pkill"})
+	registerCommand("killall", cmdPkill{name: "killall", killall: true})
+}
+
+// cmdPkill signals processes matched by name, as pkill does with a pattern or killall with exact names.
+type cmdPkill struct {
+	name    string
+	killall bool
+}
+
+func (c cmdPkill) execute(context commandContext) (uint32, error) {
+	signal := "15"
+	full := false
+	var patterns []string
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-f" || arg == "--full":
+			full = !c.killall
+		case (arg == "-s" || arg == "--signal") && i+1 < len(args):
+			i++
+			signal = args[i]
+		case strings.HasPrefix(arg, "--signal="):
+			signal = strings.TrimPrefix(arg, "--signal=")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			signal = arg[1:]
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+	if len(patterns) == 0 || (!c.killall && len(patterns) > 1) {
+		usage := "pkill: no matching criteria specified\nTry `pkill --help' for more information."
+		if c.killall {
+			usage = "Usage: killall [OPTION]... [--] NAME..."
+		}
+		_, err := fmt.Fprintln(context.stderr, usage)
+		return 2, err
+	}
+	if _, ok := parseSignal(signal); !ok {
+		_, err := fmt.Fprintf(context.stderr, "%v: unknown signal: %v\n", c.name, signal)
+		return 2, err
+	}
+	var matchers []*regexp.Regexp
+	for _, pattern := range patterns {
+		if c.killall {
+			pattern = "^" + regexp.QuoteMeta(pattern) + "$"
+		}
+		matcher, err := regexp.Compile(pattern)
+		if err != nil {
+			_, err := fmt.Fprintf(context.stderr, "pkill: invalid regular expression: %v\n", pattern)
+			return 2, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	processes := listProcesses(context)
+	// The process running the command never matches itself
+	processes = processes[:len(processes)-1]
+	entry := pkillLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Signal:     signal,
+		Patterns:   patterns,
+	}
+	var status uint32
+	for i, matcher := range matchers {
+		matched := false
+		for _, p := range processes {
+			target := p.name()
+			if full {
+				target = p.command
+			}
+			if !matcher.MatchString(target) {
+				continue
+			}
+			matched = true
+			if context.user != "root" && p.user != context.user {
+				if _, err := fmt.Fprintf(context.stderr, "%v: killing pid %v failed: Operation not permitted\n", c.name, p.pid); err != nil {
+					return 1, err
+				}
+				status = 1
+				continue
+			}
+			entry.PIDs = append(entry.PIDs, p.pid)
+			entry.Commands = append(entry.Commands, p.command)
+		}
+		if !matched {
+			if c.killall {
+				if _, err := fmt.Fprintf(context.stderr, "%v: no process found\n", patterns[i]); err != nil {
+					return 1, err
+				}
+			}
+			status = 1
+		}
+	}
+	context.logEvent(entry)
+	return status, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rateLimitedConnectionsMetric = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sshesame_rate_limited_connections_total",
+	Help: "Total number of connections rejected by the rate limit",
+})
+
+// rateLimitSweepInterval is how often idle buckets are evicted from the limiter.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket connection rate limiter keyed by source IP.
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	now       func() time.Time
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg rateLimitConfig) *rateLimiter {
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: cfg.Rate, burst: float64(burst), now: time.Now, buckets: map[string]*tokenBucket{}}
+}
+
+// refill adds the tokens earned since the last update of the bucket, up to the burst size.
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * limiter.rate
+	if bucket.tokens > limiter.burst {
+		bucket.tokens = limiter.burst
+	}
+	bucket.last = now
+}
+
+// allow takes a token from the bucket of ip, reporting whether there was one.
+func (limiter *rateLimiter) allow(ip string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.now()
+	if now.Sub(limiter.lastSweep) >= rateLimitSweepInterval {
+		limiter.sweep(now)
+	}
+	bucket := limiter.buckets[ip]
+	if bucket == nil {
+		bucket = &tokenBucket{limiter.burst, now}
+		limiter.buckets[ip] = bucket
+	}
+	limiter.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweep evicts buckets that have refilled completely, as a new bucket would behave the same.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	for ip, bucket := range limiter.buckets {
+		limiter.refill(bucket, now)
+		if bucket.tokens >= limiter.burst {
+			delete(limiter.buckets, ip)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+// rawConnMetadata allows logging events about connections that haven't completed the SSH handshake.
+type rawConnMetadata struct {
+	net.Conn
+}
+
+func (rawConnMetadata) User() string          { return "" }
+func (rawConnMetadata) SessionID() []byte     { return nil }
+func (rawConnMetadata) ClientVersion() []byte { return nil }
+func (rawConnMetadata) ServerVersion() []byte { return nil }
+
+// rateLimitedListener closes connections exceeding the rate limit of their source IP before the SSH handshake.
+type rateLimitedListener struct {
+	net.Listener
+	limiter *rateLimiter
+	cfg     *config
+}
+
+// newRateLimitedListener limits the connections accepted by listener with limiter,
+// which can be shared by several listeners.
+func newRateLimitedListener(listener net.Listener, limiter *rateLimiter, cfg *config) net.Listener {
+	return &rateLimitedListener{listener, limiter, cfg}
+}
+
+func (listener *rateLimitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := listener.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil || listener.limiter.allow(host) {
+			return conn, nil
+		}
+		rateLimitedConnectionsMetric.Inc()
+		connContext{ConnMetadata: rawConnMetadata{conn}, cfg: listener.cfg}.logEvent(rateLimitLog{})
+		conn.Close()
+	}
+}
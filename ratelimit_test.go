@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(rateLimitConfig{Rate: 1, Burst: 2})
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+	for i, expected := range []bool{true, true, false} {
+		if allowed := limiter.allow("192.0.2.1"); allowed != expected {
+			t.Errorf("allow #%v=%v, want %v", i, allowed, expected)
+		}
+	}
+	if !limiter.allow("192.0.2.2") {
+		t.Errorf("allow=false for another IP, want true")
+	}
+	now = now.Add(time.Second)
+	if !limiter.allow("192.0.2.1") {
+		t.Errorf("allow=false after a second, want true")
+	}
+	now = now.Add(rateLimitSweepInterval)
+	limiter.allow("192.0.2.3")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("len(buckets)=%v, want idle buckets to be evicted", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitedListener(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.RateLimit = rateLimitConfig{Rate: 0.001, Burst: 3}
+	logs := setupLogBuffer(t, cfg)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newRateLimitedListener(tcpListener, newRateLimiter(cfg.Server.RateLimit), cfg)
+	accepted := make(chan net.Conn, 5)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	var clients []net.Conn
+	for i := 0; i < 5; i++ {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		if i < 3 {
+			select {
+			case conn := <-accepted:
+				defer conn.Close()
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for connection #%v to be accepted", i)
+			}
+		}
+	}
+	for _, client := range clients[3:] {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("err=%v, want the rate limited connection to be closed", err)
+		}
+	}
+	listener.Close()
+	<-done
+	if len(accepted) != 0 {
+		t.Errorf("%v extra connections accepted, want 0", len(accepted))
+	}
+	if count := strings.Count(logs.String(), "] connection rate limited\n"); count != 2 {
+		t.Errorf("logs=%v, want 2 rate limited connections", logs.String())
+	}
+}
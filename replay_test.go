@@ -481,6 +481,8 @@ func (event channelErrorReplayEntry) Wait(t *testing.T, context *clientContext)
 }
 
 func TestReplay(t *testing.T) {
+	// A frozen clock keeps the logged connection durations reproducible.
+	stubTimeSource(t, time.Date(2023, 11, 14, 9, 12, 37, 0, time.UTC))
 	tempDir := t.TempDir()
 
 	keyFile, err := generateKey(tempDir, ecdsa_key)
@@ -493,6 +495,9 @@ func TestReplay(t *testing.T) {
 		80: "HTTP",
 	}
 	cfg.Auth.NoAuth = true
+	if err := cfg.setupTCPIPServers(); err != nil {
+		t.Fatal(err)
+	}
 	if err := cfg.setupSSHConfig(); err != nil {
 		t.Fatal(err)
 	}
@@ -595,6 +600,8 @@ func TestReplay(t *testing.T) {
 							break
 						}
 						expectedLogLine := strings.ReplaceAll(testCase.PlainLogs[i], "SOURCE", conn.LocalAddr().String())
+						expectedLogLine = strings.ReplaceAll(expectedLogLine, "CONNECTION", formatConnectionID(sshConn.SessionID()))
+						expectedLogLine = strings.ReplaceAll(expectedLogLine, "LISTENER", listener.Addr().String())
 						if logLine != expectedLogLine {
 							t.Errorf("Log mismatch at line %d: got \n%q, want \n%q", i, logLine, expectedLogLine)
 						}
@@ -613,6 +620,12 @@ func TestReplay(t *testing.T) {
 						}
 						expectedLogLine := testCase.JSONLogs[i]
 						expectedLogLine["source"] = conn.LocalAddr().String()
+						if event, ok := expectedLogLine["event"].(map[string]interface{}); ok && event["connection_id"] == "CONNECTION" {
+							event["connection_id"] = formatConnectionID(sshConn.SessionID())
+						}
+						if event, ok := expectedLogLine["event"].(map[string]interface{}); ok && event["listener"] == "LISTENER" {
+							event["listener"] = listener.Addr().String()
+						}
 						if !reflect.DeepEqual(parsedLogLine, expectedLogLine) {
 							t.Errorf("Log mismatch at line %d: got \n%#v, want \n%#v", i, parsedLogLine, expectedLogLine)
 						}
@@ -17,6 +17,8 @@ type globalRequestPayload interface {
 	logEntry(context *connContext) logEntry
 }
 
+const keepaliveRequestType = "keepalive@openssh.com"
+
 type globalRequestPayloadParser func(data []byte, context *connContext) (globalRequestPayload, error)
 
 type tcpipRequest struct {
@@ -146,14 +148,7 @@ var (
 func handleGlobalRequest(request *ssh.Request, context *connContext) error {
 	parser := globalRequestPayloads[request.Type]
 	if parser == nil {
-		globalRequestsMetric.WithLabelValues("unknown").Inc()
-		warningLogger.Printf("Unsupported global request type %v", request.Type)
-		if request.WantReply {
-			if err := request.Reply(false, nil); err != nil {
-				return err
-			}
-		}
-		return nil
+		return handleUnsupportedGlobalRequest(request, context)
 	}
 	globalRequestsMetric.WithLabelValues(request.Type).Inc()
 	payload, err := parser(request.Payload, context)
@@ -173,6 +168,28 @@ func handleGlobalRequest(request *ssh.Request, context *connContext) error {
 	return nil
 }
 
+// handleUnsupportedGlobalRequest replies to a request without a payload parser as configured, failing by default.
+func handleUnsupportedGlobalRequest(request *ssh.Request, context *connContext) error {
+	// Clients send keepalives regularly and expect any reply, so they're counted on their own rather than as unknown.
+	if request.Type == keepaliveRequestType {
+		globalRequestsMetric.WithLabelValues(request.Type).Inc()
+	} else {
+		globalRequestsMetric.WithLabelValues("unknown").Inc()
+	}
+	accepted := context.cfg.SSHProto.GlobalRequestReplies[request.Type]
+	if request.WantReply {
+		if err := request.Reply(accepted, nil); err != nil {
+			return err
+		}
+	}
+	context.logEvent(globalRequestLog{
+		RequestType: request.Type,
+		WantReply:   request.WantReply,
+		Accepted:    accepted,
+	})
+	return nil
+}
+
 func marshalBytes(data [][]byte) []byte {
 	var result []byte
 	for _, b := range data {
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaksi/sshutils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// newConnectionTest connects a client to a connection served by handleConnection.
+// The returned function waits for the connection to be handled after closing the client.
+func newConnectionTest(t *testing.T) (*ssh.Client, *bytes.Buffer, func()) {
+	t.Helper()
+	return newConfiguredConnectionTest(t, &config{})
+}
+
+// newConfiguredConnectionTest is newConnectionTest with cfg instead of the default config.
+func newConfiguredConnectionTest(t *testing.T, cfg *config) (*ssh.Client, *bytes.Buffer, func()) {
+	t.Helper()
+	setupTestSSHConfig(t, cfg)
+	logs := setupLogBuffer(t, cfg)
+	listener, err := sshutils.Listen("127.0.0.1:0", cfg.sshConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		handleConnection(conn, cfg)
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, logs, func() {
+		client.Close()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the connection to be handled")
+		}
+	}
+}
+
+func TestTCPIPForwardRequest(t *testing.T) {
+	client, logs, wait := newConnectionTest(t)
+	accepted, reply, err := client.SendRequest("tcpip-forward", true, ssh.Marshal(tcpipRequest{"0.0.0.0", 0}))
+	if err != nil || !accepted {
+		t.Fatalf("tcpip-forward accepted=%v, err=%v", accepted, err)
+	}
+	if len(reply) != 4 || binary.BigEndian.Uint32(reply) < 1024 {
+		t.Errorf("reply=%v, want an allocated unprivileged port", reply)
+	}
+	if accepted, reply, err := client.SendRequest("tcpip-forward", true, ssh.Marshal(tcpipRequest{"127.0.0.1", 8080})); err != nil || !accepted || len(reply) != 0 {
+		t.Errorf("tcpip-forward accepted=%v, reply=%v, err=%v, want an empty reply for a fixed port", accepted, reply, err)
+	}
+	if _, _, err := client.SendRequest("cancel-tcpip-forward", true, ssh.Marshal(cancelTCPIPRequest{"127.0.0.1", 8080})); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	for _, want := range []string{
+		"] TCP/IP forwarding on 0.0.0.0:0 requested\n",
+		"] TCP/IP forwarding on 127.0.0.1:8080 requested\n",
+		"] TCP/IP forwarding on 127.0.0.1:8080 canceled\n",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestChannelExtraDataTruncated(t *testing.T) {
+	cfg := &config{}
+	cfg.Logging.Debug = true
+	cfg.Logging.JSON = true
+	cfg.Logging.MaxExtraDataBytes = 8
+	client, logs, wait := newConfiguredConnectionTest(t, cfg)
+	if _, _, err := client.OpenChannel("x11", []byte(strings.Repeat("A", 4096))); err == nil {
+		t.Errorf("x11 channel accepted, want it rejected")
+	}
+	wait()
+	want := `"event":{"channel_id":0,"channel_type":"x11","extra_data":"AAAAAAAA...(truncated)","extra_data_length":4096}`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("logs=%v, want them to contain %v", logs.String(), want)
+	}
+}
+
+func TestConnectionCloseLog(t *testing.T) {
+	start := time.Date(2023, 11, 14, 9, 12, 37, 0, time.UTC)
+	stubTimeSource(t, start)
+	cfg := &config{}
+	cfg.Logging.JSON = true
+	client, logs, wait := newConfiguredConnectionTest(t, cfg)
+	for i := 0; i < 2; i++ {
+		channel, requests, err := client.OpenChannel("session", nil)
+		if err != nil {
+			t.Fatalf("Failed to open session channel: %v", err)
+		}
+		go ssh.DiscardRequests(requests)
+		channel.Close()
+	}
+	stubTimeSource(t, start.Add(90*time.Second))
+	wait()
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	var entry struct {
+		EventType string             `json:"event_type"`
+		Event     connectionCloseLog `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("Failed to parse logs %q: %v", logs.String(), err)
+	}
+	expected := connectionCloseLog{Duration: 90, Channels: 2, User: "root", AuthMethod: "none"}
+	if entry.EventType != "connection_close" || entry.Event != expected {
+		t.Errorf("last event=%+v, want %+v", entry, expected)
+	}
+}
+
+func TestKeepaliveRequest(t *testing.T) {
+	cfg := &config{}
+	cfg.SSHProto.GlobalRequestReplies = map[string]bool{"keepalive@openssh.com": true}
+	client, logs, wait := newConfiguredConnectionTest(t, cfg)
+	if accepted, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil || !accepted {
+		t.Errorf("keepalive accepted=%v, err=%v, want the configured success", accepted, err)
+	}
+	if accepted, _, err := client.SendRequest("made-up@example.com", true, nil); err != nil || accepted {
+		t.Errorf("made-up request accepted=%v, err=%v, want a failure", accepted, err)
+	}
+	wait()
+	for _, want := range []string{
+		"] global request \"keepalive@openssh.com\" received, replied with success\n",
+		"] global request \"made-up@example.com\" received, replied with failure\n",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestForwardedTCPIPChannel(t *testing.T) {
+	client, logs, wait := newConnectionTest(t)
+	channel, requests, err := client.OpenChannel("forwarded-tcpip", ssh.Marshal(tcpipChannelData{"0.0.0.0", 8080, "203.0.113.5", 5555}))
+	if err != nil {
+		t.Fatalf("Failed to open forwarded-tcpip channel: %v", err)
+	}
+	go ssh.DiscardRequests(requests)
+	if _, err := channel.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read succeeded, want the channel to be closed")
+	}
+	wait()
+	for _, want := range []string{
+		"] [channel 0] forwarded TCP/IP connection to 0.0.0.0:8080 from 203.0.113.5:5555 opened\n",
+		"] [channel 0] input: \"hello\"\n",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestRejectedChannel(t *testing.T) {
+	cfg := &config{}
+	cfg.SSHProto.ChannelRejectMessage = "administratively prohibited"
+	client, logs, wait := newConfiguredConnectionTest(t, cfg)
+	x11Rejections := testutil.ToFloat64(rejectedChannelsMetric.WithLabelValues("x11"))
+	otherRejections := testutil.ToFloat64(rejectedChannelsMetric.WithLabelValues("other"))
+	for _, channelType := range []string{"x11", "made-up@example.com"} {
+		_, _, err := client.OpenChannel(channelType, nil)
+		var openChannelErr *ssh.OpenChannelError
+		if !errors.As(err, &openChannelErr) || openChannelErr.Message != "administratively prohibited" {
+			t.Errorf("err=%v, want the channel rejected with the configured message", err)
+		}
+	}
+	wait()
+	for _, want := range []string{
+		"] x11 channel rejected: administratively prohibited\n",
+		"] made-up@example.com channel rejected: administratively prohibited\n",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %q", logs.String(), want)
+		}
+	}
+	if rejections := testutil.ToFloat64(rejectedChannelsMetric.WithLabelValues("x11")) - x11Rejections; rejections != 1 {
+		t.Errorf("x11 rejections=%v, want 1", rejections)
+	}
+	if rejections := testutil.ToFloat64(rejectedChannelsMetric.WithLabelValues("other")) - otherRejections; rejections != 1 {
+		t.Errorf("other rejections=%v, want 1", rejections)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxSCPFileSize bounds the size of a file uploaded with scp, regardless of the filesystem limits.
+const maxSCPFileSize = 16 << 20
+
+// scpSinkTarget returns the target of an scp -t command, run by scp clients to upload files.
+func scpSinkTarget(command string) (string, bool) {
+	args := strings.Fields(command)
+	if len(args) == 0 || args[0] != "scp" {
+		return "", false
+	}
+	sink := false
+	target := "."
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			if i+2 < len(args) {
+				target = args[i+2]
+			}
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			target = arg
+			break
+		}
+		if strings.ContainsRune(arg, 't') {
+			sink = true
+		}
+	}
+	return target, sink
+}
+
+type scpError string
+
+func (err scpError) Error() string {
+	return string(err)
+}
+
+// scpSink receives files uploaded with the scp protocol into a session's copy of the fake filesystem.
+type scpSink struct {
+	context *sessionContext
+	fs      *FileSystemType
+	user    string
+	reader  *bufio.Reader
+	// dirs holds the directories entered with D messages, the target directory being the first one.
+	dirs  []*FileSystemNode
+	paths []string
+}
+
+func (sink *scpSink) ack() error {
+	_, err := sink.context.Write([]byte{0})
+	return err
+}
+
+// place returns the directory and name a file or directory named in a message is stored as.
+func (sink *scpSink) place(target, name string) (*FileSystemNode, string, string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return nil, "", "", scpError(fmt.Sprintf("error: unexpected filename: %v", name))
+	}
+	if len(sink.dirs) > 0 {
+		return sink.dirs[len(sink.dirs)-1], name, path.Join(sink.paths[len(sink.paths)-1], name), nil
+	}
+	filePath := sink.fs.absPath(target)
+	dir, name := path.Split(filePath)
+	parent := sink.fs.lookup(dir)
+	if parent == nil || !parent.IsDir {
+		return nil, "", "", scpError(fmt.Sprintf("%v: No such file or directory", target))
+	}
+	return parent, name, filePath, nil
+}
+
+func parseSCPHeader(line string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", scpError("protocol error: bad mode")
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", scpError("protocol error: bad mode")
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", scpError("protocol error: size not delimited")
+	}
+	return os.FileMode(mode) & os.ModePerm, size, fields[2], nil
+}
+
+func (sink *scpSink) receiveFile(target string, line string) error {
+	mode, size, name, err := parseSCPHeader(line)
+	if err != nil {
+		return err
+	}
+	parent, name, filePath, err := sink.place(target, name)
+	if err != nil {
+		return err
+	}
+	if size > maxSCPFileSize {
+		return scpError(fmt.Sprintf("%v: %v", filePath, errNoSpace))
+	}
+	if err := sink.ack(); err != nil {
+		return err
+	}
+	var content strings.Builder
+	if _, err := io.CopyN(&content, sink.reader, size); err != nil {
+		return err
+	}
+	if status, err := sink.reader.ReadByte(); err != nil {
+		return err
+	} else if status != 0 {
+		return scpError("protocol error: expected control record")
+	}
+	hash := sha256.Sum256([]byte(content.String()))
+	sink.context.logEvent(scpUploadLog{
+		channelLog: channelLog{ChannelID: sink.context.channelID},
+		Path:       filePath,
+		Mode:       fmt.Sprintf("%04o", mode),
+		Size:       size,
+		SHA256:     hex.EncodeToString(hash[:]),
+	})
+	node, exists := parent.Children[name]
+	switch {
+	case exists && node.IsDir:
+		return scpError(fmt.Sprintf("%v: Is a directory", filePath))
+	case exists && !node.canWrite(sink.user), !exists && !parent.canWrite(sink.user):
+		return scpError(fmt.Sprintf("%v: Permission denied", filePath))
+	case !exists:
+		if node, err = sink.fs.create(parent, name, false, sink.user); err != nil {
+			return scpError(fmt.Sprintf("%v: %v", filePath, err))
+		}
+		node.Mode = mode
+	}
+	if err := sink.fs.write(node, content.String()); err != nil {
+		return scpError(fmt.Sprintf("%v: %v", filePath, err))
+	}
+	return sink.ack()
+}
+
+func (sink *scpSink) enterDirectory(target string, line string) error {
+	mode, _, name, err := parseSCPHeader(line)
+	if err != nil {
+		return err
+	}
+	parent, name, dirPath, err := sink.place(target, name)
+	if err != nil {
+		return err
+	}
+	node, exists := parent.Children[name]
+	switch {
+	case exists && !node.IsDir:
+		return scpError(fmt.Sprintf("%v: Not a directory", dirPath))
+	case !exists && !parent.canWrite(sink.user):
+		return scpError(fmt.Sprintf("%v: Permission denied", dirPath))
+	case !exists:
+		if node, err = sink.fs.create(parent, name, true, sink.user); err != nil {
+			return scpError(fmt.Sprintf("%v: %v", dirPath, err))
+		}
+		node.Mode = mode
+	}
+	sink.dirs = append(sink.dirs, node)
+	sink.paths = append(sink.paths, dirPath)
+	return sink.ack()
+}
+
+// serve receives files into target until the client is done, the way scp -t does.
+func (sink *scpSink) serve(target string) error {
+	if node := sink.fs.lookup(target); node != nil && node.IsDir {
+		sink.dirs = []*FileSystemNode{node}
+		sink.paths = []string{sink.fs.absPath(target)}
+	}
+	depth := len(sink.dirs)
+	if err := sink.ack(); err != nil {
+		return err
+	}
+	for {
+		line, err := sink.reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return scpError("protocol error: unexpected <newline>")
+		}
+		switch line[0] {
+		case 'C':
+			err = sink.receiveFile(target, line)
+		case 'D':
+			err = sink.enterDirectory(target, line)
+		case 'E':
+			if len(sink.dirs) <= depth {
+				return scpError("protocol error: unexpected <newline>")
+			}
+			sink.dirs = sink.dirs[:len(sink.dirs)-1]
+			sink.paths = sink.paths[:len(sink.paths)-1]
+			err = sink.ack()
+		case 'T':
+			err = sink.ack()
+		case '\x01', '\x02':
+			// The client reports its own errors, after which it stops sending.
+			return nil
+		default:
+			return scpError(fmt.Sprintf("protocol error: unexpected <%q>", line[0]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleSCP serves an scp -t command on the session channel, storing the uploaded files and logging their hashes.
+func (context *sessionContext) handleSCP(target string) {
+	sink := &scpSink{
+		context: context,
+		fs:      newSessionFileSystem(context.cfg.Shell),
+		user:    context.User(),
+		reader:  bufio.NewReader(context.Channel),
+	}
+	go func() {
+		defer close(context.inputChan)
+		var status uint32
+		if err := sink.serve(target); err != nil {
+			status = 1
+			if scpErr, ok := err.(scpError); ok {
+				if _, err := fmt.Fprintf(context, "\x01scp: %v\n", scpErr); err != nil {
+					warningLogger.Printf("Error sending scp error: %v", err)
+				}
+			} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+				warningLogger.Printf("Error serving scp: %v", err)
+			}
+		}
+		if _, err := context.SendRequest("exit-status", false, ssh.Marshal(struct {
+			ExitStatus uint32
+		}{status})); err != nil {
+			warningLogger.Printf("Error sending exit status: %s", err)
+			return
+		}
+		if err := context.Close(); err != nil {
+			warningLogger.Printf("Error closing channel: %s", err)
+		}
+	}()
+}
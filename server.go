@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jaksi/sshutils"
+)
+
+// sshServer accepts connections on all its listeners and keeps track of them so that they can be drained on shutdown.
+type sshServer struct {
+	listeners []*sshutils.Listener
+	cfg       *config
+	mutex     sync.Mutex
+	conns     map[*sshutils.Conn]struct{}
+	closing   bool
+	handlers  sync.WaitGroup
+}
+
+func newSSHServer(listeners []*sshutils.Listener, cfg *config) *sshServer {
+	return &sshServer{listeners: listeners, cfg: cfg, conns: map[*sshutils.Conn]struct{}{}}
+}
+
+// serve handles connections until the listeners are closed by shutdown.
+func (server *sshServer) serve() {
+	var accepting sync.WaitGroup
+	for _, listener := range server.listeners {
+		accepting.Add(1)
+		go func(listener *sshutils.Listener) {
+			defer accepting.Done()
+			server.accept(listener)
+		}(listener)
+	}
+	accepting.Wait()
+}
+
+func (server *sshServer) accept(listener *sshutils.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			warningLogger.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+		server.mutex.Lock()
+		if server.closing {
+			server.mutex.Unlock()
+			conn.Close()
+			continue
+		}
+		server.conns[conn] = struct{}{}
+		server.handlers.Add(1)
+		server.mutex.Unlock()
+		go func() {
+			defer server.handlers.Done()
+			handleConnection(conn, server.cfg)
+			server.mutex.Lock()
+			delete(server.conns, conn)
+			server.mutex.Unlock()
+		}()
+	}
+}
+
+// shutdown stops accepting connections and waits up to gracePeriod for the active ones to be closed by clients,
+// then closes the remaining ones, returning once all of them have been handled.
+func (server *sshServer) shutdown(gracePeriod time.Duration) {
+	server.mutex.Lock()
+	server.closing = true
+	infoLogger.Printf("Shutting down, waiting up to %v for %v active connections to close", gracePeriod, len(server.conns))
+	server.mutex.Unlock()
+	for _, listener := range server.listeners {
+		if err := listener.Close(); err != nil {
+			warningLogger.Printf("Failed to close listener: %v", err)
+		}
+	}
+	drained := make(chan struct{})
+	go func() {
+		server.handlers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return
+	case <-time.After(gracePeriod):
+	}
+	server.mutex.Lock()
+	infoLogger.Printf("Closing %v remaining connections", len(server.conns))
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mutex.Unlock()
+	<-drained
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaksi/sshutils"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestShutdownClosesSessions(t *testing.T) {
+	cfg := &config{}
+	setupTestSSHConfig(t, cfg)
+	setupLogBuffer(t, cfg)
+	listener, err := sshutils.Listen("127.0.0.1:0", cfg.sshConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newSSHServer([]*sshutils.Listener{listener}, cfg)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.serve()
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	gracePeriod := 100 * time.Millisecond
+	start := time.Now()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		server.shutdown(gracePeriod)
+	}()
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the server to shut down")
+	}
+	if elapsed := time.Since(start); elapsed < gracePeriod {
+		t.Errorf("shutdown took %v, want the session to be given %v to close", elapsed, gracePeriod)
+	}
+	<-served
+	closed := make(chan error)
+	go func() {
+		closed <- client.Wait()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for the connection to be closed")
+	}
+	if _, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	}); err == nil {
+		t.Errorf("Connection accepted after shutdown")
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	cfg := &config{}
+	setupTestSSHConfig(t, cfg)
+	logs := setupLogBuffer(t, cfg)
+	var listeners []*sshutils.Listener
+	for i := 0; i < 2; i++ {
+		listener, err := sshutils.Listen("127.0.0.1:0", cfg.sshConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+	}
+	server := newSSHServer(listeners, cfg)
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.serve()
+	}()
+	for _, listener := range listeners {
+		client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            "root",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		// The connection is logged before any channel is handled
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer session.Close()
+	}
+	server.shutdown(0)
+	<-served
+	for _, listener := range listeners {
+		if expected := fmt.Sprintf(`established on %v`, listener.Addr()); !strings.Contains(logs.String(), expected) {
+			t.Errorf("logs=%v, want a connection %v", logs.String(), expected)
+		}
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	cfg := &config{}
+	cfg.SSHProto.Version = "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6"
+	setupTestSSHConfig(t, cfg)
+	setupLogBuffer(t, cfg)
+	listener, err := sshutils.Listen("127.0.0.1:0", cfg.sshConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handleConnection(conn, cfg)
+	}()
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if version := string(client.ServerVersion()); version != cfg.SSHProto.Version {
+		t.Errorf("ServerVersion()=%q, want %q", version, cfg.SSHProto.Version)
+	}
+}
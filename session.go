@@ -2,9 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -22,13 +27,20 @@ func (request ptyRequestPayload) reply() []byte {
 	return nil
 }
 func (request ptyRequestPayload) logEntry(channelID int) logEntry {
+	modes, err := parseTerminalModes(request.Modes)
+	if err != nil {
+		warningLogger.Printf("Failed to parse terminal modes: %v", err)
+	}
 	return ptyLog{
 		channelLog: channelLog{
 			ChannelID: channelID,
 		},
-		Terminal: request.Term,
-		Width:    request.Width,
-		Height:   request.Height,
+		Terminal:    request.Term,
+		Width:       request.Width,
+		Height:      request.Height,
+		PixelWidth:  request.PixelWidth,
+		PixelHeight: request.PixelHeight,
+		Modes:       modes,
 	}
 }
 
@@ -135,11 +147,21 @@ type sessionContext struct {
 	inputChan chan string
 	active    bool
 	pty       bool
+	// width and height are the terminal size in characters, as last requested by the client.
+	// Commands read height while it changes, so it's written atomically.
+	width, height uint32
+	// pixelWidth and pixelHeight are the terminal size in pixels, if the client sent it.
+	pixelWidth, pixelHeight uint32
+	terminal                *term.Terminal
+	env                     map[string]string
+	transcript              *transcript
+	done                    chan struct{}
 }
 
 type scannerReadLiner struct {
-	scanner   *bufio.Scanner
-	inputChan chan<- string
+	scanner    *bufio.Scanner
+	inputChan  chan<- string
+	transcript *transcript
 }
 
 func (r scannerReadLiner) ReadLine() (string, error) {
@@ -150,13 +172,103 @@ func (r scannerReadLiner) ReadLine() (string, error) {
 		return "", io.EOF
 	}
 	line := r.scanner.Text()
+	r.transcript.input(line)
 	r.inputChan <- line
 	return line, nil
 }
 
 type terminalReadLiner struct {
-	terminal  *term.Terminal
-	inputChan chan<- string
+	terminal   *term.Terminal
+	inputChan  chan<- string
+	transcript *transcript
+	// filter, if set, is what the terminal reads the client's input through.
+	filter *ctrlDFilter
+}
+
+// ctrlD ends the input of a command when typed on an empty line.
+const ctrlD = '\x04'
+
+// ctrlDKey is what a Ctrl-D from the client reaches the terminal as. term.Terminal leaves a Ctrl-D
+// ending the input in its buffer, so every later ReadLine would fail and the shell couldn't continue
+// after a command's input is ended. ctrlDFilter handles it with the terminal's key callback instead.
+const ctrlDKey = '\x1c'
+
+// ctrlDFilter passes the client's input to a terminal with Ctrl-D replaced by ctrlDKey. Input is passed
+// on up to the first Ctrl-D, so the next read sees what the key callback made of it.
+type ctrlDFilter struct {
+	io.ReadWriter
+	// pending is input read from the client but not passed on yet.
+	pending []byte
+	// submit is set when the next read has to end the line with an Enter.
+	submit bool
+	// eof is set when the line being read was ended by a Ctrl-D.
+	eof bool
+	// lock guards skipNewline, which is set when the terminal's echo of a submitted Enter is to be dropped.
+	lock        sync.Mutex
+	skipNewline bool
+}
+
+func (f *ctrlDFilter) Read(p []byte) (int, error) {
+	if f.submit {
+		f.submit = false
+		f.lock.Lock()
+		f.skipNewline = true
+		f.lock.Unlock()
+		return copy(p, "\r"), nil
+	}
+	if len(f.pending) == 0 {
+		buf := make([]byte, len(p))
+		n, err := f.ReadWriter.Read(buf)
+		if n == 0 {
+			return 0, err
+		}
+		f.pending = buf[:n]
+	}
+	input := f.pending
+	if i := bytes.IndexByte(input, ctrlD); i >= 0 {
+		input[i] = ctrlDKey
+		input = input[:i+1]
+	}
+	n := copy(p, input)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+func (f *ctrlDFilter) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	skip := f.skipNewline && string(p) == "\r\n"
+	f.skipNewline = false
+	f.lock.Unlock()
+	if skip {
+		return len(p), nil
+	}
+	return f.ReadWriter.Write(p)
+}
+
+// key is the terminal key callback. Like the terminal, it treats Ctrl-D as the end of input on an empty line
+// and as deleting the character under the cursor otherwise.
+func (f *ctrlDFilter) key(line string, pos int, key rune) (string, int, bool) {
+	if key != ctrlDKey {
+		return keepCtrlX(line, pos, key)
+	}
+	if line == "" {
+		f.submit, f.eof = true, true
+		return "", 0, false
+	}
+	if pos == len(line) {
+		return "", 0, false
+	}
+	_, size := utf8.DecodeRuneInString(line[pos:])
+	return line[:pos] + line[pos+size:], pos, true
+}
+
+// ended reports whether the line just read was ended by a Ctrl-D, resetting it for the next one.
+func (f *ctrlDFilter) ended() bool {
+	if f == nil || !f.eof {
+		return false
+	}
+	f.eof = false
+	return true
 }
 
 type clientEOFError struct{}
@@ -169,7 +281,26 @@ func (clientEOFError) Error() string {
 
 func (r terminalReadLiner) ReadLine() (string, error) {
 	line, err := r.terminal.ReadLine()
+	if r.filter.ended() {
+		return "", clientEOF
+	}
 	if err == nil || line != "" {
+		r.transcript.input(line)
+		r.inputChan <- line
+	}
+	if err == io.EOF {
+		return line, clientEOF
+	}
+	return line, err
+}
+
+func (r terminalReadLiner) ReadPassword(prompt string) (string, error) {
+	line, err := r.terminal.ReadPassword(prompt)
+	if r.filter.ended() {
+		return "", clientEOF
+	}
+	if err == nil || line != "" {
+		r.transcript.input(line)
 		r.inputChan <- line
 	}
 	if err == io.EOF {
@@ -182,20 +313,45 @@ func (context *sessionContext) handleProgram(program []string) {
 	context.active = true
 	var stdin readLiner
 	var stdout, stderr io.Writer
+	var channel io.ReadWriter = context
+	var channelStderr io.Writer = context.Stderr()
+	if context.cfg.Session.TranscriptDirectory != "" {
+		transcript, err := newTranscript(context.channelContext, context.width, context.height)
+		if err != nil {
+			warningLogger.Printf("Failed to create transcript: %v", err)
+		} else {
+			context.transcript = transcript
+			channel = struct {
+				io.Reader
+				io.Writer
+			}{context, transcript.writer(context)}
+			channelStderr = transcript.writer(channelStderr)
+		}
+	}
 	if context.pty {
-		terminal := term.NewTerminal(context, "")
-		stdin = terminalReadLiner{terminal, context.inputChan}
+		filter := &ctrlDFilter{ReadWriter: channel}
+		terminal := term.NewTerminal(filter, "")
+		terminal.AutoCompleteCallback = filter.key
+		if context.width != 0 && context.height != 0 {
+			if err := terminal.SetSize(int(context.width), int(context.height)); err != nil {
+				warningLogger.Printf("Error setting terminal size: %s", err)
+			}
+		}
+		context.terminal = terminal
+		stdin = terminalReadLiner{terminal, context.inputChan, context.transcript, filter}
 		stdout = terminal
 		stderr = terminal
 	} else {
-		stdin = scannerReadLiner{bufio.NewScanner(context), context.inputChan}
-		stdout = context
-		stderr = context.Stderr()
+		stdin = scannerReadLiner{bufio.NewScanner(context), context.inputChan, context.transcript}
+		stdout = channel
+		stderr = channelStderr
 	}
+	state := &shellState{fs: newSessionFileSystem(context.cfg.Shell)}
 	go func() {
 		defer close(context.inputChan)
+		defer state.waitForInput()
 
-		result, err := executeProgram(commandContext{program, stdin, stdout, stderr, context.pty, context.User()})
+		result, err := executeProgram(commandContext{context.channelContext, program, stdin, stdout, stderr, context.pty, &context.height, context.User(), context.env, state, context.done})
 		if err != nil && err != io.EOF && err != clientEOF {
 			warningLogger.Printf("Error executing program: %s", err)
 			return
@@ -207,6 +363,7 @@ func (context *sessionContext) handleProgram(program []string) {
 				return
 			}
 		}
+		context.transcript.flush()
 
 		if _, err := context.SendRequest("exit-status", false, ssh.Marshal(struct {
 			ExitStatus uint32
@@ -251,6 +408,12 @@ func (context *sessionContext) handleRequest(request *ssh.Request) error {
 				return err
 			}
 			context.pty = true
+			context.width = payload.Width
+			atomic.StoreUint32(&context.height, payload.Height)
+			context.pixelWidth, context.pixelHeight = payload.PixelWidth, payload.PixelHeight
+			if payload.Term != "" {
+				context.env["TERM"] = payload.Term
+			}
 			return nil
 		}
 	case "shell":
@@ -286,6 +449,7 @@ func (context *sessionContext) handleRequest(request *ssh.Request) error {
 				return err
 			}
 			context.logEvent(payload.logEntry(context.channelID))
+			context.env[payload.Name] = payload.Value
 			return request.Reply(true, payload.reply())
 		}
 	case "exec":
@@ -300,7 +464,11 @@ func (context *sessionContext) handleRequest(request *ssh.Request) error {
 				return err
 			}
 			context.active = true
-			context.handleProgram(strings.Fields(payload.Command))
+			if target, ok := scpSinkTarget(payload.Command); ok {
+				context.handleSCP(target)
+				return nil
+			}
+			context.handleProgram([]string{"sh", "-c", payload.Command})
 			return nil
 		}
 	case "subsystem":
@@ -315,6 +483,10 @@ func (context *sessionContext) handleRequest(request *ssh.Request) error {
 				return err
 			}
 			context.active = true
+			if payload.Subsystem == "sftp" {
+				context.handleSFTP()
+				return nil
+			}
 			context.handleProgram(strings.Fields(payload.Subsystem))
 			return nil
 		}
@@ -325,6 +497,13 @@ func (context *sessionContext) handleRequest(request *ssh.Request) error {
 			return err
 		}
 		context.logEvent(payload.logEntry(context.channelID))
+		context.width = payload.Width
+		atomic.StoreUint32(&context.height, payload.Height)
+		if context.terminal != nil {
+			if err := context.terminal.SetSize(int(payload.Width), int(payload.Height)); err != nil {
+				return err
+			}
+		}
 		return request.Reply(true, payload.reply())
 	default:
 		sessionChannelRequestsMetric.WithLabelValues("unknown").Inc()
@@ -374,10 +553,39 @@ func handleSessionChannel(newChannel ssh.NewChannel, context channelContext) err
 	})
 
 	inputChan := make(chan string)
-	session := sessionContext{context, channel, inputChan, false, false}
+	session := sessionContext{channelContext: context, Channel: channel, inputChan: inputChan, env: map[string]string{}, done: make(chan struct{})}
+	var endSession sync.Once
+	end := func() { endSession.Do(func() { close(session.done) }) }
+	defer end()
+
+	defer func() {
+		if session.transcript != nil {
+			if err := session.transcript.Close(); err != nil {
+				warningLogger.Printf("Failed to close transcript: %v", err)
+			}
+		}
+	}()
+
+	var deadline <-chan time.Time
+	if context.cfg.Session.MaxDuration > 0 {
+		timer := time.NewTimer(context.cfg.Session.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
 
 	for inputChan != nil || requests != nil {
 		select {
+		case <-deadline:
+			deadline = nil
+			end()
+			context.logEvent(sessionTimeoutLog{
+				channelLog: channelLog{
+					ChannelID: context.channelID,
+				},
+			})
+			if err := channel.Close(); err != nil {
+				return err
+			}
 		case input, ok := <-inputChan:
 			if !ok {
 				inputChan = nil
@@ -392,6 +600,7 @@ func handleSessionChannel(newChannel ssh.NewChannel, context channelContext) err
 		case request, ok := <-requests:
 			if !ok {
 				requests = nil
+				end()
 				if !session.active {
 					close(inputChan)
 				}
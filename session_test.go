@@ -0,0 +1,676 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+func TestEnvRequest(t *testing.T) {
+	cfg := &config{}
+	setupLogBuffer(t, cfg)
+	session := &sessionContext{
+		channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}},
+		env:            map[string]string{},
+	}
+	request := &ssh.Request{Type: "env", Payload: ssh.Marshal(envRequestPayload{"LANG", "en_US.UTF-8"})}
+	if err := session.handleRequest(request); err != nil {
+		t.Fatalf("Failed to handle env request: %v", err)
+	}
+	if session.env["LANG"] != "en_US.UTF-8" {
+		t.Errorf("env[LANG]=%q, want en_US.UTF-8", session.env["LANG"])
+	}
+}
+
+func TestWindowChangeRequest(t *testing.T) {
+	cfg := &config{}
+	logs := setupLogBuffer(t, cfg)
+	session := &sessionContext{
+		channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}},
+		env:            map[string]string{},
+	}
+	for _, request := range []*ssh.Request{
+		{Type: "pty-req", Payload: ssh.Marshal(ptyRequestPayload{"xterm", 80, 24, 0, 0, ""})},
+		{Type: "window-change", Payload: ssh.Marshal(windowChangeRequestPayload{132, 43, 1056, 688})},
+	} {
+		if err := session.handleRequest(request); err != nil {
+			t.Fatalf("Failed to handle %v request: %v", request.Type, err)
+		}
+	}
+	if session.width != 132 || session.height != 43 {
+		t.Errorf("size=%vx%v, want 132x43", session.width, session.height)
+	}
+	if !strings.Contains(logs.String(), "[channel 0] window size change to 132x43 requested") {
+		t.Errorf("logs=%v, want the window change logged", logs.String())
+	}
+}
+
+func TestPtyRequestModes(t *testing.T) {
+	cfg := &config{}
+	logs := setupLogBuffer(t, cfg)
+	session := &sessionContext{
+		channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}},
+		env:            map[string]string{},
+	}
+	// ECHO=1, ONLCR=0, TTY_OP_OSPEED=38400, then TTY_OP_END
+	modes := "\x35\x00\x00\x00\x01\x48\x00\x00\x00\x00\x81\x00\x00\x96\x00\x00"
+	request := &ssh.Request{Type: "pty-req", Payload: ssh.Marshal(ptyRequestPayload{"vt100", 120, 40, 960, 640, modes})}
+	if err := session.handleRequest(request); err != nil {
+		t.Fatalf("Failed to handle pty-req request: %v", err)
+	}
+	if session.width != 120 || session.height != 40 || session.pixelWidth != 960 || session.pixelHeight != 640 {
+		t.Errorf("size=%vx%v (%vx%v pixels), want 120x40 (960x640 pixels)", session.width, session.height, session.pixelWidth, session.pixelHeight)
+	}
+	if session.env["TERM"] != "vt100" {
+		t.Errorf("env[TERM]=%q, want vt100", session.env["TERM"])
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] PTY using terminal "vt100" (size 120x40, modes ECHO=1 ONLCR=0 TTY_OP_OSPEED=38400) requested
+`
+	if logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", logs.String(), expectedLogs)
+	}
+}
+
+func TestParseTerminalModesTruncated(t *testing.T) {
+	modes, err := parseTerminalModes("\x35\x00\x00\x00\x01\x48\x00")
+	if err == nil {
+		t.Errorf("err=nil, want an error")
+	}
+	if len(modes) != 1 || modes["ECHO"] != 1 {
+		t.Errorf("modes=%v, want the modes before the truncated one", modes)
+	}
+}
+
+type sessionTest struct {
+	channel      ssh.Channel
+	exitStatus   chan uint32
+	serverResult chan error
+	logs         *bytes.Buffer
+}
+
+// newSessionTest opens a session channel over a real SSH connection served by handleSessionChannel.
+func newSessionTest(t *testing.T, cfg *config) *sessionTest {
+	t.Helper()
+	setupTestSSHConfig(t, cfg)
+	test := &sessionTest{
+		exitStatus:   make(chan uint32, 1),
+		serverResult: make(chan error, 1),
+		logs:         setupLogBuffer(t, cfg),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			test.serverResult <- err
+			return
+		}
+		conn, newChannels, requests, err := ssh.NewServerConn(serverConn, cfg.sshConfig)
+		if err != nil {
+			test.serverResult <- err
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(requests)
+		test.serverResult <- handleSessionChannel(<-newChannels, channelContext{connContext: connContext{ConnMetadata: conn, cfg: cfg}})
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "root",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	channel, channelRequests, err := client.OpenChannel("session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.channel = channel
+	go func() {
+		for request := range channelRequests {
+			if request.Type == "exit-status" {
+				test.exitStatus <- binary.BigEndian.Uint32(request.Payload)
+			}
+		}
+	}()
+	return test
+}
+
+// finish reads the session output until EOF, checks the exit status and waits for the server to return.
+func (test *sessionTest) finish(t *testing.T, expectedStatus uint32) string {
+	t.Helper()
+	output, err := io.ReadAll(test.channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case status := <-test.exitStatus:
+		if status != expectedStatus {
+			t.Errorf("exit status=%v, want %v", status, expectedStatus)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for exit status")
+	}
+	test.channel.Close()
+	if err := <-test.serverResult; err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestExecRequest(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("exec", true, ssh.Marshal(execRequestPayload{`echo "hi  there"`})); err != nil || !accepted {
+		t.Fatalf("exec request accepted=%v, err=%v", accepted, err)
+	}
+	if output := test.finish(t, 0); output != "hi  there\n" {
+		t.Errorf("output=%q, want %q", output, "hi  there\n")
+	}
+	if !strings.Contains(test.logs.String(), `[channel 0] command "echo \"hi  there\"" requested`) {
+		t.Errorf("logs=%v, want the exec command logged", test.logs.String())
+	}
+}
+
+func TestShellExitStatus(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("false\nexit 7\n")); err != nil {
+		t.Fatal(err)
+	}
+	test.finish(t, 7)
+}
+
+func TestSessionTranscript(t *testing.T) {
+	cfg := &config{}
+	cfg.Session.TranscriptDirectory = t.TempDir()
+	test := newSessionTest(t, cfg)
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("echo hello\nfoo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := test.channel.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	test.finish(t, 127)
+	files, err := filepath.Glob(filepath.Join(cfg.Session.TranscriptDirectory, "*.cast"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files=%v, err=%v, want a single transcript", files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	var header struct {
+		Version       int
+		Width, Height uint32
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil || header.Version != 2 || header.Width != 80 || header.Height != 24 {
+		t.Errorf("header=%v, err=%v, want an 80x24 asciicast v2 header", lines[0], err)
+	}
+	var events []string
+	for _, line := range lines[1:] {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || len(event) != 3 {
+			t.Fatalf("event=%v, err=%v, want a [time, type, data] event", line, err)
+		}
+		events = append(events, fmt.Sprintf("%v %q", event[1], event[2]))
+	}
+	expectedEvents := []string{`i "echo hello\n"`, `o "hello\n"`, `i "foo\n"`, `o "foo: command not found\n"`}
+	if strings.Join(events, ", ") != strings.Join(expectedEvents, ", ") {
+		t.Errorf("events=%v, want %v", events, expectedEvents)
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	cfg := &config{}
+	cfg.Session.TranscriptDirectory = t.TempDir()
+	test := newSessionTest(t, cfg)
+	if accepted, err := test.channel.SendRequest("pty-req", true, ssh.Marshal(ptyRequestPayload{"xterm", 80, 24, 0, 0, ""})); err != nil || !accepted {
+		t.Fatalf("pty-req request accepted=%v, err=%v", accepted, err)
+	}
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("false\rlogout\r")); err != nil {
+		t.Fatal(err)
+	}
+	output := test.finish(t, 1)
+	if !strings.HasSuffix(output, "# logout\r\nlogout\r\n") {
+		t.Errorf("output=%q, want the shell to log out", output)
+	}
+	files, err := filepath.Glob(filepath.Join(cfg.Session.TranscriptDirectory, "*.cast"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files=%v, err=%v, want a single transcript", files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `,"o","logout"]`) {
+		t.Errorf("transcript=%q, want the farewell line recorded", content)
+	}
+}
+
+func TestSessionCrontabCtrlD(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("pty-req", true, ssh.Marshal(ptyRequestPayload{"xterm", 80, 24, 0, 0, ""})); err != nil || !accepted {
+		t.Fatalf("pty-req request accepted=%v, err=%v", accepted, err)
+	}
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("crontab -e\r@reboot /tmp/.x\r\x04\x04")); err != nil {
+		t.Fatal(err)
+	}
+	if output := test.finish(t, 0); !strings.Contains(output, "@reboot /tmp/.x\r\ncrontab: installing new crontab\r\n") {
+		t.Errorf("output=%q, want the crontab installed", output)
+	}
+	if !strings.Contains(test.logs.String(), `crontab for user "root" installed: "@reboot /tmp/.x\n"`) {
+		t.Errorf("logs=%v, want the crontab logged", test.logs.String())
+	}
+}
+
+func TestSessionSftpCtrlD(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("pty-req", true, ssh.Marshal(ptyRequestPayload{"xterm", 80, 24, 0, 0, ""})); err != nil || !accepted {
+		t.Fatalf("pty-req request accepted=%v, err=%v", accepted, err)
+	}
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("sftp admin@evil\rhunter2\r\x04logout\r")); err != nil {
+		t.Fatal(err)
+	}
+	if output := test.finish(t, 0); !strings.HasSuffix(output, "sftp> # logout\r\nlogout\r\n") {
+		t.Errorf("output=%q, want the shell prompt back after sftp", output)
+	}
+}
+
+func TestSessionPagerResize(t *testing.T) {
+	cfg := &config{}
+	cfg.Shell.Pager = true
+	test := newSessionTest(t, cfg)
+	if accepted, err := test.channel.SendRequest("pty-req", true, ssh.Marshal(ptyRequestPayload{"xterm", 80, 24, 0, 0, ""})); err != nil || !accepted {
+		t.Fatalf("pty-req request accepted=%v, err=%v", accepted, err)
+	}
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	if accepted, err := test.channel.SendRequest("window-change", true, ssh.Marshal(windowChangeRequestPayload{80, 3, 0, 0})); err != nil || !accepted {
+		t.Fatalf("window-change request accepted=%v, err=%v", accepted, err)
+	}
+	if _, err := test.channel.Write([]byte("cat /etc/cloud/cloud.cfg\rq\rlogout\r")); err != nil {
+		t.Fatal(err)
+	}
+	if output := test.finish(t, 0); !strings.Contains(output, "\r\n# The top level settings are used as module\r\n# and base configuration.\r\n--More--(") {
+		t.Errorf("output=%q, want a screen of the resized terminal paged", output)
+	}
+}
+
+func TestTerminalReadLinerEditing(t *testing.T) {
+	input := "eco\x7f\x7fcho\reho\x1b[D\x1b[Dc\rjunk\x15ls\r"
+	output := &bytes.Buffer{}
+	inputChan := make(chan string, 3)
+	stdin := terminalReadLiner{term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), output}, "$ "), inputChan, nil, nil}
+	for _, expectedLine := range []string{"echo", "echo", "ls"} {
+		line, err := stdin.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != expectedLine {
+			t.Errorf("line=%q, want %q", line, expectedLine)
+		}
+		if logged := <-inputChan; logged != expectedLine {
+			t.Errorf("input=%q, want %q", logged, expectedLine)
+		}
+	}
+	if _, err := stdin.ReadLine(); err != clientEOF {
+		t.Errorf("err=%v, want %v", err, clientEOF)
+	}
+	if !strings.Contains(output.String(), "$ ec") {
+		t.Errorf("output=%q, want the input echoed", output.String())
+	}
+}
+
+func TestSessionMaxDuration(t *testing.T) {
+	cfg := &config{}
+	cfg.Session.MaxDuration = 50 * time.Millisecond
+	test := newSessionTest(t, cfg)
+	if accepted, err := test.channel.SendRequest("shell", true, nil); err != nil || !accepted {
+		t.Fatalf("shell request accepted=%v, err=%v", accepted, err)
+	}
+	go func() {
+		for {
+			if _, err := test.channel.Write([]byte("true\n")); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		io.Copy(io.Discard, test.channel)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the channel to be closed")
+	}
+	if err := <-test.serverResult; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(test.logs.String(), "[channel 0] session duration limit reached") {
+		t.Errorf("logs=%v, want the duration limit logged", test.logs.String())
+	}
+}
+
+// scpUpload plays the source side of the scp protocol, sending each message after the previous one is acknowledged.
+func scpUpload(t *testing.T, channel io.ReadWriter, messages ...string) {
+	t.Helper()
+	response := make([]byte, 1)
+	for i := 0; i <= len(messages); i++ {
+		if _, err := io.ReadFull(channel, response); err != nil || response[0] != 0 {
+			t.Fatalf("response=%q, err=%v, want an acknowledgement", response, err)
+		}
+		if i == len(messages) {
+			return
+		}
+		if _, err := channel.Write([]byte(messages[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSCPSink(t *testing.T) {
+	cfg := &config{}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	context := &sessionContext{
+		channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}},
+		Channel:        mockChannel{serverConn},
+	}
+	logs := setupLogBuffer(t, cfg)
+	sink := &scpSink{context: context, fs: newSessionFileSystem(cfg.Shell), user: "root", reader: bufio.NewReader(serverConn)}
+	result := make(chan error)
+	go func() {
+		result <- sink.serve("/tmp")
+	}()
+	scpUpload(t, clientConn, "T1700000000 0 1700000000 0\n", "C0755 9 miner.sh\n", "#!/bin/sh\x00", "D0700 0 .x\n", "C0600 3 key\n", "abc\x00", "E\n")
+	clientConn.Close()
+	if err := <-result; err != nil {
+		t.Fatalf("Failed to serve scp: %v", err)
+	}
+	if node := sink.fs.lookup("/tmp/miner.sh"); node == nil || node.Content != "#!/bin/sh" || node.Mode != 0755 {
+		t.Errorf("node=%+v, want the uploaded file", node)
+	}
+	if node := sink.fs.lookup("/tmp/.x/key"); node == nil || node.Content != "abc" || node.Mode != 0600 {
+		t.Errorf("node=%+v, want the uploaded file in the uploaded directory", node)
+	}
+	expectedLogs := `[127.0.0.1:1234] [channel 0] scp upload of "/tmp/miner.sh" (mode 0755, 9 bytes, SHA-256 3af71adb278ad4af33c144b78fa1ae708da03b773d98324ae991a7daedb53ca2)
+[127.0.0.1:1234] [channel 0] scp upload of "/tmp/.x/key" (mode 0600, 3 bytes, SHA-256 ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad)
+`
+	if logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", logs.String(), expectedLogs)
+	}
+}
+
+func TestSCPExecRequest(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("exec", true, ssh.Marshal(execRequestPayload{"scp -t payload.bin"})); err != nil || !accepted {
+		t.Fatalf("exec request accepted=%v, err=%v", accepted, err)
+	}
+	scpUpload(t, test.channel, "C0644 5 local.bin\n", "hello\x00")
+	if err := test.channel.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	test.finish(t, 0)
+	if !strings.Contains(test.logs.String(), `[channel 0] scp upload of "/payload.bin" (mode 0644, 5 bytes, SHA-256 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824)`) {
+		t.Errorf("logs=%v, want the upload logged", test.logs.String())
+	}
+}
+
+func TestSCPEmptyMessage(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("exec", true, ssh.Marshal(execRequestPayload{"scp -t payload.bin"})); err != nil || !accepted {
+		t.Fatalf("exec request accepted=%v, err=%v", accepted, err)
+	}
+	scpUpload(t, test.channel)
+	if _, err := test.channel.Write([]byte("\n")); err != nil {
+		t.Fatal(err)
+	}
+	if output := test.finish(t, 1); output != "\x01scp: protocol error: unexpected <newline>\n" {
+		t.Errorf("output=%q, want a protocol error", output)
+	}
+}
+
+func TestSFTPSubsystem(t *testing.T) {
+	test := newSessionTest(t, &config{})
+	if accepted, err := test.channel.SendRequest("subsystem", true, ssh.Marshal(subsystemRequestPayload{"sftp"})); err != nil || !accepted {
+		t.Fatalf("subsystem request accepted=%v, err=%v", accepted, err)
+	}
+	client, err := sftp.NewClientPipe(test.channel, test.channel)
+	if err != nil {
+		t.Fatalf("Failed to start sftp client: %v", err)
+	}
+	files, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatalf("Failed to list /: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if !strings.Contains(strings.Join(names, " "), "pwd.txt") {
+		t.Errorf("names=%v, want pwd.txt", names)
+	}
+	file, err := client.Open("/pwd.txt")
+	if err != nil {
+		t.Fatalf("Failed to open pwd.txt: %v", err)
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Failed to read pwd.txt: %v", err)
+	}
+	file.Close()
+	if string(content) != FileSystem.lookup("/pwd.txt").Content {
+		t.Errorf("content=%q, want the seeded pwd.txt", content)
+	}
+	upload, err := client.Create("/tmp.sh")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := upload.Write([]byte("#!/bin/sh\n")); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	upload.Close()
+	if info, err := client.Stat("/tmp.sh"); err != nil || info.Size() != 10 {
+		t.Errorf("Stat(/tmp.sh)=%v, %v, want a 10 byte file", info, err)
+	}
+	if err := test.channel.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case status := <-test.exitStatus:
+		if status != 0 {
+			t.Errorf("exit status=%v, want 0", status)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for exit status")
+	}
+	client.Close()
+	if err := <-test.serverResult; err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`[channel 0] sftp List of "/" requested`,
+		`[channel 0] sftp Get of "/pwd.txt" requested`,
+		`[channel 0] sftp Put of "/tmp.sh" requested`,
+	} {
+		if !strings.Contains(test.logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %v", test.logs.String(), want)
+		}
+	}
+}
+
+func TestSFTPPermissions(t *testing.T) {
+	cfg := &config{}
+	setupLogBuffer(t, cfg)
+	handler := &sftpHandler{
+		context: &sessionContext{channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}}},
+		fs:      newSessionFileSystem(cfg.Shell),
+		user:    "guest",
+	}
+	handler.fs.addFile("/srv/secret", "hunter2").Mode = 0600
+	if _, err := handler.Fileread(sftp.NewRequest("Get", "/srv/secret")); err != sftp.ErrSSHFxPermissionDenied {
+		t.Errorf("Get: err=%v, want %v", err, sftp.ErrSSHFxPermissionDenied)
+	}
+	for _, file := range []string{"/srv/secret", "/srv/new"} {
+		if _, err := handler.Filewrite(sftp.NewRequest("Put", file)); err != sftp.ErrSSHFxPermissionDenied {
+			t.Errorf("Put %v: err=%v, want %v", file, err, sftp.ErrSSHFxPermissionDenied)
+		}
+	}
+	rename := sftp.NewRequest("Rename", "/srv/secret")
+	rename.Target = "/srv/moved"
+	for _, request := range []*sftp.Request{
+		sftp.NewRequest("Setstat", "/srv/secret"),
+		sftp.NewRequest("Mkdir", "/srv/dir"),
+		sftp.NewRequest("Remove", "/srv/secret"),
+		rename,
+	} {
+		if err := handler.Filecmd(request); err != sftp.ErrSSHFxPermissionDenied {
+			t.Errorf("%v: err=%v, want %v", request.Method, err, sftp.ErrSSHFxPermissionDenied)
+		}
+	}
+	if len(handler.fs.lookup("/srv").Children) != 1 || handler.fs.lookup("/srv/secret").Content != "hunter2" {
+		t.Errorf("/srv=%+v, want it unchanged", handler.fs.lookup("/srv").Children)
+	}
+}
+
+func TestSFTPRename(t *testing.T) {
+	cfg := &config{}
+	setupLogBuffer(t, cfg)
+	handler := &sftpHandler{
+		context: &sessionContext{channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}}},
+		fs:      newSessionFileSystem(cfg.Shell),
+		user:    "root",
+	}
+	handler.fs.addFile("/srv/a/b", "")
+	handler.fs.addFile("/srv/x", "payload")
+	handler.fs.addFile("/srv/y", "old")
+	nodes, bytes := handler.fs.nodes, handler.fs.bytes
+	rename := func(method, source, target string) error {
+		request := sftp.NewRequest(method, source)
+		request.Target = target
+		return handler.Filecmd(request)
+	}
+	if err := rename("Rename", "/srv/a", "/srv/a/c"); err != sftp.ErrSSHFxFailure {
+		t.Errorf("Rename into itself: err=%v, want %v", err, sftp.ErrSSHFxFailure)
+	}
+	if err := rename("Rename", "/srv/x", "/srv/y"); err != os.ErrExist {
+		t.Errorf("Rename onto a file: err=%v, want %v", err, os.ErrExist)
+	}
+	if err := rename("PosixRename", "/srv/x", "/srv/a"); err != sftp.ErrSSHFxFailure {
+		t.Errorf("PosixRename onto a directory: err=%v, want %v", err, sftp.ErrSSHFxFailure)
+	}
+	if err := rename("PosixRename", "/srv/x", "/srv/y"); err != nil {
+		t.Errorf("PosixRename onto a file: err=%v, want nil", err)
+	}
+	if node := handler.fs.lookup("/srv/y"); node == nil || node.Content != "payload" || handler.fs.lookup("/srv/x") != nil {
+		t.Errorf("/srv/y=%+v, want /srv/x moved there", node)
+	}
+	if handler.fs.nodes != nodes-1 || handler.fs.bytes != bytes-len("old") {
+		t.Errorf("nodes=%v, bytes=%v, want %v, %v", handler.fs.nodes, handler.fs.bytes, nodes-1, bytes-len("old"))
+	}
+}
+
+func TestSFTPLazyContent(t *testing.T) {
+	cfg := &config{}
+	setupLogBuffer(t, cfg)
+	handler := &sftpHandler{
+		context: &sessionContext{channelContext: channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}}},
+		fs:      newSessionFileSystem(cfg.Shell),
+		user:    "root",
+	}
+	var readers []*dumpReader
+	node := handler.fs.addFile("/root/dump.sql", "")
+	node.ContentReader = func() io.Reader {
+		readers = append(readers, &dumpReader{rows: 100000})
+		return readers[len(readers)-1]
+	}
+	node.ContentSize = 3388890
+	if size := (sftpFileInfo{node: node}).Size(); size != 3388890 || len(readers) != 0 {
+		t.Errorf("Size()=%v after %v reads, want the declared size without reading", size, len(readers))
+	}
+	readerAt, err := handler.Fileread(sftp.NewRequest("Get", "/root/dump.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 30)
+	for _, offset := range []int64{0, 30, 90, 0} {
+		if n, err := readerAt.ReadAt(buffer, offset); n != len(buffer) || err != nil {
+			t.Errorf("ReadAt(%v)=%v, %v, want a full read", offset, n, err)
+		}
+	}
+	if string(buffer) != "INSERT INTO users VALUES (0);\n" {
+		t.Errorf("ReadAt(0)=%q, want the first row", buffer)
+	}
+	if len(readers) != 2 || readers[0].read > 64*1024 {
+		t.Errorf("%v streams read, the first %v bytes, want the content streamed and reopened only to go back", len(readers), readers[0].read)
+	}
+	content, err := io.ReadAll(io.NewSectionReader(readerAt, 0, int64(node.ContentSize)+1))
+	if err != nil || len(content) != node.ContentSize {
+		t.Errorf("read %v bytes, err=%v, want all %v", len(content), err, node.ContentSize)
+	}
+}
+
+func TestSFTPWriteAtLimits(t *testing.T) {
+	fs := newSessionFileSystem(shellConfig{})
+	fs.maxBytes = fs.bytes + 10
+	node, err := fs.create(fs.Root, "payload.sh", false, "root")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	writer := &sftpFileWriter{handler: &sftpHandler{fs: fs}, node: node}
+	if n, err := writer.WriteAt([]byte("x"), -1); n != 0 || err == nil {
+		t.Errorf("WriteAt(-1)=%v, %v, want an error", n, err)
+	}
+	if n, err := writer.WriteAt([]byte("x"), 1<<40); n != 0 || err != errNoSpace {
+		t.Errorf("WriteAt(1<<40)=%v, %v, want %v", n, err, errNoSpace)
+	}
+	if n, err := writer.WriteAt([]byte("x"), 10); n != 0 || err != errNoSpace {
+		t.Errorf("WriteAt(10)=%v, %v, want %v", n, err, errNoSpace)
+	}
+	if n, err := writer.WriteAt([]byte("x"), 9); n != 1 || err != nil {
+		t.Errorf("WriteAt(9)=%v, %v, want 1, nil", n, err)
+	}
+	if node.Content != strings.Repeat("\x00", 9)+"x" {
+		t.Errorf("Content=%q, want the write padded with zeros", node.Content)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpHandler serves the sftp subsystem from a session's copy of the fake filesystem.
+type sftpHandler struct {
+	context *sessionContext
+	fs      *FileSystemType
+	user    string
+	modTime time.Time
+	// mutex guards fs, which the request server accesses concurrently.
+	mutex sync.Mutex
+}
+
+func (handler *sftpHandler) log(request *sftp.Request) {
+	handler.context.logEvent(sftpRequestLog{
+		channelLog: channelLog{ChannelID: handler.context.channelID},
+		Method:     request.Method,
+		Path:       request.Filepath,
+		Target:     request.Target,
+	})
+}
+
+func (handler *sftpHandler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	handler.log(request)
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	node := handler.fs.lookup(request.Filepath)
+	if node == nil {
+		return nil, os.ErrNotExist
+	}
+	if node.IsDir {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	if !node.canRead(handler.user) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	if node.Canary {
+		handler.context.logEvent(canaryLog{
+			channelLog: channelLog{ChannelID: handler.context.channelID},
+			Path:       request.Filepath,
+		})
+	}
+	if node.ContentReader != nil {
+		return &sftpStreamReader{open: node.ContentReader}, nil
+	}
+	return strings.NewReader(node.Content), nil
+}
+
+func (handler *sftpHandler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	handler.log(request)
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	dir, name := path.Split(request.Filepath)
+	parent := handler.fs.lookup(dir)
+	if parent == nil || !parent.IsDir {
+		return nil, os.ErrNotExist
+	}
+	node, exists := parent.Children[name]
+	if (exists && !node.canWrite(handler.user)) || (!exists && !parent.canWrite(handler.user)) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+	if !exists {
+		var err error
+		if node, err = handler.fs.create(parent, name, false, handler.user); err != nil {
+			return nil, err
+		}
+	}
+	if node.IsDir {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	if err := handler.fs.write(node, ""); err != nil {
+		return nil, err
+	}
+	return &sftpFileWriter{handler, node}, nil
+}
+
+func (handler *sftpHandler) Filecmd(request *sftp.Request) error {
+	handler.log(request)
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	switch request.Method {
+	case "Setstat":
+		if node := handler.fs.lookup(request.Filepath); node != nil && !node.canWrite(handler.user) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		return nil
+	case "Mkdir":
+		dir, name := path.Split(request.Filepath)
+		parent := handler.fs.lookup(dir)
+		if parent == nil || !parent.IsDir {
+			return os.ErrNotExist
+		}
+		if _, exists := parent.Children[name]; exists {
+			return os.ErrExist
+		}
+		if !parent.canWrite(handler.user) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		_, err := handler.fs.create(parent, name, true, handler.user)
+		return err
+	case "Remove", "Rmdir":
+		node := handler.fs.lookup(request.Filepath)
+		if node == nil || node.Parent == nil {
+			return os.ErrNotExist
+		}
+		if node.IsDir != (request.Method == "Rmdir") || len(node.Children) != 0 {
+			return sftp.ErrSSHFxFailure
+		}
+		if !node.Parent.canWrite(handler.user) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		delete(node.Parent.Children, path.Base(request.Filepath))
+		handler.fs.release(node)
+		return nil
+	case "Rename", "PosixRename":
+		node := handler.fs.lookup(request.Filepath)
+		dir, name := path.Split(request.Target)
+		parent := handler.fs.lookup(dir)
+		if node == nil || node.Parent == nil || parent == nil || !parent.IsDir {
+			return os.ErrNotExist
+		}
+		if !node.Parent.canWrite(handler.user) || !parent.canWrite(handler.user) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		for ancestor := parent; ancestor != nil; ancestor = ancestor.Parent {
+			if ancestor == node {
+				return sftp.ErrSSHFxFailure
+			}
+		}
+		// Only PosixRename replaces an existing target, as long as rename(2) would.
+		replaced, exists := parent.Children[name]
+		if replaced == node {
+			return nil
+		}
+		if exists {
+			if request.Method != "PosixRename" {
+				return os.ErrExist
+			}
+			if replaced.IsDir != node.IsDir || len(replaced.Children) != 0 {
+				return sftp.ErrSSHFxFailure
+			}
+			handler.fs.release(replaced)
+		}
+		delete(node.Parent.Children, path.Base(request.Filepath))
+		node.Parent = parent
+		parent.Children[name] = node
+		return nil
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// sftpStreamReader serves lazy content without holding it. Clients read files in order,
+// so the stream is only reopened if a read goes back.
+type sftpStreamReader struct {
+	open   func() io.Reader
+	mutex  sync.Mutex
+	reader io.Reader
+	offset int64
+}
+
+func (reader *sftpStreamReader) ReadAt(p []byte, offset int64) (int, error) {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.reader == nil || offset < reader.offset {
+		reader.reader, reader.offset = reader.open(), 0
+	}
+	if offset > reader.offset {
+		skipped, err := io.CopyN(io.Discard, reader.reader, offset-reader.offset)
+		reader.offset += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(reader.reader, p)
+	reader.offset += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (handler *sftpHandler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	handler.log(request)
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	node := handler.fs.lookup(request.Filepath)
+	if node == nil {
+		return nil, os.ErrNotExist
+	}
+	switch request.Method {
+	case "List":
+		if !node.IsDir {
+			return nil, sftp.ErrSSHFxFailure
+		}
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		files := make(sftpListerAt, len(names))
+		for i, name := range names {
+			files[i] = sftpFileInfo{name, node.Children[name], handler.modTime}
+		}
+		return files, nil
+	case "Stat", "Lstat":
+		return sftpListerAt{sftpFileInfo{path.Base(request.Filepath), node, handler.modTime}}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// sftpFileWriter writes uploaded data to a file, within the filesystem limits.
+type sftpFileWriter struct {
+	handler *sftpHandler
+	node    *FileSystemNode
+}
+
+func (writer *sftpFileWriter) WriteAt(p []byte, offset int64) (int, error) {
+	writer.handler.mutex.Lock()
+	defer writer.handler.mutex.Unlock()
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	content := writer.node.content()
+	// Check the limits before padding, so a huge offset can't allocate more than the filesystem allows
+	end := offset + int64(len(p))
+	if end > int64(len(content)) {
+		fs := writer.handler.fs
+		if end > maxSCPFileSize || (fs.maxBytes > 0 && fs.bytes+int(end)-len(writer.node.Content) > fs.maxBytes) {
+			return 0, errNoSpace
+		}
+		content += strings.Repeat("\x00", int(end)-len(content))
+	}
+	content = content[:offset] + string(p) + content[int(offset)+len(p):]
+	if err := writer.handler.fs.write(writer.node, content); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type sftpListerAt []os.FileInfo
+
+func (files sftpListerAt) ListAt(list []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(files)) {
+		return 0, io.EOF
+	}
+	n := copy(list, files[offset:])
+	if n < len(list) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type sftpFileInfo struct {
+	name    string
+	node    *FileSystemNode
+	modTime time.Time
+}
+
+func (info sftpFileInfo) Name() string       { return info.name }
+func (info sftpFileInfo) Size() int64        { return int64(info.node.size()) }
+func (info sftpFileInfo) ModTime() time.Time { return info.modTime }
+func (info sftpFileInfo) IsDir() bool        { return info.node.IsDir }
+func (info sftpFileInfo) Sys() interface{}   { return nil }
+
+func (info sftpFileInfo) Mode() os.FileMode {
+	if info.node.IsDir {
+		return info.node.fileMode() | os.ModeDir
+	}
+	return info.node.fileMode()
+}
+
+// handleSFTP serves the sftp subsystem on the session channel until the client closes it.
+func (context *sessionContext) handleSFTP() {
+	handler := &sftpHandler{
+		context: context,
+		fs:      newSessionFileSystem(context.cfg.Shell),
+		user:    context.User(),
+		modTime: context.cfg.clock.boot(),
+	}
+	server := sftp.NewRequestServer(context.Channel, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	})
+	go func() {
+		defer close(context.inputChan)
+		if err := server.Serve(); err != nil && err != io.EOF {
+			warningLogger.Printf("Error serving sftp: %v", err)
+		}
+		if _, err := context.SendRequest("exit-status", false, ssh.Marshal(struct {
+			ExitStatus uint32
+		}{0})); err != nil {
+			warningLogger.Printf("Error sending exit status: %s", err)
+			return
+		}
+		if err := context.Close(); err != nil {
+			warningLogger.Printf("Error closing channel: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var sleepUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+}
+
+// parseSleepInterval parses an interval such as "5", "0.5s" or "1m".
+func parseSleepInterval(arg string) (time.Duration, bool) {
+	unit := time.Second
+	number := arg
+	if len(arg) > 0 {
+		if u, ok := sleepUnits[arg[len(arg)-1]]; ok {
+			unit = u
+			number = arg[:len(arg)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || value < 0 {
+		return 0, false
+	}
+	if nanoseconds := value * float64(unit); nanoseconds < math.MaxInt64 {
+		return time.Duration(nanoseconds), true
+	}
+	return math.MaxInt64, true
+}
+
+func init() {
+	registerCommand("sleep", cmdSleep{})
+}
+
+type cmdSleep struct{}
+
+func (cmdSleep) execute(context commandContext) (uint32, error) {
+	if len(context.args) < 2 {
+		_, err := fmt.Fprint(context.stderr, "sleep: missing operand\nTry 'sleep --help' for more information.\n")
+		return 1, err
+	}
+	var duration time.Duration
+	for _, arg := range context.args[1:] {
+		interval, ok := parseSleepInterval(arg)
+		if !ok {
+			_, err := fmt.Fprintf(context.stderr, "sleep: invalid time interval '%v'\nTry 'sleep --help' for more information.\n", arg)
+			return 1, err
+		}
+		if duration += interval; duration < 0 {
+			duration = math.MaxInt64
+		}
+	}
+	context.logEvent(sleepLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Duration:   duration.String(),
+	})
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return 0, nil
+	case <-context.done:
+		// The session is gone, so the status is only what a hung up process would report.
+		return 129, nil
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/ssh"
+)
+
+var passwordSpraysMetric = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "sshesame_password_sprays_detected_total",
+	Help: "Total number of password sprays detected",
+})
+
+const (
+	defaultSprayWindow       = 10 * time.Minute
+	defaultSprayMaxPasswords = 2
+)
+
+// maxSpraySources bounds the number of source IPs tracked, so a wide scan can't grow the detector without limit.
+const maxSpraySources = 10000
+
+// spraySource is what's known about the recent attempts of a source IP: when each user and password was
+// last tried. Only the most recent entries that can affect detection are kept: threshold users, and one
+// password more than the maximum.
+type spraySource struct {
+	users     map[string]time.Time
+	passwords map[string]time.Time
+	last      time.Time
+	reported  bool
+}
+
+// sprayDetector tracks the password attempts of every source IP over a sliding window,
+// reporting IPs trying many users with few passwords.
+type sprayDetector struct {
+	threshold    int
+	maxPasswords int
+	window       time.Duration
+	now          func() time.Time
+	mutex        sync.Mutex
+	sources      map[string]*spraySource
+	lastSweep    time.Time
+}
+
+// newSprayDetector returns nil if spray detection is disabled.
+func newSprayDetector(cfg passwordSprayConfig) *sprayDetector {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	detector := &sprayDetector{
+		threshold:    cfg.Threshold,
+		maxPasswords: cfg.MaxPasswords,
+		window:       cfg.Window,
+		now:          time.Now,
+		sources:      map[string]*spraySource{},
+	}
+	if detector.maxPasswords <= 0 {
+		detector.maxPasswords = defaultSprayMaxPasswords
+	}
+	if detector.window <= 0 {
+		detector.window = defaultSprayWindow
+	}
+	return detector
+}
+
+// pruneEntries drops the entries older than the window, then the oldest ones above limit.
+func (detector *sprayDetector) pruneEntries(entries map[string]time.Time, limit int, now time.Time) {
+	for key, tried := range entries {
+		if now.Sub(tried) >= detector.window {
+			delete(entries, key)
+		}
+	}
+	for len(entries) > limit {
+		var oldest string
+		for key, tried := range entries {
+			if oldest == "" || tried.Before(entries[oldest]) {
+				oldest = key
+			}
+		}
+		delete(entries, oldest)
+	}
+}
+
+// prune drops what source tried before the window.
+func (detector *sprayDetector) prune(source *spraySource, now time.Time) {
+	detector.pruneEntries(source.users, detector.threshold, now)
+	detector.pruneEntries(source.passwords, detector.maxPasswords+1, now)
+}
+
+// record adds an attempt from ip, returning the number of distinct users and passwords in the window
+// and whether they make up a spray that wasn't reported yet.
+func (detector *sprayDetector) record(ip, user, password string) (int, int, bool) {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	now := detector.now()
+	if now.Sub(detector.lastSweep) >= detector.window {
+		detector.sweep(now)
+	}
+	source := detector.sources[ip]
+	if source == nil {
+		if len(detector.sources) >= maxSpraySources {
+			detector.sweep(now)
+			detector.evictOldest()
+		}
+		source = &spraySource{users: map[string]time.Time{}, passwords: map[string]time.Time{}}
+		detector.sources[ip] = source
+	}
+	if now.Sub(source.last) >= detector.window {
+		source.reported = false
+	}
+	source.users[user] = now
+	source.passwords[password] = now
+	source.last = now
+	detector.prune(source, now)
+	users, passwords := len(source.users), len(source.passwords)
+	if source.reported || users < detector.threshold || passwords > detector.maxPasswords {
+		return users, passwords, false
+	}
+	source.reported = true
+	return users, passwords, true
+}
+
+// sweep evicts sources without attempts in the window.
+func (detector *sprayDetector) sweep(now time.Time) {
+	for ip, source := range detector.sources {
+		if now.Sub(source.last) >= detector.window {
+			delete(detector.sources, ip)
+		}
+	}
+	detector.lastSweep = now
+}
+
+// evictOldest makes room for a new source if there are still too many, dropping the one that was quiet the longest.
+func (detector *sprayDetector) evictOldest() {
+	if len(detector.sources) < maxSpraySources {
+		return
+	}
+	var oldest string
+	for ip, source := range detector.sources {
+		if oldest == "" || source.last.Before(detector.sources[oldest].last) {
+			oldest = ip
+		}
+	}
+	delete(detector.sources, oldest)
+}
+
+// check records a password attempt on conn, logging a spray if one is detected.
+// A nil detector does nothing.
+func (detector *sprayDetector) check(conn ssh.ConnMetadata, cfg *config, password string) {
+	if detector == nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	users, passwords, detected := detector.record(host, conn.User(), password)
+	if !detected {
+		return
+	}
+	passwordSpraysMetric.Inc()
+	connContext{ConnMetadata: conn, cfg: cfg}.logEvent(passwordSprayLog{
+		Users:     users,
+		Passwords: passwords,
+		Window:    detector.window.String(),
+	})
+}
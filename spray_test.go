@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockUserConnContext struct {
+	mockConnContext
+	user string
+}
+
+func (context mockUserConnContext) User() string {
+	return context.user
+}
+
+func TestPasswordSpray(t *testing.T) {
+	cfg := &config{}
+	cfg.Auth.PasswordAuth.Enabled = true
+	cfg.Auth.PasswordSpray = passwordSprayConfig{Threshold: 3}
+	cfg.spray = newSprayDetector(cfg.Auth.PasswordSpray)
+	now := time.Unix(0, 0)
+	cfg.spray.now = func() time.Time { return now }
+	logs := setupLogBuffer(t, cfg)
+	callback := cfg.getPasswordCallback()
+
+	// Brute forcing a single user isn't spraying
+	for _, password := range []string{"123456", "password", "qwerty", "letmein"} {
+		callback(mockUserConnContext{user: "root"}, []byte(password))
+	}
+	for _, user := range []string{"admin", "oracle", "postgres", "ubuntu"} {
+		callback(mockUserConnContext{user: user}, []byte("123456"))
+	}
+	if strings.Contains(logs.String(), "password spray detected") {
+		t.Errorf("logs=%v, want no spray detected with many passwords", logs.String())
+	}
+
+	now = now.Add(defaultSprayWindow)
+	for _, user := range []string{"admin", "oracle", "postgres", "ubuntu"} {
+		callback(mockUserConnContext{user: user}, []byte("123456"))
+	}
+	expectedLog := "[127.0.0.1:1234] password spray detected: 3 users tried with 1 passwords within 10m0s\n"
+	if strings.Count(logs.String(), "password spray detected") != 1 || !strings.Contains(logs.String(), expectedLog) {
+		t.Errorf("logs=%v, want a single %q", logs.String(), expectedLog)
+	}
+
+	now = now.Add(defaultSprayWindow)
+	callback(mockUserConnContext{user: "root"}, []byte("123456"))
+	if len(cfg.spray.sources) != 1 || len(cfg.spray.sources["127.0.0.1"].users) != 1 {
+		t.Errorf("sources=%v, want old attempts to be evicted", cfg.spray.sources)
+	}
+}
+
+func TestPasswordSprayBounds(t *testing.T) {
+	detector := newSprayDetector(passwordSprayConfig{Threshold: 3})
+	now := time.Unix(0, 0)
+	detector.now = func() time.Time { return now }
+	for i := 0; i < 100; i++ {
+		detector.record("192.0.2.1", fmt.Sprint("user", i), fmt.Sprint("password", i))
+	}
+	if source := detector.sources["192.0.2.1"]; len(source.users) != 3 || len(source.passwords) != 3 {
+		t.Errorf("users=%v, passwords=%v, want only what affects detection kept", len(source.users), len(source.passwords))
+	}
+	for i := 0; i < maxSpraySources+10; i++ {
+		now = now.Add(time.Millisecond)
+		detector.record(fmt.Sprint("ip", i), "root", "123456")
+	}
+	if len(detector.sources) != maxSpraySources {
+		t.Errorf("sources=%v, want %v", len(detector.sources), maxSpraySources)
+	}
+	if detector.sources["ip0"] != nil || detector.sources[fmt.Sprint("ip", maxSpraySources+9)] == nil {
+		t.Errorf("want the quietest sources evicted")
+	}
+}
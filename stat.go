@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// passwdIDs returns the user and group IDs of name in the configured /etc/passwd.
+// Unlisted users get the IDs useradd would give the first regular user.
+func passwdIDs(passwd, name string) (uid, gid int) {
+	for _, line := range strings.Split(passwd, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || fields[0] != name {
+			continue
+		}
+		uid, uidErr := strconv.Atoi(fields[2])
+		gid, gidErr := strconv.Atoi(fields[3])
+		if uidErr == nil && gidErr == nil {
+			return uid, gid
+		}
+	}
+	if name == "root" {
+		return 0, 0
+	}
+	return 1000, 1000
+}
+
+const statTimeFormat = "2006-01-02 15:04:05.000000000 -0700"
+
+func init() {
+	registerCommand("stat", cmdStat{})
+}
+
+type cmdStat struct{}
+
+func (cmdStat) execute(context commandContext) (uint32, error) {
+	if len(context.args) < 2 {
+		_, err := fmt.Fprint(context.stderr, "stat: missing operand\nTry 'stat --help' for more information.\n")
+		return 1, err
+	}
+	now := context.cfg.clock.now()
+	var status uint32
+	for _, path := range context.args[1:] {
+		node := context.state.fs.lookup(path)
+		if node == nil {
+			if _, err := fmt.Fprintf(context.stderr, "stat: cannot stat '%v': No such file or directory\n", path); err != nil {
+				return 1, err
+			}
+			status = 1
+			continue
+		}
+		size, links, fileType := node.size(), 1, "regular file"
+		switch {
+		case node.IsDir:
+			size, links, fileType = 4096, 2, "directory"
+			for _, child := range node.Children {
+				if child.IsDir {
+					links++
+				}
+			}
+		case size == 0:
+			fileType = "regular empty file"
+		}
+		// The inode and timestamps are derived from the path so they stay the same across runs.
+		hash := fnv.New64a()
+		hash.Write([]byte(context.state.fs.absPath(path)))
+		seed := hash.Sum64()
+		modified := now.Add(-time.Duration(seed%(90*24*3600)) * time.Second).Truncate(time.Second)
+		changed := modified.Add(time.Duration(seed%1000000000) * time.Nanosecond)
+		accessed := changed.Add(time.Duration(seed%(7*24*3600)) * time.Second)
+		if accessed.After(now) {
+			accessed = now
+		}
+		uid, _ := passwdIDs(context.cfg.Shell.Etc.Passwd, node.owner())
+		_, gid := passwdIDs(context.cfg.Shell.Etc.Passwd, node.group())
+		if _, err := fmt.Fprintf(context.stdout, `  File: %v
+  Size: %-10v	Blocks: %-10v IO Block: 4096   %v
+Device: 801h/2049d	Inode: %-11v Links: %v
+Access: (%04o/%v)  Uid: (%5v/%8v)   Gid: (%5v/%8v)
+Access: %v
+Modify: %v
+Change: %v
+ Birth: -
+`, path, size, (size+4095)/4096*8, fileType, seed%1000000+131072, links, node.Mode.Perm(), node.fileMode(), uid, node.owner(), gid, node.group(),
+			accessed.Format(statTimeFormat), modified.Format(statTimeFormat), changed.Format(statTimeFormat)); err != nil {
+			return 1, err
+		}
+	}
+	return status, nil
+}
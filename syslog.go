@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverityInfo is the severity activity logs are sent with.
+const syslogSeverityInfo = 6
+
+// syslogWriter sends every write as an RFC 5424 message, framed with its length over stream connections.
+type syslogWriter struct {
+	conn     net.Conn
+	priority int
+	hostname string
+	stream   bool
+}
+
+func dialSyslog(cfg syslogConfig) (*syslogWriter, error) {
+	facility := cfg.Facility
+	if facility == "" {
+		facility = "user"
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	network := cfg.Network
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := net.DialTimeout(network, cfg.Address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogWriter{
+		conn:     conn,
+		priority: code*8 + syslogSeverityInfo,
+		hostname: hostname,
+		stream:   network == "tcp" || network == "tcp4" || network == "tcp6" || network == "unix",
+	}, nil
+}
+
+func (writer *syslogWriter) Write(p []byte) (int, error) {
+	message := fmt.Sprintf("<%v>1 %v %v sshesame %v - - %v", writer.priority, time.Now().Format("2006-01-02T15:04:05.000000Z07:00"), writer.hostname, os.Getpid(), strings.TrimSuffix(string(p), "\n"))
+	if writer.stream {
+		message = fmt.Sprintf("%v %v", len(message), message)
+	}
+	if _, err := writer.conn.Write([]byte(message)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (writer *syslogWriter) Close() error {
+	return writer.conn.Close()
+}
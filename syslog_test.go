@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cfg := &config{}
+	cfg.Logging.JSON = true
+	cfg.Logging.Syslog = syslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0"}
+	if err := cfg.setupLogging(); err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+	t.Cleanup(func() {
+		cfg.syslogHandle.Close()
+		log.SetOutput(os.Stdout)
+	})
+	context := connContext{ConnMetadata: mockConnContext{}, cfg: cfg}
+	context.logEvent(passwordAuthLog{authLog: authLog{User: "root", Accepted: true}, Password: "hunter2"})
+
+	buffer := make([]byte, 65536)
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Failed to receive syslog message: %v", err)
+	}
+	message := string(buffer[:n])
+	if !strings.HasPrefix(message, "<134>1 ") {
+		t.Errorf("message=%q, want a local0 info RFC 5424 message", message)
+	}
+	if !strings.HasSuffix(message, ` - - {"source":"127.0.0.1:1234","event_type":"password_auth","event":{"user":"root","accepted":true,"password":"hunter2"}}`) {
+		t.Errorf("message=%q, want the password_auth event", message)
+	}
+}
+
+func TestSyslogDialFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	cfg := &config{}
+	cfg.Logging.Syslog = syslogConfig{Network: "tcp", Address: address}
+	if err := cfg.setupLogging(); err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+	t.Cleanup(func() { log.SetOutput(os.Stdout) })
+	if log.Writer() != os.Stderr {
+		t.Errorf("log.Writer()=%v, want standard error", log.Writer())
+	}
+	if cfg.syslogHandle != nil {
+		t.Errorf("syslogHandle=%v, want nil", cfg.syslogHandle)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// tarBlockSize is the size of the smallest tar archive, which placeholder archives are padded to.
+const tarBlockSize = 10240
+
+type cmdTar struct{}
+
+// archiveMembers lists a file or directory tree the way tar adds it to an archive.
+func archiveMembers(node *FileSystemNode, name string) []string {
+	if !node.IsDir {
+		return []string{name}
+	}
+	name = strings.TrimSuffix(name, "/") + "/"
+	members := []string{name}
+	var names []string
+	for childName := range node.Children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+	for _, childName := range names {
+		members = append(members, archiveMembers(node.Children[childName], name+childName)...)
+	}
+	return members
+}
+
+// fabricatedMembers makes up the contents of an archive sshesame didn't create, based on its name.
+func fabricatedMembers(archive string) []string {
+	name := path.Base(archive)
+	for _, extension := range []string{".gz", ".tgz", ".bz2", ".xz", ".tar"} {
+		name = strings.TrimSuffix(name, extension)
+	}
+	return []string{name + "/", name + "/install.sh", name + "/config.json", name + "/README"}
+}
+
+// stripMemberName removes what GNU tar does from a member name so it stays inside the directory it's
+// extracted to: leading slashes and everything up to the last ".." component. The removed prefix is returned too.
+func stripMemberName(name string) (string, string) {
+	stripped := name
+	parts := strings.Split(name, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == ".." {
+			stripped = strings.Join(parts[i+1:], "/")
+			break
+		}
+	}
+	stripped = strings.TrimLeft(stripped, "/")
+	return stripped, name[:len(name)-len(stripped)]
+}
+
+// extractMember creates an empty placeholder for an archive member, along with its parent directories.
+func extractMember(context commandContext, directory *FileSystemNode, member string) error {
+	node := directory
+	parts := strings.Split(strings.TrimSuffix(member, "/"), "/")
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		isDir := i < len(parts)-1 || strings.HasSuffix(member, "/")
+		child, exists := node.Children[part]
+		if !exists && !node.canWrite(context.user) {
+			return errors.New("Cannot open: Permission denied")
+		}
+		if !exists {
+			var err error
+			if child, err = context.state.fs.create(node, part, isDir, context.user); err != nil {
+				return err
+			}
+		}
+		if isDir && !child.IsDir {
+			return fmt.Errorf("Cannot mkdir: Not a directory")
+		}
+		node = child
+	}
+	return nil
+}
+
+func (cmdTar) execute(context commandContext) (uint32, error) {
+	var mode byte
+	var archive, directory string
+	verbose := false
+	var members []string
+	args := context.args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if i > 0 && !strings.HasPrefix(arg, "-") {
+			members = append(members, arg)
+			continue
+		}
+		switch {
+		case arg == "-C" || arg == "--directory":
+			if i+1 < len(args) {
+				i++
+				directory = args[i]
+			}
+			continue
+		case strings.HasPrefix(arg, "--file="):
+			archive = strings.TrimPrefix(arg, "--file=")
+			continue
+		case strings.HasPrefix(arg, "--"):
+			switch arg {
+			case "--create":
+				mode = 'c'
+			case "--extract", "--get":
+				mode = 'x'
+			case "--list":
+				mode = 't'
+			case "--verbose":
+				verbose = true
+			}
+			continue
+		}
+		for j := len(arg) - len(strings.TrimPrefix(arg, "-")); j < len(arg); j++ {
+			switch arg[j] {
+			case 'c', 'x', 't':
+				mode = arg[j]
+			case 'v':
+				verbose = true
+			case 'f':
+				if j+1 < len(arg) {
+					archive = arg[j+1:]
+				} else if i+1 < len(args) {
+					i++
+					archive = args[i]
+				}
+				j = len(arg)
+			}
+		}
+	}
+	if mode == 0 {
+		_, err := fmt.Fprintln(context.stderr, "tar: You must specify one of the '-Acdtrux', '--delete' or '--test-label' options\nTry 'tar --help' or 'tar --usage' for more information.")
+		return 2, err
+	}
+	if archive == "" {
+		_, err := fmt.Fprintln(context.stderr, "tar: Refusing to read archive contents from terminal (missing -f option?)\ntar: Error is not recoverable: exiting now")
+		return 2, err
+	}
+
+	var status uint32
+	var output []string
+	removed := map[string]bool{}
+	// stripMember strips a member name, warning about each prefix removed once like GNU tar.
+	stripMember := func(member string) string {
+		name, prefix := stripMemberName(member)
+		if prefix != "" && !removed[prefix] {
+			removed[prefix] = true
+			output = append(output, fmt.Sprintf("tar: Removing leading `%v' from member names", prefix))
+		}
+		return name
+	}
+	operation := map[byte]string{'c': "create", 'x': "extract", 't': "list"}[mode]
+	if mode == 'c' {
+		if len(members) == 0 {
+			_, err := fmt.Fprintln(context.stderr, "tar: Cowardly refusing to create an empty archive\nTry 'tar --help' or 'tar --usage' for more information.")
+			return 2, err
+		}
+		var files []string
+		for _, member := range members {
+			node := context.state.fs.lookup(member)
+			if node == nil {
+				output = append(output, fmt.Sprintf("tar: %v: Cannot stat: No such file or directory", member))
+				status = 2
+				continue
+			}
+			name := stripMember(member)
+			if name == "" {
+				name = "."
+			}
+			files = append(files, archiveMembers(node, name)...)
+		}
+		members = files
+	} else {
+		node := context.state.fs.lookup(archive)
+		if node == nil || node.IsDir {
+			_, err := fmt.Fprintf(context.stderr, "tar: %v: Cannot open: No such file or directory\ntar: Error is not recoverable: exiting now\n", archive)
+			return 2, err
+		}
+		if archived, ok := context.state.archives[node]; ok {
+			members = archived
+		} else {
+			members = fabricatedMembers(archive)
+		}
+	}
+	context.logEvent(tarLog{
+		channelLog: channelLog{ChannelID: context.channelID},
+		Operation:  operation,
+		Archive:    archive,
+		Files:      members,
+	})
+
+	switch mode {
+	case 'c':
+		dir, name := path.Split(archive)
+		parent := context.state.fs.lookup(dir)
+		if parent == nil || !parent.IsDir {
+			return 2, printTarErrors(context, append(output, fmt.Sprintf("tar: %v: Cannot open: No such file or directory", archive), "tar: Error is not recoverable: exiting now"))
+		}
+		node, exists := parent.Children[name]
+		if (exists && !node.canWrite(context.user)) || (!exists && !parent.canWrite(context.user)) {
+			return 2, printTarErrors(context, append(output, fmt.Sprintf("tar: %v: Cannot open: Permission denied", archive), "tar: Error is not recoverable: exiting now"))
+		}
+		if !exists {
+			var err error
+			if node, err = context.state.fs.create(parent, name, false, context.user); err != nil {
+				return 2, printTarErrors(context, append(output, fmt.Sprintf("tar: %v: Cannot open: %v", archive, err), "tar: Error is not recoverable: exiting now"))
+			}
+		}
+		if err := context.state.fs.write(node, strings.Repeat("\x00", tarBlockSize)); err != nil {
+			return 2, printTarErrors(context, append(output, fmt.Sprintf("tar: %v: Cannot write: %v", archive, err), "tar: Error is not recoverable: exiting now"))
+		}
+		if context.state.archives == nil {
+			context.state.archives = map[*FileSystemNode][]string{}
+		}
+		context.state.archives[node] = members
+	case 'x':
+		target := context.state.fs.Current
+		if directory != "" {
+			if target = context.state.fs.lookup(directory); target == nil || !target.IsDir {
+				_, err := fmt.Fprintf(context.stderr, "tar: %v: Cannot open: No such file or directory\ntar: Error is not recoverable: exiting now\n", directory)
+				return 2, err
+			}
+		}
+		for _, member := range members {
+			if member = stripMember(member); member == "" {
+				continue
+			}
+			if err := extractMember(context, target, member); err != nil {
+				output = append(output, fmt.Sprintf("tar: %v: %v", member, err))
+				status = 2
+			}
+		}
+	}
+	if mode == 't' || verbose {
+		if _, err := fmt.Fprintln(context.stdout, strings.Join(members, "\n")); err != nil {
+			return status, err
+		}
+	}
+	if status != 0 {
+		output = append(output, "tar: Exiting with failure status due to previous errors")
+	}
+	return status, printTarErrors(context, output)
+}
+
+func printTarErrors(context commandContext, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintln(context.stderr, strings.Join(lines, "\n"))
+	return err
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -15,13 +20,14 @@ import (
 )
 
 type tcpipServer interface {
-	serve(readWriter io.ReadWriter, input chan<- string)
+	serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext)
 }
 
 var servers = map[string]tcpipServer{
-	"SMTP": smtpServer{},
-	"HTTP": httpServer{},
-	"POP3": pop3Server{},
+	"SMTP":  smtpServer{},
+	"HTTP":  httpServer{},
+	"HTTPS": tlsServer{},
+	"POP3":  pop3Server{},
 }
 
 type tcpipChannelData struct {
@@ -31,6 +37,30 @@ type tcpipChannelData struct {
 	OriginatorPort    uint32
 }
 
+func (data tcpipChannelData) String() string {
+	return fmt.Sprintf("%v -> %v", net.JoinHostPort(normalizeHost(data.OriginatorAddress), fmt.Sprint(data.OriginatorPort)), net.JoinHostPort(normalizeHost(data.Address), fmt.Sprint(data.Port)))
+}
+
+// originatorIP returns the normalized originator address, or an empty string if it's not an IP address.
+func (data tcpipChannelData) originatorIP() string {
+	if ip := net.ParseIP(normalizeHost(data.OriginatorAddress)); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// normalizeHost strips the brackets around an IPv6 address and formats IP addresses canonically,
+// leaving host names as they are.
+func normalizeHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
 var (
 	tcpipChannelsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "sshesame_tcpip_channels_total",
@@ -52,10 +82,13 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, context channelContext)
 		return err
 	}
 	service := context.cfg.Server.TCPIPServices[channelData.Port]
-	server := servers[service]
+	if _, ok := context.cfg.Server.TCPIPProxy.Backends[channelData.Port]; ok {
+		service = "proxy"
+	}
+	server := context.cfg.tcpipServers[channelData.Port]
 	if server == nil {
 		tcpipChannelsMetric.WithLabelValues("unknown").Inc()
-		warningLogger.Printf("Unsupported port %v", channelData.Port)
+		warningLogger.Printf("Unsupported port %v (%v)", channelData.Port, channelData)
 		return newChannel.Reject(ssh.ConnectionFailed, "Connection refused")
 	}
 	tcpipChannelsMetric.WithLabelValues(service).Inc()
@@ -65,23 +98,36 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, context channelContext)
 	if err != nil {
 		return err
 	}
+	forward := forwardLog{
+		channelLog:   channelLog{ChannelID: context.channelID},
+		ConnectionID: context.connectionID(),
+		ForwardIndex: context.forwardIndex,
+	}
 	context.logEvent(directTCPIPLog{
-		channelLog: channelLog{
-			ChannelID: context.channelID,
-		},
-		From: getAddressLog(channelData.OriginatorAddress, int(channelData.OriginatorPort), context.cfg),
-		To:   getAddressLog(channelData.Address, int(channelData.Port), context.cfg),
-	})
-	defer context.logEvent(directTCPIPCloseLog{
-		channelLog: channelLog{
-			ChannelID: context.channelID,
-		},
+		forwardLog:     forward,
+		From:           getAddressLog(normalizeHost(channelData.OriginatorAddress), int(channelData.OriginatorPort), context.cfg),
+		To:             getAddressLog(normalizeHost(channelData.Address), int(channelData.Port), context.cfg),
+		OriginatorIP:   channelData.originatorIP(),
+		OriginatorPort: channelData.OriginatorPort,
 	})
+	counter := &countingReadWriter{ReadWriter: channel}
+	numRequests := 0
+	defer func() {
+		context.logEvent(directTCPIPCloseLog{
+			forwardLog:    forward,
+			Service:       service,
+			Requests:      numRequests,
+			BytesReceived: atomic.LoadInt64(&counter.bytesRead),
+			BytesSent:     atomic.LoadInt64(&counter.bytesWritten),
+		})
+	}()
 
 	inputChan := make(chan string)
+	outputChan := make(chan string)
 	go func() {
 		defer close(inputChan)
-		server.serve(channel, inputChan)
+		defer close(outputChan)
+		server.serve(counter, inputChan, outputChan, context)
 		if err := channel.CloseWrite(); err != nil {
 			warningLogger.Printf("Error sending EOF to channel: %v", err)
 			return
@@ -92,18 +138,26 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, context channelContext)
 		}
 	}()
 
-	for inputChan != nil || requests != nil {
+	for inputChan != nil || outputChan != nil || requests != nil {
 		select {
 		case input, ok := <-inputChan:
 			if !ok {
 				inputChan = nil
 				continue
 			}
+			numRequests++
 			context.logEvent(directTCPIPInputLog{
-				channelLog: channelLog{
-					ChannelID: context.channelID,
-				},
-				Input: input,
+				forwardLog: forward,
+				Input:      input,
+			})
+		case output, ok := <-outputChan:
+			if !ok {
+				outputChan = nil
+				continue
+			}
+			context.logEvent(directTCPIPOutputLog{
+				forwardLog: forward,
+				Output:     output,
 			})
 		case request, ok := <-requests:
 			if !ok {
@@ -129,22 +183,109 @@ func handleDirectTCPIPChannel(newChannel ssh.NewChannel, context channelContext)
 	return nil
 }
 
+// handleForwardedTCPIPChannel accepts forwarded-tcpip channels opened by clients probing for relays, logging what they send.
+func handleForwardedTCPIPChannel(newChannel ssh.NewChannel, context channelContext) error {
+	channelData := &tcpipChannelData{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), channelData); err != nil {
+		return err
+	}
+	tcpipChannelsMetric.WithLabelValues("forwarded").Inc()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	context.logEvent(forwardedTCPIPLog{
+		channelLog:     channelLog{ChannelID: context.channelID},
+		Connected:      getAddressLog(normalizeHost(channelData.Address), int(channelData.Port), context.cfg),
+		Originator:     getAddressLog(normalizeHost(channelData.OriginatorAddress), int(channelData.OriginatorPort), context.cfg),
+		OriginatorIP:   channelData.originatorIP(),
+		OriginatorPort: channelData.OriginatorPort,
+	})
+	buffer := make([]byte, 4096)
+	for {
+		n, err := channel.Read(buffer)
+		if n > 0 {
+			context.logEvent(forwardedTCPIPInputLog{
+				channelLog: channelLog{ChannelID: context.channelID},
+				Input:      string(buffer[:n]),
+			})
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// countingReadWriter counts the bytes passing through a forwarded channel.
+type countingReadWriter struct {
+	io.ReadWriter
+	bytesRead, bytesWritten int64
+}
+
+func (rw *countingReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(p)
+	atomic.AddInt64(&rw.bytesRead, int64(n))
+	return n, err
+}
+
+func (rw *countingReadWriter) Write(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Write(p)
+	atomic.AddInt64(&rw.bytesWritten, int64(n))
+	return n, err
+}
+
 type httpServer struct{}
 
-func (server httpServer) serve(readWriter io.ReadWriter, input chan<- string) {
-	for {
-		request, err := http.ReadRequest(bufio.NewReader(readWriter))
+var errHTTPRequestTimeout = errors.New("timed out waiting for request")
+
+func (httpServer) readRequest(reader *bufio.Reader) ([]byte, error) {
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, err
+	}
+	return httputil.DumpRequest(request, true)
+}
+
+type httpRequestResult struct {
+	requestBytes []byte
+	err          error
+}
+
+// readRequestTimeout reads a request, giving up after timeout if it's positive.
+// The pending read is abandoned and returns once the channel is closed.
+func (server httpServer) readRequestTimeout(reader *bufio.Reader, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		return server.readRequest(reader)
+	}
+	result := make(chan httpRequestResult, 1)
+	go func() {
+		requestBytes, err := server.readRequest(reader)
+		result <- httpRequestResult{requestBytes, err}
+	}()
+	select {
+	case r := <-result:
+		return r.requestBytes, r.err
+	case <-time.After(timeout):
+		return nil, errHTTPRequestTimeout
+	}
+}
+
+func (server httpServer) serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext) {
+	maxRequests := context.cfg.Server.HTTP.MaxRequests
+	reader := bufio.NewReader(readWriter)
+	for requests := 0; maxRequests <= 0 || requests < maxRequests; requests++ {
+		requestBytes, err := server.readRequestTimeout(reader, context.cfg.Server.HTTP.ReadTimeout)
 		if err != nil {
 			if err != io.EOF {
 				warningLogger.Printf("Error reading request: %v", err)
 			}
 			return
 		}
-		requestBytes, err := httputil.DumpRequest(request, true)
-		if err != nil {
-			warningLogger.Printf("Error dumping request: %v", err)
-			return
-		}
 		input <- string(requestBytes)
 		response := &http.Response{
 			StatusCode: 404,
@@ -161,6 +302,56 @@ func (server httpServer) serve(readWriter io.ReadWriter, input chan<- string) {
 			warningLogger.Printf("Error writing response: %v", err)
 			return
 		}
+		output <- string(responseBytes)
+	}
+}
+
+// channelConn adapts a channel to a net.Conn so it can be wrapped by crypto/tls.
+type channelConn struct {
+	io.ReadWriter
+}
+
+func (channelConn) Close() error {
+	return nil
+}
+func (channelConn) LocalAddr() net.Addr {
+	return channelAddr{}
+}
+func (channelConn) RemoteAddr() net.Addr {
+	return channelAddr{}
+}
+func (channelConn) SetDeadline(t time.Time) error {
+	return nil
+}
+func (channelConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+func (channelConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type channelAddr struct{}
+
+func (channelAddr) Network() string {
+	return "ssh"
+}
+func (channelAddr) String() string {
+	return "channel"
+}
+
+type tlsServer struct{}
+
+func (server tlsServer) serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext) {
+	conn := tls.Server(channelConn{readWriter}, &tls.Config{
+		Certificates: []tls.Certificate{context.cfg.tlsCertificate},
+	})
+	if err := conn.Handshake(); err != nil {
+		warningLogger.Printf("Error performing TLS handshake: %v", err)
+		return
+	}
+	httpServer{}.serve(conn, input, output, context)
+	if err := conn.CloseWrite(); err != nil {
+		warningLogger.Printf("Error closing TLS connection: %v", err)
 	}
 }
 
@@ -227,13 +418,39 @@ func (smtpServer) readData(reader io.Reader) (string, error) {
 	}
 }
 
-func (server smtpServer) serve(readWriter io.ReadWriter, input chan<- string) {
-	if err := server.writeReply(readWriter, smtpReply{220, "localhost"}); err != nil {
+// smtpEnvelope is the envelope of the mail transaction in progress.
+type smtpEnvelope struct {
+	from string
+	to   []string
+}
+
+func (envelope smtpEnvelope) String() string {
+	return fmt.Sprintf("MAIL FROM:%v\r\nRCPT TO:%v\r\n", envelope.from, strings.Join(envelope.to, ","))
+}
+
+// smtpPath extracts the path from the params of a MAIL or RCPT command, e.g. "FROM:<a@b>" or "FROM: <a@b>".
+func smtpPath(params []string, keyword string) (string, bool) {
+	joined := strings.Join(params, " ")
+	if len(joined) < len(keyword)+1 || !strings.EqualFold(joined[:len(keyword)+1], keyword+":") {
+		return "", false
+	}
+	path := strings.Fields(strings.TrimSpace(joined[len(keyword)+1:]))
+	if len(path) == 0 {
+		return "", false
+	}
+	return path[0], true
+}
+
+func (server smtpServer) serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext) {
+	hostname := context.cfg.Server.SMTP.Hostname
+	if err := server.writeReply(readWriter, smtpReply{220, fmt.Sprintf("%v ESMTP", hostname)}); err != nil {
 		warningLogger.Printf("Error writing greeting: %v", err)
 		return
 	}
+	reader := bufio.NewReader(readWriter)
+	var envelope *smtpEnvelope
 	for {
-		command, err := server.readCommand(readWriter)
+		command, err := server.readCommand(reader)
 		if err != nil {
 			warningLogger.Printf("Error reading command: %v", err)
 			return
@@ -242,21 +459,47 @@ func (server smtpServer) serve(readWriter io.ReadWriter, input chan<- string) {
 		reply := smtpReply{250, "OK"}
 		switch command.command {
 		case "HELO":
+			reply = smtpReply{250, hostname}
 		case "EHLO":
+			reply = smtpReply{250, fmt.Sprintf("%v\nPIPELINING\n8BITMIME", hostname)}
 		case "MAIL":
+			from, ok := smtpPath(command.params, "FROM")
+			if !ok {
+				reply = smtpReply{501, "Syntax: MAIL FROM:<address>"}
+				break
+			}
+			envelope = &smtpEnvelope{from: from}
 		case "RCPT":
+			to, ok := smtpPath(command.params, "TO")
+			if !ok {
+				reply = smtpReply{501, "Syntax: RCPT TO:<address>"}
+				break
+			}
+			if envelope == nil {
+				reply = smtpReply{503, "Error: need MAIL command"}
+				break
+			}
+			envelope.to = append(envelope.to, to)
 		case "RSET":
+			envelope = nil
+		case "NOOP":
 		case "DATA":
+			if envelope == nil || len(envelope.to) == 0 {
+				reply = smtpReply{503, "Error: need RCPT command"}
+				break
+			}
 			if err := server.writeReply(readWriter, smtpReply{354, "Start mail input; end with <CRLF>.<CRLF>"}); err != nil {
 				warningLogger.Printf("Error writing reply: %v", err)
 				return
 			}
-			data, err := server.readData(readWriter)
+			data, err := server.readData(reader)
 			if err != nil {
 				warningLogger.Printf("Error reading data: %v", err)
 				return
 			}
-			input <- data
+			input <- fmt.Sprintf("%v\r\n%v", envelope, data)
+			envelope = nil
+			reply = smtpReply{250, "OK: queued"}
 		case "QUIT":
 			reply = smtpReply{221, "Bye!"}
 		default:
@@ -339,7 +582,7 @@ func (pop3Server) readCommand(reader io.Reader) (pop3Command, error) {
 	return pop3Command{keyword, args}, nil
 }
 
-func (server pop3Server) serve(readWriter io.ReadWriter, input chan<- string) {
+func (server pop3Server) serve(readWriter io.ReadWriter, input, output chan<- string, context channelContext) {
 	if err := server.writeResponse(readWriter, pop3Response{true, "localhost", false}); err != nil {
 		warningLogger.Printf("Error writing greeting: %v", err)
 		return
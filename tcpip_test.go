@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTLSServer(t *testing.T) {
+	certificate, err := generateTLSCertificate("www.example.com", "Example Inc")
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	cfg := &config{tlsCertificate: certificate}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	input := make(chan string)
+	output := make(chan string, 1)
+	go func() {
+		defer close(input)
+		tlsServer{}.serve(serverConn, input, output, channelContext{connContext: connContext{cfg: cfg}})
+		serverConn.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	request, err := http.NewRequest("GET", "https://www.example.com/secret", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	go func() {
+		if err := request.Write(client); err != nil {
+			t.Errorf("Failed to write request: %v", err)
+		}
+	}()
+
+	select {
+	case data := <-input:
+		if !strings.HasPrefix(data, "GET /secret HTTP/1.1\r\n") {
+			t.Errorf("input=%q, want a GET /secret request", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for input")
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(client), request)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != 404 {
+		t.Errorf("StatusCode=%v, want 404", response.StatusCode)
+	}
+	peerCertificates := client.ConnectionState().PeerCertificates
+	if len(peerCertificates) != 1 {
+		t.Fatalf("len(PeerCertificates)=%v, want 1", len(peerCertificates))
+	}
+	subject := peerCertificates[0].Subject
+	if subject.CommonName != "www.example.com" {
+		t.Errorf("CommonName=%v, want www.example.com", subject.CommonName)
+	}
+	if len(subject.Organization) != 1 || subject.Organization[0] != "Example Inc" {
+		t.Errorf("Organization=%v, want [Example Inc]", subject.Organization)
+	}
+}
+
+func TestSMTPServer(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.SMTP.Hostname = "mail.example.com"
+	serverConn, clientConn := net.Pipe()
+	input := make(chan string)
+	go func() {
+		defer close(input)
+		smtpServer{}.serve(serverConn, input, nil, channelContext{connContext: connContext{cfg: cfg}})
+		serverConn.Close()
+	}()
+	var inputs []string
+	inputsDone := make(chan struct{})
+	go func() {
+		defer close(inputsDone)
+		for data := range input {
+			inputs = append(inputs, data)
+		}
+	}()
+
+	client, err := smtp.NewClient(clientConn, "mail.example.com")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if err := client.Hello("relay.example.org"); err != nil {
+		t.Fatalf("Failed to send EHLO: %v", err)
+	}
+	if err := client.Mail("spammer@example.org"); err != nil {
+		t.Fatalf("Failed to send MAIL: %v", err)
+	}
+	for _, rcpt := range []string{"victim1@example.net", "victim2@example.net"} {
+		if err := client.Rcpt(rcpt); err != nil {
+			t.Fatalf("Failed to send RCPT: %v", err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		t.Fatalf("Failed to send DATA: %v", err)
+	}
+	if _, err := writer.Write([]byte("Subject: offer\r\n\r\nBuy now!\r\n")); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to finish data: %v", err)
+	}
+	if err := client.Quit(); err != nil {
+		t.Fatalf("Failed to send QUIT: %v", err)
+	}
+	<-inputsDone
+
+	expectedInputs := []string{
+		"EHLO relay.example.org",
+		"MAIL FROM:<spammer@example.org> BODY=8BITMIME",
+		"RCPT TO:<victim1@example.net>",
+		"RCPT TO:<victim2@example.net>",
+		"DATA",
+		"MAIL FROM:<spammer@example.org>\r\nRCPT TO:<victim1@example.net>,<victim2@example.net>\r\n\r\nSubject: offer\r\n\r\nBuy now!\r\n.\r\n",
+		"QUIT",
+	}
+	if !reflect.DeepEqual(inputs, expectedInputs) {
+		t.Errorf("inputs=%q, want %q", inputs, expectedInputs)
+	}
+}
+
+func TestHTTPServerOutput(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	input := make(chan string)
+	output := make(chan string)
+	go func() {
+		defer close(input)
+		defer close(output)
+		httpServer{}.serve(serverConn, input, output, channelContext{connContext: connContext{cfg: &config{}}})
+		serverConn.Close()
+	}()
+	go func() {
+		if _, err := clientConn.Write([]byte("GET /admin HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Errorf("Failed to write request: %v", err)
+		}
+		if _, err := http.ReadResponse(bufio.NewReader(clientConn), nil); err != nil {
+			t.Errorf("Failed to read response: %v", err)
+		}
+	}()
+
+	expectedInput := "GET /admin HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	select {
+	case data := <-input:
+		if data != expectedInput {
+			t.Errorf("input=%q, want %q", data, expectedInput)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for input")
+	}
+	expectedOutput := "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	select {
+	case data := <-output:
+		if data != expectedOutput {
+			t.Errorf("output=%q, want %q", data, expectedOutput)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for output")
+	}
+}
+
+func TestHTTPServerMaxRequests(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.HTTP.MaxRequests = 2
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	input := make(chan string, 3)
+	output := make(chan string, 3)
+	go func() {
+		httpServer{}.serve(serverConn, input, output, channelContext{connContext: connContext{cfg: cfg}})
+		serverConn.Close()
+	}()
+
+	reader := bufio.NewReader(clientConn)
+	for i := 0; i < 2; i++ {
+		if _, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		if _, err := http.ReadResponse(reader, nil); err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+	}
+	if _, err := clientConn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err == nil {
+		t.Errorf("Third request was accepted, want the channel to be closed")
+	}
+	if len(input) != 2 || len(output) != 2 {
+		t.Errorf("len(input)=%v, len(output)=%v, want 2, 2", len(input), len(output))
+	}
+}
+
+func TestHTTPServerReadTimeout(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.HTTP.ReadTimeout = 10 * time.Millisecond
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		httpServer{}.serve(serverConn, nil, nil, channelContext{connContext: connContext{cfg: cfg}})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the server to give up")
+	}
+}
+
+type mockChannel struct {
+	net.Conn
+}
+
+func (channel mockChannel) CloseWrite() error {
+	return nil
+}
+
+func (channel mockChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return false, nil
+}
+
+func (channel mockChannel) Stderr() io.ReadWriter {
+	return nil
+}
+
+type mockNewChannel struct {
+	channel   ssh.Channel
+	extraData []byte
+}
+
+func (newChannel mockNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	return newChannel.channel, nil, nil
+}
+
+func (newChannel mockNewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	return nil
+}
+
+func (newChannel mockNewChannel) ChannelType() string {
+	return "direct-tcpip"
+}
+
+func (newChannel mockNewChannel) ExtraData() []byte {
+	return newChannel.extraData
+}
+
+func TestDirectTCPIPForwardLogs(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.TCPIPServices = map[uint32]string{80: "HTTP"}
+	cfg.Server.HTTP.MaxRequests = 1
+	if err := cfg.setupTCPIPServers(); err != nil {
+		t.Fatalf("Failed to setup TCP/IP servers: %v", err)
+	}
+	logs := setupLogBuffer(t, cfg)
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	newChannel := mockNewChannel{
+		channel: mockChannel{serverConn},
+		extraData: ssh.Marshal(tcpipChannelData{
+			Address:           "127.0.0.1",
+			Port:              80,
+			OriginatorAddress: "127.0.0.1",
+			OriginatorPort:    4321,
+		}),
+	}
+	context := channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}, channelID: 3, forwardIndex: 1}
+	result := make(chan error)
+	go func() {
+		result <- handleDirectTCPIPChannel(newChannel, context)
+	}()
+
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	if _, err := clientConn.Write([]byte(request)); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+	if _, err := http.ReadResponse(bufio.NewReader(clientConn), nil); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	serverConn.Close()
+	if err := <-result; err != nil {
+		t.Fatalf("Failed to handle channel: %v", err)
+	}
+
+	expectedLogs := `[127.0.0.1:1234] [channel 3] direct TCP/IP forwarding #1 of connection 736f6d6573657373 from 127.0.0.1:4321 to 127.0.0.1:80 requested
+[127.0.0.1:1234] [channel 3] input: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+[127.0.0.1:1234] [channel 3] output: "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+[127.0.0.1:1234] [channel 3] closed (HTTP, 1 requests, 37 bytes received, 45 bytes sent)
+`
+	if logs.String() != expectedLogs {
+		t.Errorf("logs=%v, want %v", logs.String(), expectedLogs)
+	}
+}
+
+func TestDirectTCPIPChannelHandler(t *testing.T) {
+	cfg := &config{}
+	cfg.Server.TCPIPServices = map[uint32]string{80: "HTTP"}
+	if err := cfg.setupTCPIPServers(); err != nil {
+		t.Fatalf("Failed to setup TCP/IP servers: %v", err)
+	}
+	logs := setupLogBuffer(t, cfg)
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	newChannel := mockNewChannel{
+		channel:   mockChannel{serverConn},
+		extraData: ssh.Marshal(tcpipChannelData{"example.com", 80, "127.0.0.1", 4321}),
+	}
+	result := make(chan error)
+	go func() {
+		result <- channelHandlers["direct-tcpip"](newChannel, channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}})
+	}()
+
+	reader := bufio.NewReader(clientConn)
+	for _, path := range []string{"/", "/admin"} {
+		if _, err := clientConn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		if _, err := http.ReadResponse(reader, nil); err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+	}
+	clientConn.Close()
+	if err := <-result; err != nil {
+		t.Fatalf("Failed to handle channel: %v", err)
+	}
+
+	for _, want := range []string{
+		`[channel 0] input: "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"`,
+		`[channel 0] input: "GET /admin HTTP/1.1\r\nHost: example.com\r\n\r\n"`,
+		`[channel 0] closed (HTTP, 2 requests, `,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %v", logs.String(), want)
+		}
+	}
+}
+
+func TestDirectTCPIPProxy(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	cfg := &config{}
+	cfg.Server.TCPIPProxy.Backends = map[uint32]string{3306: backend.Addr().String()}
+	if err := cfg.setupTCPIPServers(); err != nil {
+		t.Fatalf("Failed to setup TCP/IP servers: %v", err)
+	}
+	logs := setupLogBuffer(t, cfg)
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	newChannel := mockNewChannel{
+		channel:   mockChannel{serverConn},
+		extraData: ssh.Marshal(tcpipChannelData{"db.internal", 3306, "127.0.0.1", 4321}),
+	}
+	result := make(chan error)
+	go func() {
+		result <- channelHandlers["direct-tcpip"](newChannel, channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}})
+	}()
+
+	if _, err := clientConn.Write([]byte("SELECT 1;\n")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	echo := make([]byte, len("SELECT 1;\n"))
+	if _, err := io.ReadFull(clientConn, echo); err != nil || string(echo) != "SELECT 1;\n" {
+		t.Fatalf("echo=%q, err=%v, want the input echoed by the backend", echo, err)
+	}
+	clientConn.Close()
+	if err := <-result; err != nil {
+		t.Fatalf("Failed to handle channel: %v", err)
+	}
+
+	for _, want := range []string{
+		`[channel 0] input: "SELECT 1;\n"`,
+		`[channel 0] output: "SELECT 1;\n"`,
+		`[channel 0] closed (proxy, 1 requests, 10 bytes received, 10 bytes sent)`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs=%v, want them to contain %v", logs.String(), want)
+		}
+	}
+}
+
+func TestForwardedTCPIPOriginatorLogs(t *testing.T) {
+	for _, tt := range []struct {
+		originator       string
+		expectedLogEvent string
+	}{
+		{"203.0.113.5", `{"channel_id":0,"connected":"0.0.0.0:8080","originator":"203.0.113.5:5555","originator_ip":"203.0.113.5","originator_port":5555}`},
+		{"[2001:DB8:0::5]", `{"channel_id":0,"connected":"0.0.0.0:8080","originator":"[2001:db8::5]:5555","originator_ip":"2001:db8::5","originator_port":5555}`},
+		{"example.com", `{"channel_id":0,"connected":"0.0.0.0:8080","originator":"example.com:5555","originator_port":5555}`},
+	} {
+		cfg := &config{}
+		cfg.Logging.JSON = true
+		logs := setupLogBuffer(t, cfg)
+		serverConn, clientConn := net.Pipe()
+		newChannel := mockNewChannel{
+			channel:   mockChannel{serverConn},
+			extraData: ssh.Marshal(tcpipChannelData{"0.0.0.0", 8080, tt.originator, 5555}),
+		}
+		clientConn.Close()
+		if err := handleForwardedTCPIPChannel(newChannel, channelContext{connContext: connContext{ConnMetadata: mockConnContext{}, cfg: cfg}}); err != nil {
+			t.Fatalf("Failed to handle channel: %v", err)
+		}
+		expectedLogs := `{"source":"127.0.0.1:1234","event_type":"forwarded_tcpip","event":` + tt.expectedLogEvent + "}\n"
+		if logs.String() != expectedLogs {
+			t.Errorf("%v: logs=%v, want %v", tt.originator, logs.String(), expectedLogs)
+		}
+	}
+}
+
+func TestTCPIPChannelData(t *testing.T) {
+	data := &tcpipChannelData{}
+	if err := ssh.Unmarshal(ssh.Marshal(tcpipChannelData{"::1", 80, "127.0.0.1", 4321}), data); err != nil {
+		t.Fatalf("Failed to unmarshal channel data: %v", err)
+	}
+	if *data != (tcpipChannelData{"::1", 80, "127.0.0.1", 4321}) {
+		t.Errorf("data=%+v, want the marshaled data", *data)
+	}
+	if data.String() != "127.0.0.1:4321 -> [::1]:80" {
+		t.Errorf("String()=%q, want %q", data.String(), "127.0.0.1:4321 -> [::1]:80")
+	}
+}
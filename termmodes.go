@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// terminalModeNames are the names of the terminal mode opcodes defined in RFC 4254 and RFC 8160.
+var terminalModeNames = map[byte]string{
+	1: "VINTR", 2: "VQUIT", 3: "VERASE", 4: "VKILL", 5: "VEOF", 6: "VEOL", 7: "VEOL2", 8: "VSTART", 9: "VSTOP",
+	10: "VSUSP", 11: "VDSUSP", 12: "VREPRINT", 13: "VWERASE", 14: "VLNEXT", 15: "VFLUSH", 16: "VSWTCH", 17: "VSTATUS", 18: "VDISCARD",
+	30: "IGNPAR", 31: "PARMRK", 32: "INPCK", 33: "ISTRIP", 34: "INLCR", 35: "IGNCR", 36: "ICRNL", 37: "IUCLC", 38: "IXON",
+	39: "IXANY", 40: "IXOFF", 41: "IMAXBEL", 42: "IUTF8",
+	50: "ISIG", 51: "ICANON", 52: "XCASE", 53: "ECHO", 54: "ECHOE", 55: "ECHOK", 56: "ECHONL", 57: "NOFLSH", 58: "TOSTOP",
+	59: "IEXTEN", 60: "ECHOCTL", 61: "ECHOKE", 62: "PENDIN",
+	70: "OPOST", 71: "OLCUC", 72: "ONLCR", 73: "OCRNL", 74: "ONOCR", 75: "ONLRET",
+	90: "CS7", 91: "CS8", 92: "PARENB", 93: "PARODD",
+	128: "TTY_OP_ISPEED", 129: "TTY_OP_OSPEED",
+}
+
+// notableTerminalModes are the modes worth showing in the plain text logs, as they differ between clients.
+var notableTerminalModes = []string{"ECHO", "ICANON", "ONLCR", "IUTF8", "TTY_OP_OSPEED"}
+
+// parseTerminalModes decodes the encoded terminal modes of a pty-req.
+// Unknown opcodes are named by number, parsing stops at TTY_OP_END or an opcode without a defined argument.
+func parseTerminalModes(modes string) (map[string]uint32, error) {
+	parsed := map[string]uint32{}
+	for len(modes) > 0 {
+		opcode := modes[0]
+		if opcode == 0 || opcode >= 160 {
+			break
+		}
+		if len(modes) < 5 {
+			return parsed, fmt.Errorf("truncated terminal mode %v", opcode)
+		}
+		name, ok := terminalModeNames[opcode]
+		if !ok {
+			name = fmt.Sprint(opcode)
+		}
+		parsed[name] = binary.BigEndian.Uint32([]byte(modes[1:5]))
+		modes = modes[5:]
+	}
+	return parsed, nil
+}
+
+// formatNotableTerminalModes formats the notable modes that are set, such as "ECHO=1 ONLCR=1".
+func formatNotableTerminalModes(modes map[string]uint32) string {
+	var formatted []string
+	for _, name := range notableTerminalModes {
+		if value, ok := modes[name]; ok {
+			formatted = append(formatted, fmt.Sprintf("%v=%v", name, value))
+		}
+	}
+	return strings.Join(formatted, " ")
+}
@@ -80,3 +80,16 @@ func writeTestKeys(t *testing.T, dataDir string) {
 		}
 	}
 }
+
+// setupTestSSHConfig sets up cfg to accept SSH clients without authentication.
+func setupTestSSHConfig(t *testing.T, cfg *config) {
+	t.Helper()
+	cfg.Server.HostKeys = []string{filepath.Join(t.TempDir(), "host_ecdsa_key")}
+	if err := os.WriteFile(cfg.Server.HostKeys[0], []byte(testECDSAKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth.NoAuth = true
+	if err := cfg.setupSSHConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// transcript records the input and output of a session as an asciicast v2 file, replayable with asciinema.
+type transcript struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	start   time.Time
+}
+
+func newTranscript(context channelContext, width, height uint32) (*transcript, error) {
+	start := time.Now()
+	name := fmt.Sprintf("%v-%v-%v.cast", start.UTC().Format("20060102T150405Z"), context.connectionID(), context.channelID)
+	file, err := os.OpenFile(filepath.Join(context.cfg.Session.TranscriptDirectory, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	result := &transcript{file: file, encoder: json.NewEncoder(file), start: start}
+	if err := result.encoder.Encode(struct {
+		Version   int    `json:"version"`
+		Width     uint32 `json:"width"`
+		Height    uint32 `json:"height"`
+		Timestamp int64  `json:"timestamp"`
+	}{2, width, height, start.Unix()}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+// event records data read from ("i") or written to ("o") the client.
+func (transcript *transcript) event(eventType string, data string) {
+	transcript.mutex.Lock()
+	defer transcript.mutex.Unlock()
+	elapsed := float64(time.Since(transcript.start).Microseconds()) / 1e6
+	if err := transcript.encoder.Encode([]interface{}{elapsed, eventType, data}); err != nil {
+		warningLogger.Printf("Failed to write transcript: %v", err)
+	}
+}
+
+// input records a line read from the client, if transcript is not nil.
+func (transcript *transcript) input(line string) {
+	if transcript != nil {
+		transcript.event("i", line+"\n")
+	}
+}
+
+// writer returns a writer that records what's written to w as output.
+func (transcript *transcript) writer(w io.Writer) io.Writer {
+	return transcriptWriter{transcript, w}
+}
+
+// flush commits the transcript to disk, if transcript is not nil.
+func (transcript *transcript) flush() {
+	if transcript == nil {
+		return
+	}
+	transcript.mutex.Lock()
+	defer transcript.mutex.Unlock()
+	if err := transcript.file.Sync(); err != nil {
+		warningLogger.Printf("Failed to flush transcript: %v", err)
+	}
+}
+
+func (transcript *transcript) Close() error {
+	transcript.mutex.Lock()
+	defer transcript.mutex.Unlock()
+	return transcript.file.Close()
+}
+
+type transcriptWriter struct {
+	transcript *transcript
+	io.Writer
+}
+
+func (w transcriptWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		w.transcript.event("o", string(p[:n]))
+	}
+	return n, err
+}
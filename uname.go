@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var defaultDistro = distroConfig{
+	ID:            "ubuntu",
+	IDLike:        "debian",
+	Name:          "Ubuntu",
+	PrettyName:    "Ubuntu 22.04.3 LTS",
+	Version:       "22.04.3 LTS (Jammy Jellyfish)",
+	VersionID:     "22.04",
+	Codename:      "jammy",
+	KernelRelease: "5.15.0-91-generic",
+	KernelVersion: "#101-Ubuntu SMP Tue Nov 14 13:30:08 UTC 2023",
+}
+
+// osRelease formats the distribution identification the way /etc/os-release does, leaving out empty fields.
+func osRelease(cfg distroConfig) string {
+	var result strings.Builder
+	for _, field := range []struct {
+		name, value string
+		quoted      bool
+	}{
+		{"PRETTY_NAME", cfg.PrettyName, true},
+		{"NAME", cfg.Name, true},
+		{"VERSION_ID", cfg.VersionID, true},
+		{"VERSION", cfg.Version, true},
+		{"VERSION_CODENAME", cfg.Codename, false},
+		{"ID", cfg.ID, false},
+		{"ID_LIKE", cfg.IDLike, false},
+	} {
+		switch {
+		case field.value == "":
+		case field.quoted:
+			fmt.Fprintf(&result, "%v=%q\n", field.name, field.value)
+		default:
+			fmt.Fprintf(&result, "%v=%v\n", field.name, field.value)
+		}
+	}
+	return result.String()
+}
+
+// hostname returns the hostname of the session, as configured or last set with the hostname command.
+func hostname(context commandContext) string {
+	if context.state.hostname != "" {
+		return context.state.hostname
+	}
+	return context.cfg.Shell.Hostname
+}
+
+type cmdHostname struct{}
+
+func (cmdHostname) execute(context commandContext) (uint32, error) {
+	name := hostname(context)
+	for _, arg := range context.args[1:] {
+		switch {
+		case arg == "-s" || arg == "--short":
+			name, _, _ = strings.Cut(name, ".")
+		case arg == "-f" || arg == "--fqdn" || arg == "--long":
+		case arg == "-i" || arg == "-I":
+			name = "10.132.0.4"
+		case strings.HasPrefix(arg, "-"):
+			_, err := fmt.Fprintf(context.stderr, "hostname: invalid option -- '%v'\nTry 'hostname --help' for more information.\n", strings.TrimLeft(arg, "-"))
+			return 1, err
+		default:
+			if context.user != "root" {
+				_, err := fmt.Fprintln(context.stderr, "hostname: you must be root to change the host name")
+				return 1, err
+			}
+			context.state.hostname = arg
+			return 0, nil
+		}
+	}
+	_, err := fmt.Fprintln(context.stdout, name)
+	return 0, err
+}
+
+type cmdUname struct{}
+
+func (cmdUname) execute(context commandContext) (uint32, error) {
+	fields := []struct {
+		flag  byte
+		value string
+	}{
+		{'s', "Linux"},
+		{'n', hostname(context)},
+		{'r', context.cfg.Shell.Distro.KernelRelease},
+		{'v', context.cfg.Shell.Distro.KernelVersion},
+		{'m', "x86_64"},
+		{'p', "x86_64"},
+		{'i', "x86_64"},
+		{'o', "GNU/Linux"},
+	}
+	selected := map[byte]bool{}
+	for _, arg := range context.args[1:] {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			_, err := fmt.Fprintf(context.stderr, "uname: extra operand '%v'\nTry 'uname --help' for more information.\n", arg)
+			return 1, err
+		}
+		for _, flag := range []byte(arg[1:]) {
+			if !strings.ContainsRune("asnrvmpio", rune(flag)) {
+				_, err := fmt.Fprintf(context.stderr, "uname: invalid option -- '%c'\nTry 'uname --help' for more information.\n", flag)
+				return 1, err
+			}
+			selected[flag] = true
+		}
+	}
+	if len(selected) == 0 {
+		selected['s'] = true
+	}
+	var values []string
+	for _, field := range fields {
+		if selected[field.flag] || selected['a'] {
+			values = append(values, field.value)
+		}
+	}
+	_, err := fmt.Fprintln(context.stdout, strings.Join(values, " "))
+	return 0, err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultYesMaxLines is the number of lines yes writes if the config doesn't limit it.
+const defaultYesMaxLines = 10000
+
+func init() {
+	registerCommand("yes", cmdYes{})
+}
+
+type cmdYes struct{}
+
+func (cmdYes) execute(context commandContext) (uint32, error) {
+	line := "y"
+	if len(context.args) > 1 {
+		line = strings.Join(context.args[1:], " ")
+	}
+	maxLines := context.cfg.Shell.YesMaxLines
+	if maxLines <= 0 {
+		maxLines = defaultYesMaxLines
+	}
+	// The real yes runs until killed, stop after enough lines to look like the output was interrupted.
+	for i := 0; i < maxLines; i++ {
+		if _, err := fmt.Fprintln(context.stdout, line); err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}